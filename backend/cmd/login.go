@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/config"
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex"
+)
+
+// pinCheckInterval is how often we check whether
+// the PIN's code has been entered.
+const pinCheckInterval = 2 * time.Second
+
+// login signs in to the Plex account that owns a server with a
+// PIN, and saves the token where the server's config reads it.
+func login(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	name := flags.String("server", "", "name of the Plex server to sign in to, the first configured server by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if len(cfg.Plex) == 0 {
+		return fmt.Errorf("no Plex servers configured")
+	}
+	server := cfg.Plex[0]
+	if *name != "" {
+		var ok bool
+		server, ok = cfg.PlexServer(*name)
+		if !ok {
+			return fmt.Errorf("no Plex server named %q", *name)
+		}
+	}
+
+	pins := plex.NewPinClient(
+		plex.WithPlexTVURL(cfg.PlexTVURL),
+		plex.WithPinClientIdentifier(server.ClientIdentifier),
+	)
+	pin, err := pins.RequestPin(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("To sign in to Plex for %s, go to %s and enter the code %s\n", server.Name, plex.LinkURL, pin.Code)
+
+	token, err := pins.WaitForToken(ctx, pin, pinCheckInterval)
+	if err != nil {
+		return err
+	}
+	if err := config.SaveToken(server.TokenFile, token); err != nil {
+		return err
+	}
+	fmt.Printf("Signed in. The token for %s is saved to %s\n", server.Name, server.TokenFile)
+	return nil
+}
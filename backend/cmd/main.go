@@ -14,6 +14,15 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "login" {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err := login(ctx, config.LoadConfig(), os.Args[2:]); err != nil {
+			log.Fatalf("could not sign in to Plex: %v", err)
+		}
+		return
+	}
+
 	log.Println("Hello!")
 	defer log.Println("Good bye!")
 
@@ -4,21 +4,95 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/joho/godotenv"
 )
 
+// DefaultPlexServer is the name of the Plex server configured
+// by the unprefixed PLEX_ variables when PLEX_SERVERS is not set.
+const DefaultPlexServer = "default"
+
+// PlexServer is how to connect to one Plex server.
+type PlexServer struct {
+	// Name identifies the server in routes and in stored data.
+	Name  string
+	Token string
+	// TokenFile is where the token is saved when signing in
+	// with a PIN. It's read when Token isn't set.
+	TokenFile string
+	// Address is the Plex host, or a full base URL such as
+	// https://plex.example.com for servers behind a proxy,
+	// on another port or requiring secure connections.
+	Address               string
+	DefaultLibrarySection string
+	// LibrarySections limits ingestion to these section IDs.
+	// Every movie and show section is ingested when empty.
+	LibrarySections []string
+	// CAFile is a PEM bundle of extra certificate
+	// authorities to trust when connecting over HTTPS.
+	CAFile string
+	// TLSServerName verifies the server's certificate against
+	// this name, such as its plex.direct hostname, instead of
+	// the host in Address.
+	TLSServerName      string
+	InsecureSkipVerify bool
+	// ClientIdentifier identifies this deployment to Plex
+	ClientIdentifier string
+}
+
+// Weaviate is how to connect to the Weaviate vector store.
+type Weaviate struct {
+	// Host is Weaviate's host and port, e.g. weaviate:8080.
+	Host string
+	// Scheme is http or https.
+	Scheme string
+	// APIKey authenticates with Weaviate when set.
+	APIKey string
+	// Headers are added to every request, such as the
+	// API keys of the modules Weaviate calls out to.
+	Headers map[string]string
+	// GRPCPort is the port Weaviate serves gRPC on, on the
+	// same host. gRPC isn't used when it's empty.
+	GRPCPort string
+	// StartupTimeout is how long to wait for Weaviate
+	// to be ready when connecting to it.
+	StartupTimeout time.Duration
+	// Timeout limits how long each request can take.
+	// Requests aren't limited when it's zero.
+	Timeout time.Duration
+}
+
+// Ingest is how media is embedded and stored when it's ingested.
+// A ChunkSize or Workers left at zero, or Retries below zero,
+// uses the default.
+type Ingest struct {
+	// ChunkSize is how many items are embedded
+	// and stored together.
+	ChunkSize int
+	// Workers is how many chunks are embedded at once.
+	Workers int
+	// Retries is how many more times a chunk that
+	// fails to store is tried. Zero never retries.
+	Retries int
+}
+
 type Config struct {
-	Plex struct {
-		Token                 string
-		Address               string
-		DefaultLibrarySection string
-	}
-	Ollama struct {
+	// Plex is every Plex server recommendations are made
+	// for. The first one is the default server.
+	Plex []PlexServer
+	// PlexTVURL is where Plex's account service is
+	// hosted, for signing in. Defaults to plex.tv.
+	PlexTVURL string
+	Ollama    struct {
 		Address        string
 		LanguageModel  string
 		EmbeddingModel string
 	}
+	Weaviate Weaviate
+	Ingest   Ingest
 	Postgres struct {
 		Host     string
 		Username string
@@ -26,7 +100,47 @@ type Config struct {
 		DBName   string
 		Port     int
 	}
+	Admin struct {
+		// Secret must be sent as a bearer token to sign in to
+		// Plex or sync libraries over HTTP. Those routes are
+		// refused when it's empty.
+		Secret string
+	}
+	Webhooks struct {
+		// Secret must be passed as the token query parameter
+		// of every webhook. Webhooks are refused when it's empty.
+		Secret string
+		// Pregenerate generates a user's next recommendation
+		// in the background when Plex tells us they finished
+		// watching something.
+		Pregenerate bool
+	}
+	// VectorQuery tunes the search for media similar to the
+	// watch history. Anything left at zero uses Weaviate's default.
+	VectorQuery struct {
+		// Limit is how many similar titles are found.
+		Limit int
+		// MaxDistance leaves out titles further than
+		// this from the watch history.
+		MaxDistance float32
+		// Autocut cuts the titles found off after this many
+		// jumps in distance, keeping only the closest groups.
+		Autocut int
+		// Fallback searches again without MaxDistance and
+		// Autocut when they leave no titles, instead of
+		// failing the recommendation.
+		Fallback bool
+	}
+	Sync struct {
+		// Interval is how often Plex libraries are checked for
+		// changes and synced. They're only synced on start up
+		// when it's zero.
+		Interval time.Duration
+	}
 	RecentMovieCount int
+	// MaxHistorySize is how much watch history is used by
+	// default when a recommendation asks for a time window.
+	MaxHistorySize int
 }
 
 // loadEnv loads environment variables from a .env file.
@@ -34,6 +148,16 @@ func loadEnv() error {
 	return godotenv.Load(".env")
 }
 
+// PlexServer returns the Plex server with the provided name.
+func (c *Config) PlexServer(name string) (PlexServer, bool) {
+	for _, server := range c.Plex {
+		if server.Name == name {
+			return server, true
+		}
+	}
+	return PlexServer{}, false
+}
+
 // LoadConfig creates a Config struct based on current environment
 func LoadConfig() *Config {
 	// TODO: this is getting out of hand. Implement https://github.com/caarlos0/env
@@ -42,18 +166,15 @@ func LoadConfig() *Config {
 	}
 
 	var cfg Config
-	if os.Getenv("PLEX_TOKEN") != "" {
-		cfg.Plex.Token = os.Getenv("PLEX_TOKEN")
+	names := []string{DefaultPlexServer}
+	if os.Getenv("PLEX_SERVERS") != "" {
+		names = splitList(os.Getenv("PLEX_SERVERS"))
 	}
-	if os.Getenv("PLEX_ADDRESS") != "" {
-		cfg.Plex.Address = os.Getenv("PLEX_ADDRESS")
+	for i, name := range names {
+		cfg.Plex = append(cfg.Plex, loadPlexServer(name, i == 0))
 	}
-
-	// My movies library is at section 3, so I have the default set to that if
-	// not provided via environment.
-	cfg.Plex.DefaultLibrarySection = "3"
-	if os.Getenv("PLEX_DEFAULT_LIBRARY_SECTION") != "" {
-		cfg.Plex.DefaultLibrarySection = os.Getenv("PLEX_DEFAULT_LIBRARY_SECTION")
+	if os.Getenv("PLEX_TV_URL") != "" {
+		cfg.PlexTVURL = os.Getenv("PLEX_TV_URL")
 	}
 	if os.Getenv("OLLAMA_ADDRESS") != "" {
 		cfg.Ollama.Address = os.Getenv("OLLAMA_ADDRESS")
@@ -65,6 +186,9 @@ func LoadConfig() *Config {
 		cfg.Ollama.EmbeddingModel = os.Getenv("OLLAMA_EMBEDDING_MODEL")
 	}
 
+	cfg.Weaviate = loadWeaviate()
+	cfg.Ingest = loadIngest()
+
 	// Postgres values are defaulted to these initial values
 	// but overriden by environment
 	cfg.Postgres.Host = "postgres"
@@ -95,6 +219,58 @@ func LoadConfig() *Config {
 		cfg.Postgres.DBName = os.Getenv("POSTGRES_DB")
 	}
 
+	cfg.Admin.Secret = os.Getenv("ADMIN_SECRET")
+	cfg.Webhooks.Secret = os.Getenv("WEBHOOK_SECRET")
+	if os.Getenv("WEBHOOK_PREGENERATE") != "" {
+		pregenerate, err := strconv.ParseBool(os.Getenv("WEBHOOK_PREGENERATE"))
+		if err != nil {
+			log.Println("WEBHOOK_PREGENERATE set but to non-bool value")
+		}
+		cfg.Webhooks.Pregenerate = pregenerate
+	}
+
+	if os.Getenv("VECTOR_QUERY_LIMIT") != "" {
+		limit, err := strconv.Atoi(os.Getenv("VECTOR_QUERY_LIMIT"))
+		if err != nil {
+			log.Println("VECTOR_QUERY_LIMIT set but to non-int value")
+		} else {
+			cfg.VectorQuery.Limit = limit
+		}
+	}
+	if os.Getenv("VECTOR_QUERY_MAX_DISTANCE") != "" {
+		maxDistance, err := strconv.ParseFloat(os.Getenv("VECTOR_QUERY_MAX_DISTANCE"), 32)
+		if err != nil {
+			log.Println("VECTOR_QUERY_MAX_DISTANCE set but to non-float value")
+		} else {
+			cfg.VectorQuery.MaxDistance = float32(maxDistance)
+		}
+	}
+	if os.Getenv("VECTOR_QUERY_AUTOCUT") != "" {
+		autocut, err := strconv.Atoi(os.Getenv("VECTOR_QUERY_AUTOCUT"))
+		if err != nil {
+			log.Println("VECTOR_QUERY_AUTOCUT set but to non-int value")
+		} else {
+			cfg.VectorQuery.Autocut = autocut
+		}
+	}
+	if os.Getenv("VECTOR_QUERY_FALLBACK") != "" {
+		fallback, err := strconv.ParseBool(os.Getenv("VECTOR_QUERY_FALLBACK"))
+		if err != nil {
+			log.Println("VECTOR_QUERY_FALLBACK set but to non-bool value")
+		}
+		cfg.VectorQuery.Fallback = fallback
+	}
+
+	cfg.Sync.Interval = time.Hour
+	if os.Getenv("SYNC_INTERVAL") != "" {
+		interval, err := time.ParseDuration(os.Getenv("SYNC_INTERVAL"))
+		if err != nil {
+			log.Println("SYNC_INTERVAL set but to non-duration value")
+		} else {
+			cfg.Sync.Interval = interval
+		}
+	}
+
 	recentMovieCountStr := os.Getenv("RECENT_MOVIE_COUNT")
 	count, err := strconv.Atoi(recentMovieCountStr)
 	if recentMovieCountStr == "" || err != nil {
@@ -102,5 +278,172 @@ func LoadConfig() *Config {
 	} else {
 		cfg.RecentMovieCount = count
 	}
+
+	maxHistorySizeStr := os.Getenv("MAX_HISTORY_SIZE")
+	maxHistorySize, err := strconv.Atoi(maxHistorySizeStr)
+	if maxHistorySizeStr == "" || err != nil {
+		cfg.MaxHistorySize = 50
+	} else {
+		cfg.MaxHistorySize = maxHistorySize
+	}
 	return &cfg
 }
+
+// loadWeaviate reads the Weaviate connection settings,
+// which default to the Weaviate in our docker compose.
+func loadWeaviate() Weaviate {
+	w := Weaviate{
+		Host:     "weaviate:8080",
+		Scheme:   "http",
+		APIKey:   os.Getenv("WEAVIATE_API_KEY"),
+		GRPCPort: os.Getenv("WEAVIATE_GRPC_PORT"),
+	}
+	if os.Getenv("WEAVIATE_HOST") != "" {
+		w.Host = os.Getenv("WEAVIATE_HOST")
+	}
+	if os.Getenv("WEAVIATE_SCHEME") != "" {
+		w.Scheme = os.Getenv("WEAVIATE_SCHEME")
+	}
+	// headers are a comma separated list of Name=value pairs
+	for _, header := range splitList(os.Getenv("WEAVIATE_HEADERS")) {
+		name, value, ok := strings.Cut(header, "=")
+		if !ok {
+			log.Printf("WEAVIATE_HEADERS entry %q is not a Name=value pair\n", header)
+			continue
+		}
+		if w.Headers == nil {
+			w.Headers = make(map[string]string)
+		}
+		w.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	if os.Getenv("WEAVIATE_STARTUP_TIMEOUT") != "" {
+		timeout, err := time.ParseDuration(os.Getenv("WEAVIATE_STARTUP_TIMEOUT"))
+		if err != nil {
+			log.Println("WEAVIATE_STARTUP_TIMEOUT set but to non-duration value")
+		}
+		w.StartupTimeout = timeout
+	}
+	if os.Getenv("WEAVIATE_TIMEOUT") != "" {
+		timeout, err := time.ParseDuration(os.Getenv("WEAVIATE_TIMEOUT"))
+		if err != nil {
+			log.Println("WEAVIATE_TIMEOUT set but to non-duration value")
+		}
+		w.Timeout = timeout
+	}
+	return w
+}
+
+// loadIngest reads how media is embedded and stored.
+func loadIngest() Ingest {
+	ingest := Ingest{Retries: -1}
+	for key, setting := range map[string]*int{
+		"INGEST_CHUNK_SIZE": &ingest.ChunkSize,
+		"INGEST_WORKERS":    &ingest.Workers,
+		"INGEST_RETRIES":    &ingest.Retries,
+	} {
+		if os.Getenv(key) == "" {
+			continue
+		}
+		value, err := strconv.Atoi(os.Getenv(key))
+		if err != nil {
+			log.Printf("%s set but to non-int value\n", key)
+			continue
+		}
+		*setting = value
+	}
+	return ingest
+}
+
+// loadPlexServer reads a Plex server's settings. The default server
+// reads the PLEX_ variables, and any other server reads its own,
+// such as PLEX_CABIN_TOKEN for a server named cabin, falling back
+// to the PLEX_ variable for anything it doesn't set. The token is
+// the exception: a named server's own variable comes first, then
+// its token file, and PLEX_TOKEN is only used by the first server,
+// so another server never signs in with the first one's token.
+func loadPlexServer(name string, first bool) PlexServer {
+	getenv := func(key string) string {
+		if name != DefaultPlexServer {
+			if value := os.Getenv("PLEX_" + envName(name) + "_" + key); value != "" {
+				return value
+			}
+		}
+		return os.Getenv("PLEX_" + key)
+	}
+
+	server := PlexServer{
+		Name:             name,
+		Address:          getenv("ADDRESS"),
+		CAFile:           getenv("CA_FILE"),
+		TLSServerName:    getenv("TLS_SERVER_NAME"),
+		ClientIdentifier: getenv("CLIENT_IDENTIFIER"),
+	}
+
+	// each server signs in to its own token file, so
+	// this one doesn't fall back to PLEX_TOKEN_FILE
+	tokenFileVar := "PLEX_TOKEN_FILE"
+	if name != DefaultPlexServer {
+		tokenFileVar = "PLEX_" + envName(name) + "_TOKEN_FILE"
+	}
+	server.TokenFile = defaultTokenFile(name)
+	if os.Getenv(tokenFileVar) != "" {
+		server.TokenFile = os.Getenv(tokenFileVar)
+	}
+	if name == DefaultPlexServer {
+		server.Token = os.Getenv("PLEX_TOKEN")
+	} else {
+		server.Token = os.Getenv("PLEX_" + envName(name) + "_TOKEN")
+	}
+	if server.Token == "" {
+		token, err := ReadToken(server.TokenFile)
+		if err != nil {
+			log.Printf("could not read Plex token for %s from %s: %s\n", name, server.TokenFile, err.Error())
+		}
+		server.Token = token
+	}
+	if server.Token == "" && first {
+		server.Token = os.Getenv("PLEX_TOKEN")
+	}
+
+	// My movies library is at section 3, so I have the default set to that if
+	// not provided via environment.
+	server.DefaultLibrarySection = "3"
+	if getenv("DEFAULT_LIBRARY_SECTION") != "" {
+		server.DefaultLibrarySection = getenv("DEFAULT_LIBRARY_SECTION")
+	}
+	if getenv("LIBRARY_SECTIONS") != "" {
+		server.LibrarySections = splitList(getenv("LIBRARY_SECTIONS"))
+	}
+	if getenv("INSECURE_SKIP_VERIFY") != "" {
+		skipVerify, err := strconv.ParseBool(getenv("INSECURE_SKIP_VERIFY"))
+		if err != nil {
+			log.Printf("INSECURE_SKIP_VERIFY set for Plex server %s but to non-bool value\n", name)
+		}
+		server.InsecureSkipVerify = skipVerify
+	}
+	return server
+}
+
+// envName turns a server name into the form used in
+// environment variable names, e.g. living-room becomes
+// LIVING_ROOM.
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+// splitList splits a comma separated list,
+// dropping any empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
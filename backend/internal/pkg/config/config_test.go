@@ -0,0 +1,87 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadWeaviate(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		for _, key := range []string{"WEAVIATE_HOST", "WEAVIATE_SCHEME", "WEAVIATE_API_KEY", "WEAVIATE_HEADERS",
+			"WEAVIATE_GRPC_PORT", "WEAVIATE_STARTUP_TIMEOUT", "WEAVIATE_TIMEOUT"} {
+			t.Setenv(key, "")
+		}
+		expected := Weaviate{Host: "weaviate:8080", Scheme: "http"}
+		if w := loadWeaviate(); !reflect.DeepEqual(w, expected) {
+			t.Errorf("expected %+v, got %+v", expected, w)
+		}
+	})
+
+	t.Run("From Environment", func(t *testing.T) {
+		t.Setenv("WEAVIATE_HOST", "vectors.example.com")
+		t.Setenv("WEAVIATE_SCHEME", "https")
+		t.Setenv("WEAVIATE_API_KEY", "secret")
+		t.Setenv("WEAVIATE_HEADERS", "X-Team = media, not-a-header, X-Env=prod")
+		t.Setenv("WEAVIATE_GRPC_PORT", "443")
+		t.Setenv("WEAVIATE_STARTUP_TIMEOUT", "1m")
+		t.Setenv("WEAVIATE_TIMEOUT", "30s")
+		expected := Weaviate{
+			Host:           "vectors.example.com",
+			Scheme:         "https",
+			APIKey:         "secret",
+			Headers:        map[string]string{"X-Team": "media", "X-Env": "prod"},
+			GRPCPort:       "443",
+			StartupTimeout: time.Minute,
+			Timeout:        30 * time.Second,
+		}
+		if w := loadWeaviate(); !reflect.DeepEqual(w, expected) {
+			t.Errorf("expected %+v, got %+v", expected, w)
+		}
+	})
+}
+
+func TestLoadIngest(t *testing.T) {
+	t.Setenv("INGEST_CHUNK_SIZE", "64")
+	t.Setenv("INGEST_WORKERS", "many")
+	t.Setenv("INGEST_RETRIES", "")
+	expected := Ingest{ChunkSize: 64, Retries: -1}
+	if ingest := loadIngest(); ingest != expected {
+		t.Errorf("expected %+v, got %+v", expected, ingest)
+	}
+
+	t.Setenv("INGEST_RETRIES", "0")
+	if ingest := loadIngest(); ingest.Retries != 0 {
+		t.Errorf("expected retries to be turned off, got %+v", ingest)
+	}
+}
+
+func TestLoadPlexServerToken(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PLEX_TOKEN", "home-token")
+	t.Setenv("PLEX_HOME_TOKEN", "")
+	t.Setenv("PLEX_HOME_TOKEN_FILE", filepath.Join(dir, "home.token"))
+	t.Setenv("PLEX_CABIN_TOKEN", "")
+	t.Setenv("PLEX_CABIN_TOKEN_FILE", filepath.Join(dir, "cabin.token"))
+	t.Setenv("PLEX_ATTIC_TOKEN", "")
+	t.Setenv("PLEX_ATTIC_TOKEN_FILE", filepath.Join(dir, "attic.token"))
+	if err := SaveToken(filepath.Join(dir, "cabin.token"), "cabin-token"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if server := loadPlexServer("home", true); server.Token != "home-token" {
+		t.Errorf("expected the first server to fall back to PLEX_TOKEN, got %q", server.Token)
+	}
+	if server := loadPlexServer("cabin", false); server.Token != "cabin-token" {
+		t.Errorf("expected the cabin's saved token, got %q", server.Token)
+	}
+	if server := loadPlexServer("attic", false); server.Token != "" {
+		t.Errorf("expected a server without a token of its own to have none, got %q", server.Token)
+	}
+
+	t.Setenv("PLEX_CABIN_TOKEN", "cabin-env-token")
+	if server := loadPlexServer("cabin", false); server.Token != "cabin-env-token" {
+		t.Errorf("expected PLEX_CABIN_TOKEN to win over the saved token, got %q", server.Token)
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultTokenFile is where a Plex server's token is saved when
+// signing in, unless its TOKEN_FILE variable says otherwise.
+func defaultTokenFile(name string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "plex-recommendation", name+".token")
+}
+
+// ReadToken reads a saved Plex token. A missing
+// file is not an error and reads as no token.
+func ReadToken(path string) (string, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		log.Printf("WARNING: %s can be read by other users, it should only be readable by its owner\n", path)
+	}
+	token, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// SaveToken saves a Plex token so only the current user can read
+// it. The file is replaced in one step so a reader never sees a
+// partly written token.
+func SaveToken(path, token string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	// temporary files are only readable by their owner
+	f, err := os.CreateTemp(dir, ".token-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(token + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
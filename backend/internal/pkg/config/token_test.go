@@ -0,0 +1,67 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plex-recommendation", "home.token")
+
+	for _, token := range []string{"first-token", "second-token"} {
+		if err := SaveToken(path, token); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		saved, err := ReadToken(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if saved != token {
+			t.Errorf("expected %q, got %q", token, saved)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the token to only be readable by its owner, got %v", info.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected only the token file, got %d entries", len(entries))
+	}
+
+	if token, err := ReadToken(filepath.Join(t.TempDir(), "missing.token")); token != "" || err != nil {
+		t.Errorf("expected no token for a missing file, got %q, %v", token, err)
+	}
+}
+
+func TestLoadConfigReadsTokenFiles(t *testing.T) {
+	dir := t.TempDir()
+	homeFile, cabinFile := filepath.Join(dir, "home.token"), filepath.Join(dir, "cabin.token")
+	if err := SaveToken(homeFile, "home-token"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := SaveToken(cabinFile, "stale-token"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Setenv("PLEX_SERVERS", "home,cabin")
+	t.Setenv("PLEX_TOKEN", "")
+	t.Setenv("PLEX_HOME_TOKEN_FILE", homeFile)
+	t.Setenv("PLEX_CABIN_TOKEN", "cabin-token")
+	t.Setenv("PLEX_CABIN_TOKEN_FILE", cabinFile)
+
+	cfg := LoadConfig()
+	if len(cfg.Plex) != 2 {
+		t.Fatalf("expected 2 Plex servers, got %+v", cfg.Plex)
+	}
+	// a token from the environment wins over a saved one
+	for i, expected := range []string{"home-token", "cabin-token"} {
+		if cfg.Plex[i].Token != expected {
+			t.Errorf("expected %s to have token %q, got %q", cfg.Plex[i].Name, expected, cfg.Plex[i].Token)
+		}
+	}
+}
@@ -1,62 +1,275 @@
 package httpinternal
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex"
 	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/weaviate"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"io"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type llmResponse struct {
 	Videos        []*plex.VideoShort `json:"videos"`
 	Justification string             `json:"justification"`
+	// Similar is the media found to be most like the watch
+	// history, with how similar each is, which the LLM
+	// was given to base its recommendation on.
+	Similar []*plex.VideoShort `json:"similar,omitempty"`
+	// Writeback is where the recommendation was saved
+	// in Plex, if it was requested.
+	Writeback *writebackResult `json:"writeback,omitempty"`
+}
+
+// musicResponse is a recommendation of albums
+// and artists from a music section.
+type musicResponse struct {
+	Albums        []*plex.AlbumShort  `json:"albums"`
+	Artists       []*plex.ArtistShort `json:"artists"`
+	Justification string              `json:"justification"`
+	// Similar is the albums found to be most like the
+	// listening history, with how similar each is.
+	Similar []*plex.AlbumShort `json:"similar,omitempty"`
 }
 
 func formatHttpError(err error) []byte {
 	return []byte(fmt.Sprintf(`{"error": "%s"}`, err.Error()))
 }
 
-const recommendationPathway = "/recommendation/{movieSection}"
-
-func recommendationHandler(w http.ResponseWriter, r *http.Request) {
+// getRequestId returns the caller provided request ID
+// or generates a new one.
+func getRequestId(r *http.Request) string {
 	requestId := r.Header.Get("X-Request-Id")
 	if requestId == "" {
 		requestId = uuid.NewString()
 	}
+	return requestId
+}
+
+const (
+	recommendationPathway = "/recommendation/{movieSection}"
+	sectionsPathway       = "GET /sections"
+	usersPathway          = "GET /users"
+	webhookPathway        = "POST /webhooks/plex"
+	writebackPathway      = "POST /recommendation/{movieSection}/{target}"
+	imagesPathway         = "GET /images/{ratingKey}"
+	serversPathway        = "GET /servers"
+	loginPathway          = "POST /login"
+	loginStatusPathway    = "GET /login/{pin}"
+	musicPathway          = "/music/recommendation/{musicSection}"
+	syncStatusPathway     = "GET /admin/sync"
+	syncPathway           = "POST /admin/sync"
+)
+
+// serverPrefix scopes a route to the Plex server named
+// in the path. Routes without it use the default server.
+const serverPrefix = "/servers/{server}"
+
+// serverPattern returns the pattern that serves the route
+// for the Plex server named in the path, keeping its method.
+func serverPattern(pattern string) string {
+	if method, path, ok := strings.Cut(pattern, " "); ok {
+		return method + " " + serverPrefix + path
+	}
+	return serverPrefix + pattern
+}
+
+// maxImageSize is the largest width or height an
+// image can be resized to.
+const maxImageSize = 2000
+
+// imageCacheControl lets browsers and proxies keep images
+// for a day, since artwork rarely changes.
+const imageCacheControl = "public, max-age=86400"
+
+// maxWebhookMemory is how much of a webhook request is held in
+// memory. Plex attaches a thumbnail to some events, and anything
+// past this is spilled to disk while the request is handled.
+const maxWebhookMemory = 1 << 20
+
+// recommendationRequest holds the caller's
+// inputs to a recommendation.
+type recommendationRequest struct {
+	// server is the name of the Plex server whose watch
+	// history the recommendation is based on.
+	server  string
+	section string
+	limit   int
+	// user is a Plex account ID or name. The server's
+	// recently viewed is used when it's empty.
+	user string
+	// writeback is where in Plex to save the
+	// recommendation, if anywhere.
+	writeback string
+	// rewatch allows titles that have already been
+	// watched to be recommended.
+	rewatch bool
+	// since and until limit the watch history the
+	// recommendation is based on to a window of time.
+	since time.Time
+	until time.Time
+	// span allows media on every Plex server to be
+	// recommended, not only media on server.
+	span bool
+}
+
+// parseRecommendationRequest reads the recommendation inputs
+// from the request's path and query.
+func parseRecommendationRequest(r *http.Request) (recommendationRequest, error) {
+	server, _, err := plexServer(r.PathValue("server"))
+	if err != nil {
+		return recommendationRequest{}, err
+	}
+	var limit int
+	limitQuery, ok := r.URL.Query()["limit"]
+	if ok {
+		limit, _ = strconv.Atoi(limitQuery[0])
+	}
+	rewatch, _ := strconv.ParseBool(r.URL.Query().Get("rewatch"))
+	spanServers, _ := strconv.ParseBool(r.URL.Query().Get("span"))
+	now := time.Now()
+	since, err := parseTimeBound(r.URL.Query().Get("since"), now)
+	if err != nil {
+		return recommendationRequest{}, err
+	}
+	until, err := parseTimeBound(r.URL.Query().Get("until"), now)
+	if err != nil {
+		return recommendationRequest{}, err
+	}
+	return recommendationRequest{
+		server:    server,
+		section:   r.PathValue("movieSection"),
+		limit:     limit,
+		user:      r.URL.Query().Get("user"),
+		writeback: r.URL.Query().Get("writeback"),
+		rewatch:   rewatch,
+		since:     since,
+		until:     until,
+		span:      spanServers,
+	}, nil
+}
+
+// parseTimeBound reads a time window bound, which is either an age
+// relative to now such as 30d, 2w or 12h, an RFC 3339 timestamp or
+// a date. An empty value is the zero time.
+func parseTimeBound(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if count, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -count), nil
+		}
+	}
+	if weeks, ok := strings.CutSuffix(value, "w"); ok {
+		if count, err := strconv.Atoi(weeks); err == nil {
+			return now.AddDate(0, 0, -7*count), nil
+		}
+	}
+	if age, err := time.ParseDuration(value); err == nil {
+		return now.Add(-age), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as an age like 30d or a time like 2024-05-01", value)
+}
+
+func recommendationHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := getRequestId(r)
 	ctx, span := telemetry.StartSpan(r.Context(),
 		telemetry.WithSpanName("Get Recommendation HTTP Handler"),
 		telemetry.WithSpanPackage("httpinternal"),
 		telemetry.WithRequestId(requestId),
 	)
 	defer span.End()
-	section := r.PathValue("movieSection")
-	span.SetAttributes(attribute.String("movieSection", section))
-	var limit int
-	limitQuery, ok := r.URL.Query()["limit"]
-	if ok {
-		limit, _ = strconv.Atoi(limitQuery[0])
+	req, err := parseRecommendationRequest(r)
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
 	}
-	span.SetAttributes(attribute.Int("limit", limit))
+	writeRecommendation(ctx, w, req)
+}
 
-	recommendation, err := getRecommendation(ctx, section, limit)
+// writebackHandler saves a recommendation to a Plex
+// playlist or collection named by the path.
+func writebackHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := getRequestId(r)
+	ctx, span := telemetry.StartSpan(r.Context(),
+		telemetry.WithSpanName("Write Back Recommendation HTTP Handler"),
+		telemetry.WithSpanPackage("httpinternal"),
+		telemetry.WithRequestId(requestId),
+	)
+	defer span.End()
+	req, err := parseRecommendationRequest(r)
 	if err != nil {
 		w.Write(formatHttpError(err))
 		span.SetStatus(codes.Error, err.Error())
 		return
 	}
-	span.AddEvent("recommendation generated")
-	var respStruct *llmResponse
-	if err := json.Unmarshal([]byte(recommendation), &respStruct); err != nil {
+	req.writeback = r.PathValue("target")
+	writeRecommendation(ctx, w, req)
+}
+
+// writeRecommendation gets the requested recommendation, writes
+// it back to Plex if asked to, and responds with it.
+func writeRecommendation(ctx context.Context, w http.ResponseWriter, req recommendationRequest) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.String("server", req.server),
+		attribute.String("movieSection", req.section),
+		attribute.Int("limit", req.limit),
+		attribute.String("user", req.user),
+		attribute.String("writeback", req.writeback),
+		attribute.Bool("rewatch", req.rewatch),
+		attribute.Bool("span", req.span),
+	)
+	if !req.since.IsZero() {
+		span.SetAttributes(attribute.String("since", req.since.Format(time.RFC3339)))
+	}
+	if !req.until.IsZero() {
+		span.SetAttributes(attribute.String("until", req.until.Format(time.RFC3339)))
+	}
+
+	respStruct, err := getRecommendation(ctx, req)
+	if err != nil {
 		w.Write(formatHttpError(err))
 		span.SetStatus(codes.Error, err.Error())
 		return
 	}
+	span.AddEvent("recommendation generated")
+	// links are added here rather than cached so recommendations
+	// cached before links existed get them too
+	if err := addLinks(ctx, req.server, respStruct.Videos); err != nil {
+		log.Println("could not link recommendations to Plex: ", err.Error())
+		span.RecordError(err)
+	}
+	if req.writeback != "" {
+		respStruct.Writeback, err = writeBack(ctx, req, respStruct)
+		if err != nil {
+			w.Write(formatHttpError(err))
+			span.SetStatus(codes.Error, err.Error())
+			return
+		}
+		span.AddEvent("recommendation written back")
+	}
 	respBytes, err := json.Marshal(&respStruct)
 	if err != nil {
 		w.Write(formatHttpError(err))
@@ -71,3 +284,486 @@ func recommendationHandler(w http.ResponseWriter, r *http.Request) {
 	span.AddEvent("write complete")
 	span.SetStatus(codes.Ok, "recommendation successfully retrieved")
 }
+
+// musicRecommendationHandler recommends albums and artists
+// from a music section based on what was listened to.
+func musicRecommendationHandler(w http.ResponseWriter, r *http.Request) {
+	requestId := getRequestId(r)
+	ctx, span := telemetry.StartSpan(r.Context(),
+		telemetry.WithSpanName("Get Music Recommendation HTTP Handler"),
+		telemetry.WithSpanPackage("httpinternal"),
+		telemetry.WithRequestId(requestId),
+	)
+	defer span.End()
+	req, err := parseRecommendationRequest(r)
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	req.section = r.PathValue("musicSection")
+	span.SetAttributes(
+		attribute.String("server", req.server),
+		attribute.String("musicSection", req.section),
+		attribute.Int("limit", req.limit),
+		attribute.String("user", req.user),
+		attribute.Bool("rewatch", req.rewatch),
+	)
+	if req.writeback != "" || req.span {
+		err := errors.New("music recommendations cannot be written back to Plex or span servers")
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+
+	respStruct, err := getMusicRecommendation(ctx, req)
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.AddEvent("recommendation generated")
+	respBytes, err := json.Marshal(&respStruct)
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	_, err = w.Write(respBytes)
+	if err != nil {
+		log.Println("could not write back to client: ", err.Error())
+	}
+	span.SetStatus(codes.Ok, "music recommendation successfully retrieved")
+}
+
+// sectionResponse is a Plex library section and whether
+// its media has been ingested into the vector store.
+type sectionResponse struct {
+	plex.SectionSummary
+	Ingested bool `json:"ingested"`
+}
+
+func sectionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := telemetry.StartSpan(r.Context(),
+		telemetry.WithSpanName("Get Sections HTTP Handler"),
+		telemetry.WithSpanPackage("httpinternal"),
+		telemetry.WithRequestId(getRequestId(r)),
+	)
+	defer span.End()
+
+	sections, err := getSections(ctx, r.PathValue("server"))
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetAttributes(attribute.Int("count", len(sections)))
+	respBytes, err := json.Marshal(sections)
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	_, err = w.Write(respBytes)
+	if err != nil {
+		log.Println("could not write back to client: ", err.Error())
+	}
+	span.SetStatus(codes.Ok, "sections successfully retrieved")
+}
+
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := telemetry.StartSpan(r.Context(),
+		telemetry.WithSpanName("Get Users HTTP Handler"),
+		telemetry.WithSpanPackage("httpinternal"),
+		telemetry.WithRequestId(getRequestId(r)),
+	)
+	defer span.End()
+
+	_, client, err := plexServer(r.PathValue("server"))
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	accounts, err := plex.GetAccounts(ctx, client)
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	respBytes, err := json.Marshal(accounts)
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	_, err = w.Write(respBytes)
+	if err != nil {
+		log.Println("could not write back to client: ", err.Error())
+	}
+	span.SetStatus(codes.Ok, "users successfully retrieved")
+}
+
+// webhookHandler receives Plex webhooks so new media and
+// finished plays are picked up without a restart. Each webhook
+// must carry the configured secret as its token query parameter.
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := telemetry.StartSpan(r.Context(),
+		telemetry.WithSpanName("Plex Webhook HTTP Handler"),
+		telemetry.WithSpanPackage("httpinternal"),
+		telemetry.WithRequestId(getRequestId(r)),
+	)
+	defer span.End()
+
+	if !secretMatches(r.URL.Query().Get("token"), serverConfig.Webhooks.Secret) {
+		err := errors.New("webhook token is missing or wrong")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+
+	server, _, err := plexServer(r.PathValue("server"))
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetAttributes(attribute.String("server", server))
+
+	if err := r.ParseMultipartForm(maxWebhookMemory); err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	payload, err := plex.DecodeWebhook([]byte(r.FormValue("payload")))
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetAttributes(attribute.String("event", payload.Event))
+
+	if err := handleWebhook(ctx, server, payload); err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetStatus(codes.Ok, "webhook handled")
+}
+
+// imageHandler serves a movie or show's poster or background
+// from Plex so clients never see the Plex token. The image is
+// resized by Plex's transcoder when a width and height are given.
+// Only media in the sections recommendations are made from is
+// served.
+func imageHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := telemetry.StartSpan(r.Context(),
+		telemetry.WithSpanName("Get Image HTTP Handler"),
+		telemetry.WithSpanPackage("httpinternal"),
+		telemetry.WithRequestId(getRequestId(r)),
+	)
+	defer span.End()
+
+	server, client, err := plexServer(r.PathValue("server"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	ratingKey, err := strconv.Atoi(r.PathValue("ratingKey"))
+	if err != nil {
+		http.Error(w, "invalid rating key", http.StatusBadRequest)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = plex.ImageThumb
+	}
+	if kind != plex.ImageThumb && kind != plex.ImageArt {
+		http.Error(w, "kind must be thumb or art", http.StatusBadRequest)
+		span.SetStatus(codes.Error, "invalid image kind")
+		return
+	}
+	span.SetAttributes(attribute.Int("ratingKey", ratingKey), attribute.String("kind", kind))
+
+	var opts []plex.ImageOption
+	width, _ := strconv.Atoi(r.URL.Query().Get("width"))
+	height, _ := strconv.Atoi(r.URL.Query().Get("height"))
+	if width > 0 && height > 0 {
+		opts = append(opts, plex.WithImageSize(min(width, maxImageSize), min(height, maxImageSize)))
+	}
+
+	err = checkImageSection(ctx, server, client, ratingKey)
+	var resp *http.Response
+	if err == nil {
+		resp, err = plex.GetImage(ctx, client, ratingKey, kind, opts...)
+	}
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, plex.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, http.StatusText(status), status)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	// only pass along what describes the image, never
+	// anything else Plex responded with
+	for _, header := range []string{"Content-Type", "Content-Length", "ETag", "Last-Modified"} {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	w.Header().Set("Cache-Control", imageCacheControl)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Println("could not write image back to client: ", err.Error())
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetStatus(codes.Ok, "image served")
+}
+
+// serverResponse is a configured Plex server.
+type serverResponse struct {
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+}
+
+// serversHandler lists the configured Plex servers.
+func serversHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := telemetry.StartSpan(r.Context(),
+		telemetry.WithSpanName("Get Servers HTTP Handler"),
+		telemetry.WithSpanPackage("httpinternal"),
+		telemetry.WithRequestId(getRequestId(r)),
+	)
+	defer span.End()
+
+	servers := make([]serverResponse, 0, len(serverConfig.Plex))
+	for _, server := range serverConfig.Plex {
+		servers = append(servers, serverResponse{Name: server.Name, Default: server.Name == defaultServer})
+	}
+	respBytes, err := json.Marshal(servers)
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	_, err = w.Write(respBytes)
+	if err != nil {
+		log.Println("could not write back to client: ", err.Error())
+	}
+	span.SetStatus(codes.Ok, "servers successfully retrieved")
+}
+
+// loginResponse is a PIN whose code is entered at
+// LinkURL to sign in to Plex.
+type loginResponse struct {
+	PinID     int       `json:"pin_id"`
+	Code      string    `json:"code"`
+	LinkURL   string    `json:"link_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// loginStatusResponse is whether a PIN's code has been
+// entered and the server signed in to.
+type loginStatusResponse struct {
+	Server string `json:"server"`
+	Linked bool   `json:"linked"`
+}
+
+// adminOnly refuses requests that don't send the configured admin
+// secret as a bearer token, and all requests when it isn't set.
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provided, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !bearer || !secretMatches(provided, serverConfig.Admin.Secret) {
+			err := errors.New("admin secret is missing or wrong")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(formatHttpError(err))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// syncRequestInterval is how long after a sync is asked
+// for over HTTP before another one can be.
+const syncRequestInterval = time.Minute
+
+// throttle refuses requests that come within every of the
+// last one it let through to next.
+func throttle(every time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	var mu sync.Mutex
+	var last time.Time
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		now := time.Now()
+		if wait := every - now.Sub(last); !last.IsZero() && wait > 0 {
+			mu.Unlock()
+			err := fmt.Errorf("too many requests, try again in %s", wait.Round(time.Second))
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write(formatHttpError(err))
+			return
+		}
+		last = now
+		mu.Unlock()
+		next(w, r)
+	}
+}
+
+// loginHandler starts signing in to Plex for a server. The code
+// it responds with is entered at plex.tv/link, after which
+// loginStatusHandler finishes signing in.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := telemetry.StartSpan(r.Context(),
+		telemetry.WithSpanName("Login HTTP Handler"),
+		telemetry.WithSpanPackage("httpinternal"),
+		telemetry.WithRequestId(getRequestId(r)),
+	)
+	defer span.End()
+
+	settings, err := serverSettings(r.PathValue("server"))
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	pin, err := pinClient(settings).RequestPin(ctx)
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	respBytes, err := json.Marshal(loginResponse{
+		PinID:     pin.ID,
+		Code:      pin.Code,
+		LinkURL:   plex.LinkURL,
+		ExpiresAt: pin.ExpiresAt,
+	})
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	_, err = w.Write(respBytes)
+	if err != nil {
+		log.Println("could not write back to client: ", err.Error())
+	}
+	span.SetStatus(codes.Ok, "pin requested")
+}
+
+// loginStatusHandler checks whether a PIN's code has been entered.
+// Once it has, the token is saved and the server is reconnected to
+// with it. The token itself is never sent back.
+func loginStatusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := telemetry.StartSpan(r.Context(),
+		telemetry.WithSpanName("Login Status HTTP Handler"),
+		telemetry.WithSpanPackage("httpinternal"),
+		telemetry.WithRequestId(getRequestId(r)),
+	)
+	defer span.End()
+
+	settings, err := serverSettings(r.PathValue("server"))
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	pinID, err := strconv.Atoi(r.PathValue("pin"))
+	if err != nil {
+		w.Write(formatHttpError(fmt.Errorf("invalid pin %q", r.PathValue("pin"))))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	pin, err := pinClient(settings).CheckPin(ctx, pinID)
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	if pin.Linked() {
+		if err := signIn(settings, pin.AuthToken); err != nil {
+			w.Write(formatHttpError(err))
+			span.SetStatus(codes.Error, err.Error())
+			return
+		}
+		span.AddEvent("signed in")
+	}
+	respBytes, err := json.Marshal(loginStatusResponse{Server: settings.Name, Linked: pin.Linked()})
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	_, err = w.Write(respBytes)
+	if err != nil {
+		log.Println("could not write back to client: ", err.Error())
+	}
+	span.SetStatus(codes.Ok, "pin checked")
+}
+
+// syncStatusHandler reports how syncing the
+// Plex libraries is going.
+func syncStatusHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := telemetry.StartSpan(r.Context(),
+		telemetry.WithSpanName("Get Sync Status HTTP Handler"),
+		telemetry.WithSpanPackage("httpinternal"),
+		telemetry.WithRequestId(getRequestId(r)),
+	)
+	defer span.End()
+	writeSyncStatus(w, span)
+}
+
+// syncHandler starts syncing the Plex libraries in the background.
+// Only sections Plex has updated since they were last synced are
+// synced, unless full is set.
+func syncHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := telemetry.StartSpan(r.Context(),
+		telemetry.WithSpanName("Sync Libraries HTTP Handler"),
+		telemetry.WithSpanPackage("httpinternal"),
+		telemetry.WithRequestId(getRequestId(r)),
+	)
+	defer span.End()
+	full, _ := strconv.ParseBool(r.URL.Query().Get("full"))
+	span.SetAttributes(attribute.Bool("full", full))
+
+	opts, err := syncOptions()
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	if full {
+		opts = append(opts, weaviate.WithFullSync())
+	}
+	// the sync outlives the request
+	if err := weaviate.StartSync(context.WithoutCancel(ctx), ollamaEmbedder, opts...); err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.AddEvent("sync started")
+	writeSyncStatus(w, span)
+}
+
+// writeSyncStatus responds with the sync status.
+func writeSyncStatus(w http.ResponseWriter, span trace.Span) {
+	respBytes, err := json.Marshal(weaviate.GetSyncStatus())
+	if err != nil {
+		w.Write(formatHttpError(err))
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	_, err = w.Write(respBytes)
+	if err != nil {
+		log.Println("could not write back to client: ", err.Error())
+	}
+	span.SetStatus(codes.Ok, "sync status retrieved")
+}
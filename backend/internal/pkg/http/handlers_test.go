@@ -0,0 +1,151 @@
+package httpinternal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/weaviate"
+)
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		value    string
+		expected time.Time
+		wantErr  bool
+	}{
+		{name: "Empty", value: ""},
+		{name: "Days", value: "30d", expected: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "Weeks", value: "2w", expected: time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC)},
+		{name: "Hours", value: "12h", expected: time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)},
+		{name: "RFC 3339", value: "2024-04-01T08:30:00Z", expected: time.Date(2024, 4, 1, 8, 30, 0, 0, time.UTC)},
+		{name: "Date", value: "2024-04-01", expected: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "Invalid", value: "last month", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := parseTimeBound(tc.value, now)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if !result.Equal(tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestServerPattern(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		expected string
+	}{
+		{pattern: recommendationPathway, expected: "/servers/{server}/recommendation/{movieSection}"},
+		{pattern: imagesPathway, expected: "GET /servers/{server}/images/{ratingKey}"},
+	}
+
+	for _, tc := range testCases {
+		if result := serverPattern(tc.pattern); result != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, result)
+		}
+	}
+}
+
+func TestSyncStatusHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	syncStatusHandler(recorder, httptest.NewRequest(http.MethodGet, "/admin/sync", nil))
+
+	var status weaviate.SyncStatus
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("unexpected response %q: %v", recorder.Body.String(), err)
+	}
+	if status.Running || status.Sections == nil {
+		t.Errorf("expected an idle sync with no sections yet, got %+v", status)
+	}
+}
+
+func TestWebhookHandlerRequiresSecret(t *testing.T) {
+	usePlexServer(t, newTestServer(t))
+
+	testCases := []struct {
+		name     string
+		secret   string
+		target   string
+		expected int
+	}{
+		{name: "No Secret Configured", target: "/webhooks/plex?token=", expected: http.StatusUnauthorized},
+		{name: "Missing Token", secret: "hook-secret", target: "/webhooks/plex", expected: http.StatusUnauthorized},
+		{name: "Wrong Token", secret: "hook-secret", target: "/webhooks/plex?token=guess", expected: http.StatusUnauthorized},
+		{name: "Matching Token", secret: "hook-secret", target: "/webhooks/plex?token=hook-secret", expected: http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			serverConfig.Webhooks.Secret = tc.secret
+			recorder := httptest.NewRecorder()
+			webhookHandler(recorder, httptest.NewRequest(http.MethodPost, tc.target, nil))
+			if recorder.Code != tc.expected {
+				t.Errorf("expected status %d, got %d: %s", tc.expected, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminOnly(t *testing.T) {
+	usePlexServer(t, newTestServer(t))
+	handler := adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	testCases := []struct {
+		name          string
+		secret        string
+		authorization string
+		expected      int
+	}{
+		{name: "No Secret Configured", authorization: "Bearer ", expected: http.StatusUnauthorized},
+		{name: "Missing Token", secret: "admin-secret", expected: http.StatusUnauthorized},
+		{name: "Wrong Token", secret: "admin-secret", authorization: "Bearer guess", expected: http.StatusUnauthorized},
+		{name: "Not A Bearer Token", secret: "admin-secret", authorization: "admin-secret", expected: http.StatusUnauthorized},
+		{name: "Matching Token", secret: "admin-secret", authorization: "Bearer admin-secret", expected: http.StatusNoContent},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			serverConfig.Admin.Secret = tc.secret
+			req := httptest.NewRequest(http.MethodPost, "/login", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			handler(recorder, req)
+			if recorder.Code != tc.expected {
+				t.Errorf("expected status %d, got %d: %s", tc.expected, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	var calls int
+	handler := throttle(time.Hour, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	expected := []int{http.StatusNoContent, http.StatusTooManyRequests}
+	for _, code := range expected {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/admin/sync", nil))
+		if recorder.Code != code {
+			t.Errorf("expected status %d, got %d: %s", code, recorder.Code, recorder.Body.String())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected only the first request through, got %d", calls)
+	}
+}
@@ -2,11 +2,21 @@ package httpinternal
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/config"
 	"github.com/wgeorgecook/plex-recommendation/internal/pkg/langchain"
 	"github.com/wgeorgecook/plex-recommendation/internal/pkg/pg"
 	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex"
@@ -17,37 +27,362 @@ func buildStringFromSlice[T any](slice []T) string {
 	return fmt.Sprintf("%+v", slice)
 }
 
-func getRecommendation(ctx context.Context, section string, limit int) (string, error) {
+// plexServer returns the name and client of the named Plex
+// server, or of the default server when no name is given.
+func plexServer(name string) (string, *plex.PlexClient, error) {
+	if name == "" {
+		name = defaultServer
+	}
+	plexClientsMu.RLock()
+	defer plexClientsMu.RUnlock()
+	client, ok := plexClients[name]
+	if !ok {
+		return "", nil, fmt.Errorf("no Plex server named %q", name)
+	}
+	return name, client, nil
+}
+
+// serverSettings returns the configuration of the named Plex
+// server, or of the default server when no name is given.
+func serverSettings(name string) (config.PlexServer, error) {
+	if name == "" {
+		name = defaultServer
+	}
+	plexClientsMu.RLock()
+	defer plexClientsMu.RUnlock()
+	settings, ok := serverConfig.PlexServer(name)
+	if !ok {
+		return config.PlexServer{}, fmt.Errorf("no Plex server named %q", name)
+	}
+	return settings, nil
+}
+
+// pinClient creates a client for signing in to the Plex
+// account that owns the provided server.
+func pinClient(settings config.PlexServer) *plex.PinClient {
+	return plex.NewPinClient(
+		plex.WithPlexTVURL(serverConfig.PlexTVURL),
+		plex.WithPinClientIdentifier(settings.ClientIdentifier),
+	)
+}
+
+// signIn saves the server's new token and
+// reconnects to the server with it.
+func signIn(settings config.PlexServer, token string) error {
+	if err := config.SaveToken(settings.TokenFile, token); err != nil {
+		return err
+	}
+	settings.Token = token
+	client, err := newPlexClient(settings)
+	if err != nil {
+		return err
+	}
+	plexClientsMu.Lock()
+	defer plexClientsMu.Unlock()
+	plexClients[settings.Name] = client
+	for i := range serverConfig.Plex {
+		if serverConfig.Plex[i].Name == settings.Name {
+			serverConfig.Plex[i].Token = token
+		}
+	}
+	log.Println("signed in to Plex server ", settings.Name)
+	return nil
+}
+
+// setServer records which Plex server the videos are on.
+func setServer(videos []plex.VideoShort, server string) {
+	for i := range videos {
+		videos[i].Server = server
+	}
+}
+
+// getHistory returns what the requested user watched along with
+// the account ID used to key their cached recommendations. The
+// server's recently viewed is used when no user or time window
+// is requested, and Plex's watch history otherwise.
+func getHistory(ctx context.Context, req recommendationRequest) ([]plex.VideoShort, string, error) {
+	server, client, err := plexServer(req.server)
+	if err != nil {
+		return nil, "", err
+	}
+	windowed := !req.since.IsZero() || !req.until.IsZero()
+	limit := req.limit
+	if limit <= 0 {
+		limit = serverConfig.RecentMovieCount
+		if windowed {
+			limit = serverConfig.MaxHistorySize
+		}
+	}
+
+	if req.user == "" && !windowed {
+		recentlyViewed, err := plex.GetRecentlyPlayed(ctx, client, req.section, limit)
+		setServer(recentlyViewed, server)
+		return recentlyViewed, "", err
+	}
+
+	opts := []plex.HistoryOption{plex.WithViewedSince(req.since), plex.WithViewedUntil(req.until)}
+	var accountID string
+	if req.user != "" {
+		account, err := findAccount(ctx, client, req.user)
+		if err != nil {
+			return nil, "", err
+		}
+		accountID = strconv.Itoa(account.ID)
+		opts = append(opts, plex.WithAccountID(account.ID))
+	}
+	history, err := plex.GetWatchHistory(ctx, client, req.section, limit, opts...)
+	setServer(history, server)
+	return history, accountID, err
+}
+
+// setAlbumServer records which Plex server the albums are on.
+func setAlbumServer(albums []plex.AlbumShort, server string) {
+	for i := range albums {
+		albums[i].Server = server
+	}
+}
+
+// getListeningHistory returns the albums the requested user
+// listened to along with the account ID used to key their cached
+// recommendations, the same way getHistory does for videos.
+func getListeningHistory(ctx context.Context, req recommendationRequest) ([]plex.AlbumShort, string, error) {
+	server, client, err := plexServer(req.server)
+	if err != nil {
+		return nil, "", err
+	}
+	windowed := !req.since.IsZero() || !req.until.IsZero()
+	limit := req.limit
+	if limit <= 0 {
+		limit = serverConfig.RecentMovieCount
+		if windowed {
+			limit = serverConfig.MaxHistorySize
+		}
+	}
+
+	if req.user == "" && !windowed {
+		recentlyPlayed, err := plex.GetRecentlyPlayedAlbums(ctx, client, req.section, limit)
+		setAlbumServer(recentlyPlayed, server)
+		return recentlyPlayed, "", err
+	}
+
+	opts := []plex.HistoryOption{plex.WithViewedSince(req.since), plex.WithViewedUntil(req.until)}
+	var accountID string
+	if req.user != "" {
+		account, err := findAccount(ctx, client, req.user)
+		if err != nil {
+			return nil, "", err
+		}
+		accountID = strconv.Itoa(account.ID)
+		opts = append(opts, plex.WithAccountID(account.ID))
+	}
+	history, err := plex.GetListeningHistory(ctx, client, req.section, limit, opts...)
+	setAlbumServer(history, server)
+	return history, accountID, err
+}
+
+// findAccount returns the Plex account matching the
+// requested user's ID or name.
+func findAccount(ctx context.Context, c plex.Client, user string) (*plex.Account, error) {
+	accounts, err := plex.GetAccounts(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	account, ok := plex.FindAccount(accounts, user)
+	if !ok {
+		return nil, fmt.Errorf("no Plex user found matching %q", user)
+	}
+	return account, nil
+}
+
+// abandonedProgress is how far into a video someone has to get
+// before it counts towards their recommendations. Anything
+// stopped sooner than this probably wasn't enjoyed.
+const abandonedProgress = 0.2
+
+// recencyHalfLife is how long it takes for the part of a weight
+// that comes from how recently a video was watched to halve.
+const recencyHalfLife = 180 * 24 * time.Hour
+
+// weightedVideo is a video from the watch history and how much it
+// counts towards a recommendation, along with the reasons why.
+type weightedVideo struct {
+	video   plex.VideoShort
+	weight  float64
+	reasons []string
+}
+
+func (w weightedVideo) String() string {
+	s := fmt.Sprintf("%s (weight %.2f", w.video.Title, w.weight)
+	if len(w.reasons) > 0 {
+		s += ": " + strings.Join(w.reasons, ", ")
+	}
+	return s + ")"
+}
+
+// forAccount replaces the play counts, progress and ratings Plex
+// has for the account that owns the server with what the account
+// that watched the history did, going by everything it watched.
+// Plex doesn't say how far into a video anyone else got or how
+// they rated it, so those are left out.
+func forAccount(history, accountWatched []plex.VideoShort) []plex.VideoShort {
+	adjusted := make([]plex.VideoShort, 0, len(history))
+	for _, v := range history {
+		v.ViewCount, v.LastViewedAt, v.ViewOffset, v.UserRating = 0, 0, 0, 0
+		i := slices.IndexFunc(accountWatched, func(w plex.VideoShort) bool {
+			return plex.InHistory(v, []plex.VideoShort{w})
+		})
+		if i >= 0 {
+			v.ViewCount = accountWatched[i].ViewCount
+			v.LastViewedAt = accountWatched[i].LastViewedAt
+		}
+		adjusted = append(adjusted, v)
+	}
+	return adjusted
+}
+
+// evenWeights weighs every video in the watch history the same.
+func evenWeights(history []plex.VideoShort) []weightedVideo {
+	weighted := make([]weightedVideo, 0, len(history))
+	for _, v := range history {
+		weighted = append(weighted, weightedVideo{video: v, weight: 1})
+	}
+	return weighted
+}
+
+// weightSettings describes how the watch history was weighted, so
+// recommendations based on the same titles weighted differently
+// are cached apart.
+func weightSettings(weighted []weightedVideo) string {
+	pairs := make([]string, 0, len(weighted))
+	for _, w := range weighted {
+		pairs = append(pairs, fmt.Sprintf("%s=%.2f", w.video.Title, w.weight))
+	}
+	slices.Sort(pairs)
+	return "weights:" + strings.Join(pairs, ",")
+}
+
+// weighHistory weighs each video in the watch history by how it
+// was watched. Videos that were rewatched or rated highly count
+// more, videos that were only partly watched or watched a long
+// time ago count less, and videos abandoned early are left out.
+func weighHistory(history []plex.VideoShort, now time.Time) []weightedVideo {
+	weighted := make([]weightedVideo, 0, len(history))
+	for _, v := range history {
+		w := weightedVideo{video: v, weight: 1}
+		times := v.TimesWatched()
+		if progress := v.Progress(); times == 0 && progress < 1 {
+			if progress < abandonedProgress {
+				log.Printf("leaving %s out of the history, it was stopped %.0f%% of the way through\n", v.Title, progress*100)
+				continue
+			}
+			w.weight *= progress
+			w.reasons = append(w.reasons, fmt.Sprintf("stopped %.0f%% of the way through", progress*100))
+		}
+		if times > 1 {
+			w.weight *= 1 + 0.5*float64(min(times-1, 3))
+			w.reasons = append(w.reasons, fmt.Sprintf("watched %d times", times))
+		}
+		if v.UserRating > 0 {
+			// Plex ratings are out of 10, so an average
+			// rating leaves the weight as it is
+			w.weight *= v.UserRating / 5
+			w.reasons = append(w.reasons, fmt.Sprintf("rated %g/10", v.UserRating))
+		}
+		if v.LastViewedAt > 0 {
+			age := now.Sub(time.Unix(v.LastViewedAt, 0))
+			w.weight *= 0.5 + 0.5*math.Pow(0.5, max(age, 0).Hours()/recencyHalfLife.Hours())
+		}
+		weighted = append(weighted, w)
+	}
+	return weighted
+}
+
+func getRecommendation(ctx context.Context, req recommendationRequest) (*llmResponse, error) {
 	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Get Recommendation"))
 	defer span.End()
-	recentlyViewed, err := plex.GetRecentlyPlayed(ctx, plexClient, section, limit)
+	section := req.section
+	recentlyViewed, accountID, err := getHistory(ctx, req)
 	if err != nil {
 		span.SetStatus(codes.Error, err.Error())
-		return "", err
+		return nil, err
+	}
+
+	// Plex only keeps play counts, progress and ratings for the
+	// account that owns the server, so a user's own watch history
+	// stands in for them
+	var accountWatched []plex.VideoShort
+	if accountID != "" {
+		accountWatched, err = getWatched(ctx, req, accountID)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		recentlyViewed = forAccount(recentlyViewed, accountWatched)
+	}
+
+	weighted := weighHistory(recentlyViewed, time.Now())
+	span.SetAttributes(attribute.Int("abandoned", len(recentlyViewed)-len(weighted)))
+	if len(weighted) == 0 {
+		// going on what was abandoned beats not
+		// recommending anything at all
+		log.Println("everything in the watch history was abandoned, weighing it all the same")
+		weighted = evenWeights(recentlyViewed)
+	}
+	if len(weighted) == 0 {
+		err := errors.New("nothing in the watch history to base a recommendation on")
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
 	}
 
 	// LLM inputs operate on strings, so force the structs from the call to
 	// plex into their stringified forms
-	rvTexts := make([]string, 0, len(recentlyViewed))
-	titles := make([]string, 0, len(recentlyViewed))
-	for _, vid := range recentlyViewed {
-		rvTexts = append(rvTexts, vid.String())
-		titles = append(titles, vid.Title)
+	rvTexts := make([]string, 0, len(weighted))
+	titles := make([]string, 0, len(weighted))
+	weights := make([]float64, 0, len(weighted))
+	for _, w := range weighted {
+		rvTexts = append(rvTexts, w.video.String())
+		titles = append(titles, w.video.Title)
+		weights = append(weights, w.weight)
 	}
 
 	// query the cache to see if we've asked for recommendations
-	// based on this exact recently viewed
-	resp, err := pg.QueryData(ctx, pg.WithInputTitles(titles))
+	// based on this exact recently viewed, weighted and searched
+	// the same way
+	settings := weightSettings(weighted) + ";" + vectorSettings()
+	resp, err := pg.QueryData(ctx,
+		pg.WithInputTitles(titles),
+		pg.WithAccountID(accountID),
+		pg.WithRewatchAllowed(req.rewatch),
+		pg.WithServerName(req.server),
+		pg.WithSpanning(req.span),
+		pg.WithSameSettings(settings),
+	)
 	if err != nil {
 		span.SetStatus(codes.Error, err.Error())
 		log.Println("could not query cache for these titles: ", err.Error())
 	}
 
-	if resp.GeneratedOutput != "" {
+	if resp != nil && resp.GeneratedOutput != "" {
 		log.Println("found cached recommendation")
-		span.SetStatus(codes.Ok, "found cached recommendation")
-		span.AddEvent("cache found")
-		return resp.GeneratedOutput, nil
+		var cached *llmResponse
+		if err := json.Unmarshal([]byte(resp.GeneratedOutput), &cached); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		if !hasRatingKeys(cached) {
+			// cached before recommendations carried rating keys, so it
+			// can't be written back to Plex. Drop it and ask again.
+			log.Println("cached recommendation has no rating keys, regenerating")
+			span.AddEvent("cache stale")
+			if err := pg.DeleteByID(ctx, resp.ID); err != nil {
+				span.SetStatus(codes.Error, err.Error())
+				return nil, err
+			}
+		} else {
+			span.SetStatus(codes.Ok, "found cached recommendation")
+			span.AddEvent("cache found")
+			return cached, nil
+		}
 	}
 
 	span.AddEvent("no cached recommendation")
@@ -57,48 +392,1053 @@ func getRecommendation(ctx context.Context, section string, limit int) (string,
 	rvEmbeddings, err := ollamaEmbedder.CreateEmbedding(ctx, rvTexts)
 	if err != nil {
 		span.SetStatus(codes.Error, err.Error())
-		return "", err
+		return nil, err
 	}
 	span.AddEvent("embeddings complete")
 	log.Println("embeddings complete, querying database")
 
-	results, err := weaviate.VectorQuery(ctx, weaviate.VideoClass.Class, rvEmbeddings)
+	// section IDs are only meaningful on their own server, so
+	// a recommendation spanning servers searches everything
+	queryOpts := append(vectorQueryOptions(), weaviate.WithWeights(weights))
+	if !req.span {
+		queryOpts = append(queryOpts, weaviate.WithSectionID(section), weaviate.WithServer(req.server))
+	}
+	results, err := weaviate.VectorQuery(ctx, weaviate.VideoClass.Class, rvEmbeddings, queryOpts...)
 	if err != nil {
+		err = explainVectorQueryError(err)
 		span.SetStatus(codes.Error, err.Error())
-		return "", err
+		return nil, err
 	}
 	span.AddEvent("vector query complete")
 	log.Println("complete")
 
 	rvStr := buildStringFromSlice(results)
 
-	fullCollection, err := plex.GetAllVideos(ctx, plexClient, section)
+	fullCollection, err := getCollection(ctx, req)
 	if err != nil {
 		span.SetStatus(codes.Error, err.Error())
-		return "", err
+		return nil, err
+	}
+
+	candidates := fullCollection
+	var watched func(plex.VideoShort) bool
+	if !req.rewatch {
+		watched = watchedBy(recentlyViewed, accountWatched, accountID)
+		candidates = slices.DeleteFunc(slices.Clone(fullCollection), watched)
+		span.SetAttributes(attribute.Int("watched", len(fullCollection)-len(candidates)))
 	}
 
-	fcStr := buildStringFromSlice(fullCollection)
+	fcStr := buildStringFromSlice(candidates)
 
-	recommendation, err := langchain.GenerateRecommendation(ctx, rvStr, fcStr, ollamaLlm)
+	recommendation, err := langchain.GenerateRecommendation(ctx, rvStr, fcStr, ollamaLlm,
+		langchain.WithWeightedHistory(buildStringFromSlice(weighted)),
+	)
 	if err != nil {
 		span.SetStatus(codes.Error, err.Error())
-		return "", err
+		return nil, err
 	}
 	span.AddEvent("recommend complete")
 	normalized, err := langchain.NormalizeLLMResponse(ctx, recommendation, ollamaLlm)
 	if err != nil {
 		span.SetStatus(codes.Error, err.Error())
-		return "", err
+		return nil, err
 	}
 	span.AddEvent("normalization complete")
+	var respStruct *llmResponse
+	if err := json.Unmarshal([]byte(normalized), &respStruct); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	// the LLM only echoes back some of what we know about each
+	// video, so fill in the rest, including the rating keys
+	// needed to write the recommendation back to Plex
+	respStruct.Videos = matchCollection(respStruct.Videos, fullCollection)
+	if !req.rewatch {
+		// the LLM doesn't always stick to the candidates
+		// it's given, so check its choices too
+		respStruct.Videos = dropWatched(respStruct.Videos, watched)
+	}
+	addScores(respStruct.Videos, results)
+	respStruct.Similar = results
+	generated, err := json.Marshal(respStruct)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
 	// save this generated text back to the db
-	if err := pg.InsertData(ctx, titles, normalized); err != nil {
+	if err := pg.InsertData(ctx, titles, string(generated),
+		pg.WithAccount(accountID),
+		pg.WithRewatch(req.rewatch),
+		pg.WithServer(req.server),
+		pg.WithSpan(req.span),
+		pg.WithSettings(settings),
+	); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		span.AddEvent("insert failed")
+		log.Println("could not cache this response: ", err.Error())
+	}
+	span.SetStatus(codes.Ok, "generation completed")
+	return respStruct, nil
+
+}
+
+// getMusicRecommendation recommends albums and artists from a
+// music section based on the albums listened to recently.
+func getMusicRecommendation(ctx context.Context, req recommendationRequest) (*musicResponse, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Get Music Recommendation"))
+	defer span.End()
+	server, client, err := plexServer(req.server)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	history, accountID, err := getListeningHistory(ctx, req)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	if len(history) == 0 {
+		err := errors.New("nothing has been listened to in this section to base a recommendation on")
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	historyTexts := make([]string, 0, len(history))
+	// albums are cached by artist as well as title, since
+	// plenty of albums share a title
+	titles := make([]string, 0, len(history))
+	for _, album := range history {
+		historyTexts = append(historyTexts, album.String())
+		titles = append(titles, album.Artist+" - "+album.Title)
+	}
+
+	resp, err := pg.QueryData(ctx,
+		pg.WithInputTitles(titles),
+		pg.WithAccountID(accountID),
+		pg.WithRewatchAllowed(req.rewatch),
+		pg.WithServerName(server),
+		pg.WithSameSettings(vectorSettings()),
+	)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		log.Println("could not query cache for these albums: ", err.Error())
+	}
+	if resp != nil && resp.GeneratedOutput != "" {
+		log.Println("found cached music recommendation")
+		var cached *musicResponse
+		if err := json.Unmarshal([]byte(resp.GeneratedOutput), &cached); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		span.SetStatus(codes.Ok, "found cached recommendation")
+		span.AddEvent("cache found")
+		return cached, nil
+	}
+	span.AddEvent("no cached recommendation")
+
+	log.Println("embedding ", len(historyTexts), " albums")
+	embeddings, err := ollamaEmbedder.CreateEmbedding(ctx, historyTexts)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.AddEvent("embeddings complete")
+	similar, err := weaviate.AlbumVectorQuery(ctx, embeddings,
+		append(vectorQueryOptions(), weaviate.WithSectionID(req.section), weaviate.WithServer(server))...,
+	)
+	if err != nil {
+		err = explainVectorQueryError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.AddEvent("vector query complete")
+
+	albums, err := plex.GetAllAlbums(ctx, client, req.section)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	setAlbumServer(albums, server)
+	artists, err := plex.GetAllArtists(ctx, client, req.section)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	for i := range artists {
+		artists[i].Server = server
+	}
+
+	candidates, candidateArtists := albums, artists
+	var played func(plex.AlbumShort) bool
+	var playedArtist func(plex.ArtistShort) bool
+	if !req.rewatch {
+		var accountListened []plex.AlbumShort
+		if accountID != "" {
+			accountListened, err = getListened(ctx, req, accountID)
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+				return nil, err
+			}
+		}
+		played = playedBy(history, accountListened, accountID)
+		playedArtist = artistPlayedBy(history, accountListened, accountID)
+		candidates = slices.DeleteFunc(slices.Clone(albums), played)
+		candidateArtists = slices.DeleteFunc(slices.Clone(artists), playedArtist)
+		span.SetAttributes(
+			attribute.Int("played", len(albums)-len(candidates)),
+			attribute.Int("played artists", len(artists)-len(candidateArtists)),
+		)
+	}
+
+	recommendation, err := langchain.GenerateMusicRecommendation(ctx,
+		buildStringFromSlice(history),
+		buildStringFromSlice(similar),
+		buildStringFromSlice(candidates),
+		buildStringFromSlice(candidateArtists),
+		ollamaLlm,
+	)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.AddEvent("recommend complete")
+	normalized, err := langchain.NormalizeLLMResponse(ctx, recommendation, ollamaLlm)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.AddEvent("normalization complete")
+	var respStruct *musicResponse
+	if err := json.Unmarshal([]byte(normalized), &respStruct); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	respStruct.Albums = matchAlbums(respStruct.Albums, albums)
+	respStruct.Artists = dropMissingArtists(matchArtists(respStruct.Artists, artists))
+	if !req.rewatch {
+		respStruct.Albums = dropPlayed(respStruct.Albums, played)
+		respStruct.Artists = dropPlayedArtists(respStruct.Artists, playedArtist)
+	}
+	addAlbumScores(respStruct.Albums, similar)
+	respStruct.Similar = similar
+	generated, err := json.Marshal(respStruct)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	if err := pg.InsertData(ctx, titles, string(generated),
+		pg.WithAccount(accountID),
+		pg.WithRewatch(req.rewatch),
+		pg.WithServer(server),
+		pg.WithSettings(vectorSettings()),
+	); err != nil {
 		span.SetStatus(codes.Error, err.Error())
 		span.AddEvent("insert failed")
 		log.Println("could not cache this response: ", err.Error())
 	}
 	span.SetStatus(codes.Ok, "generation completed")
-	return normalized, nil
+	return respStruct, nil
+}
+
+// matchAlbums replaces each recommended album with the album in
+// the collection it refers to, matched by Plex ID or else by title
+// and artist. Albums that can't be matched are returned as they are.
+func matchAlbums(albums []*plex.AlbumShort, collection []plex.AlbumShort) []*plex.AlbumShort {
+	matched := make([]*plex.AlbumShort, 0, len(albums))
+	for _, album := range albums {
+		if album == nil {
+			continue
+		}
+		idx := slices.IndexFunc(collection, func(a plex.AlbumShort) bool {
+			return album.PlexID != "" && a.PlexID == album.PlexID
+		})
+		if idx < 0 {
+			idx = slices.IndexFunc(collection, func(a plex.AlbumShort) bool {
+				return strings.EqualFold(a.Title, album.Title) &&
+					(album.Artist == "" || strings.EqualFold(a.Artist, album.Artist))
+			})
+		}
+		if idx < 0 {
+			matched = append(matched, album)
+			continue
+		}
+		match := collection[idx]
+		matched = append(matched, &match)
+	}
+	return matched
+}
+
+// matchArtists replaces each recommended artist with the artist
+// in the collection it refers to, matched by Plex ID or else by
+// name. Artists that can't be matched are returned as they are.
+func matchArtists(artists []*plex.ArtistShort, collection []plex.ArtistShort) []*plex.ArtistShort {
+	matched := make([]*plex.ArtistShort, 0, len(artists))
+	for _, artist := range artists {
+		if artist == nil {
+			continue
+		}
+		idx := slices.IndexFunc(collection, func(a plex.ArtistShort) bool {
+			return artist.PlexID != "" && a.PlexID == artist.PlexID
+		})
+		if idx < 0 {
+			idx = slices.IndexFunc(collection, func(a plex.ArtistShort) bool {
+				return strings.EqualFold(a.Name, artist.Name)
+			})
+		}
+		if idx < 0 {
+			matched = append(matched, artist)
+			continue
+		}
+		match := collection[idx]
+		matched = append(matched, &match)
+	}
+	return matched
+}
+
+// getListened returns every album the account with
+// accountID listened to in the requested section.
+func getListened(ctx context.Context, req recommendationRequest, accountID string) ([]plex.AlbumShort, error) {
+	id, err := strconv.Atoi(accountID)
+	if err != nil {
+		return nil, err
+	}
+	server, client, err := plexServer(req.server)
+	if err != nil {
+		return nil, err
+	}
+	listened, err := plex.GetListened(ctx, client, req.section, plex.WithAccountID(id))
+	if err != nil {
+		return nil, err
+	}
+	setAlbumServer(listened, server)
+	return listened, nil
+}
+
+// playedBy returns how to tell whether the requested user has
+// already listened to an album, the same way watchedBy does
+// for videos.
+func playedBy(history, accountListened []plex.AlbumShort, accountID string) func(plex.AlbumShort) bool {
+	if accountID == "" {
+		return func(a plex.AlbumShort) bool {
+			return plex.HasPlayed(a, history)
+		}
+	}
+	listened := append(slices.Clip(accountListened), history...)
+	return func(a plex.AlbumShort) bool {
+		return plex.AlbumInHistory(a, listened)
+	}
+}
+
+// artistPlayedBy returns how to tell whether the requested user
+// has already listened to an artist, which they have when they've
+// listened to any of the artist's albums.
+func artistPlayedBy(history, accountListened []plex.AlbumShort, accountID string) func(plex.ArtistShort) bool {
+	if accountID == "" {
+		return func(a plex.ArtistShort) bool {
+			return a.ViewCount > 0 || plex.ArtistInHistory(a, history)
+		}
+	}
+	listened := append(slices.Clip(accountListened), history...)
+	return func(a plex.ArtistShort) bool {
+		return plex.ArtistInHistory(a, listened)
+	}
+}
+
+// dropPlayed removes the recommended albums
+// that have already been listened to.
+func dropPlayed(albums []*plex.AlbumShort, played func(plex.AlbumShort) bool) []*plex.AlbumShort {
+	unplayed := make([]*plex.AlbumShort, 0, len(albums))
+	for _, album := range albums {
+		if played(*album) {
+			log.Printf("dropping already played recommendation %q\n", album.Title)
+			continue
+		}
+		unplayed = append(unplayed, album)
+	}
+	return unplayed
+}
 
+// dropPlayedArtists removes the recommended artists
+// that have already been listened to.
+func dropPlayedArtists(artists []*plex.ArtistShort, played func(plex.ArtistShort) bool) []*plex.ArtistShort {
+	unplayed := make([]*plex.ArtistShort, 0, len(artists))
+	for _, artist := range artists {
+		if played(*artist) {
+			log.Printf("dropping already played artist %q\n", artist.Name)
+			continue
+		}
+		unplayed = append(unplayed, artist)
+	}
+	return unplayed
+}
+
+// dropMissingArtists removes the recommended artists that
+// couldn't be matched to an artist in the library.
+func dropMissingArtists(artists []*plex.ArtistShort) []*plex.ArtistShort {
+	found := make([]*plex.ArtistShort, 0, len(artists))
+	for _, artist := range artists {
+		if artist.RatingKey == 0 {
+			log.Printf("dropping artist %q, they aren't in the library\n", artist.Name)
+			continue
+		}
+		found = append(found, artist)
+	}
+	return found
+}
+
+// vectorQueryOptions returns the configured limit, maximum
+// distance, autocut and fallback for searching for similar media.
+func vectorQueryOptions() []weaviate.QueryOption {
+	var opts []weaviate.QueryOption
+	if serverConfig.VectorQuery.Limit > 0 {
+		opts = append(opts, weaviate.WithLimit(serverConfig.VectorQuery.Limit))
+	}
+	if serverConfig.VectorQuery.MaxDistance > 0 {
+		opts = append(opts, weaviate.WithMaxDistance(serverConfig.VectorQuery.MaxDistance))
+	}
+	if serverConfig.VectorQuery.Autocut > 0 {
+		opts = append(opts, weaviate.WithAutocut(serverConfig.VectorQuery.Autocut))
+	}
+	if serverConfig.VectorQuery.Fallback {
+		opts = append(opts, weaviate.WithCutoffFallback())
+	}
+	return opts
+}
+
+// explainVectorQueryError says what to do when the search for
+// similar media found nothing close enough to recommend from.
+func explainVectorQueryError(err error) error {
+	if !errors.Is(err, weaviate.ErrNothingClose) {
+		return err
+	}
+	return fmt.Errorf("nothing in the library is similar enough to the watch history to recommend: %w; "+
+		"raise VECTOR_QUERY_MAX_DISTANCE or VECTOR_QUERY_AUTOCUT, or set VECTOR_QUERY_FALLBACK=true", err)
+}
+
+// vectorSettings describes how similar media is searched for,
+// so recommendations based on different searches are cached
+// apart.
+func vectorSettings() string {
+	return fmt.Sprintf("vector:limit=%d,maxDistance=%g,autocut=%d,fallback=%t",
+		serverConfig.VectorQuery.Limit, serverConfig.VectorQuery.MaxDistance, serverConfig.VectorQuery.Autocut,
+		serverConfig.VectorQuery.Fallback)
+}
+
+// addScores copies the distance and score of each recommended
+// video that was also found by the vector search, matched by
+// server and Plex ID or else by server and title.
+func addScores(videos, similar []*plex.VideoShort) {
+	for _, video := range videos {
+		idx := slices.IndexFunc(similar, func(v *plex.VideoShort) bool {
+			return v.Server == video.Server && video.PlexID != "" && v.PlexID == video.PlexID
+		})
+		if idx < 0 {
+			idx = slices.IndexFunc(similar, func(v *plex.VideoShort) bool {
+				return v.Server == video.Server && strings.EqualFold(v.Title, video.Title)
+			})
+		}
+		if idx >= 0 {
+			video.Distance, video.Score = similar[idx].Distance, similar[idx].Score
+		}
+	}
+}
+
+// addAlbumScores copies the distance and score of each
+// recommended album that was also found by the vector
+// search, matched by server and Plex ID.
+func addAlbumScores(albums, similar []*plex.AlbumShort) {
+	for _, album := range albums {
+		idx := slices.IndexFunc(similar, func(a *plex.AlbumShort) bool {
+			return a.Server == album.Server && album.PlexID != "" && a.PlexID == album.PlexID
+		})
+		if idx >= 0 {
+			album.Distance, album.Score = similar[idx].Distance, similar[idx].Score
+		}
+	}
+}
+
+// getCollection returns the media that can be recommended: the
+// requested section, and when the request spans servers, the
+// sections of the same type on every other Plex server. Media on
+// more than one server is only kept from the first server that
+// has it, preferring the requested one.
+func getCollection(ctx context.Context, req recommendationRequest) ([]plex.VideoShort, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Get Collection"))
+	defer span.End()
+	server, client, err := plexServer(req.server)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	collection, err := plex.GetAllVideos(ctx, client, req.section)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	setServer(collection, server)
+	if !req.span {
+		span.SetStatus(codes.Ok, "collection retrieved")
+		return collection, nil
+	}
+
+	sections, err := plex.GetLibrarySections(ctx, client)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	idx := slices.IndexFunc(sections, func(s plex.Section) bool {
+		return s.Key == req.section
+	})
+	if idx < 0 {
+		err := fmt.Errorf("no section %q on Plex server %q", req.section, server)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	sectionType := sections[idx].Type
+
+	seen := make(map[string]bool, len(collection))
+	for _, video := range collection {
+		seen[video.PlexID] = true
+	}
+	for _, other := range serverConfig.Plex {
+		if other.Name == server {
+			continue
+		}
+		_, otherClient, err := plexServer(other.Name)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		otherSections, err := plex.GetLibrarySections(ctx, otherClient)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		for _, section := range plex.FilterSections(otherSections, other.LibrarySections) {
+			if section.Type != sectionType {
+				continue
+			}
+			videos, err := plex.GetAllVideos(ctx, otherClient, section.Key)
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+				return nil, err
+			}
+			for _, video := range videos {
+				if video.PlexID != "" && seen[video.PlexID] {
+					continue
+				}
+				seen[video.PlexID] = true
+				video.Server = other.Name
+				collection = append(collection, video)
+			}
+		}
+	}
+	span.SetAttributes(attribute.Int("count", len(collection)))
+	span.SetStatus(codes.Ok, "collection retrieved")
+	return collection, nil
+}
+
+// addLinks fills in the links that open each video on the Plex
+// server that has it. Videos cached before they were tagged with
+// a server are on the requested server.
+func addLinks(ctx context.Context, server string, videos []*plex.VideoShort) error {
+	byServer := make(map[string][]*plex.VideoShort)
+	for _, video := range videos {
+		if video.Server == "" {
+			video.Server = server
+		}
+		byServer[video.Server] = append(byServer[video.Server], video)
+	}
+	var errs []error
+	for name, serverVideos := range byServer {
+		_, client, err := plexServer(name)
+		if err == nil {
+			err = plex.AddLinks(ctx, client, serverVideos)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// matchCollection replaces each recommended video with the video
+// in the collection it refers to, matched by Plex ID or else by
+// title. Videos that can't be matched are returned as they are.
+func matchCollection(videos []*plex.VideoShort, collection []plex.VideoShort) []*plex.VideoShort {
+	matched := make([]*plex.VideoShort, 0, len(videos))
+	for _, video := range videos {
+		if video == nil {
+			continue
+		}
+		idx := slices.IndexFunc(collection, func(v plex.VideoShort) bool {
+			return video.PlexID != "" && v.PlexID == video.PlexID
+		})
+		if idx < 0 {
+			idx = slices.IndexFunc(collection, func(v plex.VideoShort) bool {
+				return strings.EqualFold(v.Title, video.Title)
+			})
+		}
+		if idx < 0 {
+			matched = append(matched, video)
+			continue
+		}
+		match := collection[idx]
+		matched = append(matched, &match)
+	}
+	return matched
+}
+
+// getWatched returns everything the account with
+// accountID watched in the requested section.
+func getWatched(ctx context.Context, req recommendationRequest, accountID string) ([]plex.VideoShort, error) {
+	id, err := strconv.Atoi(accountID)
+	if err != nil {
+		return nil, err
+	}
+	server, client, err := plexServer(req.server)
+	if err != nil {
+		return nil, err
+	}
+	watched, err := plex.GetWatched(ctx, client, req.section, plex.WithAccountID(id))
+	if err != nil {
+		return nil, err
+	}
+	setServer(watched, server)
+	return watched, nil
+}
+
+// watchedBy returns how to tell whether the requested user has
+// already watched a video. Plex's play counts and show progress
+// belong to the account that owns the server, so a request for a
+// user goes by everything that user watched instead.
+func watchedBy(history, accountWatched []plex.VideoShort, accountID string) func(plex.VideoShort) bool {
+	if accountID == "" {
+		return func(v plex.VideoShort) bool {
+			return plex.HasWatched(v, history)
+		}
+	}
+	watched := append(slices.Clip(accountWatched), history...)
+	return func(v plex.VideoShort) bool {
+		return plex.InHistory(v, watched)
+	}
+}
+
+// dropWatched removes the recommended videos that
+// have already been watched.
+func dropWatched(videos []*plex.VideoShort, watched func(plex.VideoShort) bool) []*plex.VideoShort {
+	unwatched := make([]*plex.VideoShort, 0, len(videos))
+	for _, video := range videos {
+		if watched(*video) {
+			log.Printf("dropping already watched recommendation %q\n", video.Title)
+			continue
+		}
+		unwatched = append(unwatched, video)
+	}
+	return unwatched
+}
+
+// Where a recommendation can be written back to in Plex.
+const (
+	writebackPlaylist   = "playlist"
+	writebackCollection = "collection"
+)
+
+// hasRatingKeys reports whether a recommendation of videos
+// knows where each of them is in Plex.
+func hasRatingKeys(resp *llmResponse) bool {
+	for _, video := range resp.Videos {
+		if video.RatingKey == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// writebackResult describes where in Plex a
+// recommendation was written to.
+type writebackResult struct {
+	Target    string `json:"target"`
+	Title     string `json:"title"`
+	ItemCount int    `json:"item_count"`
+	// Skipped are the titles that could not be written
+	// back, as they are on another server.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// writeBack saves the recommended videos to a playlist or a
+// collection in the requested section so they can be found in
+// any Plex app. Each user has their own playlist or collection,
+// and its contents are replaced every time. A user's playlist is
+// created with their own account so it shows up in their apps.
+// Only videos on the requested server are saved.
+func writeBack(ctx context.Context, req recommendationRequest, resp *llmResponse) (*writebackResult, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Write Back Recommendation"))
+	defer span.End()
+	span.SetAttributes(attribute.String("target", req.writeback))
+	server, client, err := plexServer(req.server)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	title := "Recommended"
+	var account *plex.Account
+	if req.user != "" {
+		account, err = findAccount(ctx, client, req.user)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		title = "Recommended for " + account.Name
+	}
+
+	if !hasRatingKeys(resp) {
+		err := errors.New("recommendation does not say where its videos are in Plex, ask for a new one to write it back")
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	videos := make([]plex.VideoShort, 0, len(resp.Videos))
+	var skipped []string
+	for _, video := range resp.Videos {
+		if video.Server != "" && video.Server != server {
+			log.Printf("%q is on Plex server %s, skipping write back\n", video.Title, video.Server)
+			skipped = append(skipped, video.Title)
+			continue
+		}
+		videos = append(videos, *video)
+	}
+
+	switch req.writeback {
+	case writebackPlaylist:
+		ratingKeys := make([]int, 0, len(videos))
+		for _, video := range videos {
+			ratingKeys = append(ratingKeys, video.RatingKey)
+		}
+		// playlists belong to whoever creates them, so
+		// create it as the user it's recommended for
+		if account != nil {
+			settings, err := serverSettings(server)
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+				return nil, err
+			}
+			client, err = plex.AsAccount(ctx, client, pinClient(settings), account.ID)
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+				return nil, err
+			}
+		}
+		if _, err := plex.SetPlaylist(ctx, client, title, ratingKeys); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+	case writebackCollection:
+		section := req.section
+		if section == "" {
+			section = client.GetDefaultLibrarySection()
+		}
+		if err := plex.SetCollection(ctx, client, section, title, videos); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+	default:
+		err := fmt.Errorf("cannot write a recommendation back to %q, use %q or %q", req.writeback, writebackPlaylist, writebackCollection)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetStatus(codes.Ok, "recommendation written back")
+	return &writebackResult{Target: req.writeback, Title: title, ItemCount: len(videos), Skipped: skipped}, nil
+}
+
+// getSections lists the named Plex server's library sections and
+// flags those that have media stored in the vector store.
+func getSections(ctx context.Context, name string) ([]sectionResponse, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Get Sections"))
+	defer span.End()
+	server, client, err := plexServer(name)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	summaries, err := plex.GetSectionSummaries(ctx, client)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	counts, err := weaviate.CountBySection(ctx, weaviate.VideoClass.Class, server)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	albumCounts, err := weaviate.CountBySection(ctx, weaviate.AlbumClass.Class, server)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	sections := make([]sectionResponse, 0, len(summaries))
+	for _, summary := range summaries {
+		sections = append(sections, sectionResponse{
+			SectionSummary: summary,
+			Ingested:       counts[summary.ID] > 0 || albumCounts[summary.ID] > 0,
+		})
+	}
+	span.SetStatus(codes.Ok, "sections retrieved")
+	return sections, nil
+}
+
+// syncOptions returns the options that sync the
+// libraries of every configured Plex server.
+func syncOptions() ([]weaviate.InitOption, error) {
+	opts := make([]weaviate.InitOption, 0, len(serverConfig.Plex)+2)
+	opts = append(opts, weaviate.WithIngest(serverConfig.Ingest), weaviate.WithSyncStore(syncStore{}))
+	for _, server := range serverConfig.Plex {
+		// clients are looked up each time since signing
+		// in again replaces a server's client
+		_, client, err := plexServer(server.Name)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, weaviate.WithPlexServer(weaviate.PlexServer{
+			Name:            server.Name,
+			Client:          client,
+			LibrarySections: server.LibrarySections,
+		}))
+	}
+	return opts, nil
+}
+
+// checkImageSection returns an error wrapping plex.ErrNotFound
+// unless the media with ratingKey is in one of the sections the
+// named server's media is recommended from, so the image proxy
+// can't be used to reach anything else on the server.
+func checkImageSection(ctx context.Context, server string, client plex.Client, ratingKey int) error {
+	settings, err := serverSettings(server)
+	if err != nil {
+		return err
+	}
+	sectionID, err := plex.GetLibrarySectionID(ctx, client, ratingKey)
+	if err != nil {
+		return err
+	}
+	sections, err := plex.GetLibrarySections(ctx, client)
+	if err != nil {
+		return err
+	}
+	allowed := plex.FilterSections(sections, settings.LibrarySections)
+	if !slices.ContainsFunc(allowed, func(s plex.Section) bool { return s.Key == sectionID }) {
+		return fmt.Errorf("%w: rating key %d isn't in a section recommendations are made from", plex.ErrNotFound, ratingKey)
+	}
+	return nil
+}
+
+// syncStore keeps when each library section
+// was last synced in the cache store.
+type syncStore struct{}
+
+func (syncStore) LoadSectionSyncs(ctx context.Context) ([]weaviate.SectionSyncStatus, error) {
+	synced, err := pg.GetSectionSyncs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]weaviate.SectionSyncStatus, 0, len(synced))
+	for _, s := range synced {
+		statuses = append(statuses, weaviate.SectionSyncStatus{
+			Server:          s.Server,
+			Section:         s.Section,
+			Title:           s.Title,
+			UpdatedAt:       s.PlexUpdatedAt,
+			SyncedUpdatedAt: s.PlexUpdatedAt,
+			SyncedAt:        s.SyncedAt,
+		})
+	}
+	return statuses, nil
+}
+
+func (syncStore) SaveSectionSync(ctx context.Context, s weaviate.SectionSyncStatus) error {
+	return pg.SaveSectionSync(ctx, pg.SectionSync{
+		Server:        s.Server,
+		Section:       s.Section,
+		Title:         s.Title,
+		PlexUpdatedAt: s.SyncedUpdatedAt,
+		SyncedAt:      s.SyncedAt,
+	})
+}
+
+// syncLibraries syncs the libraries of every configured Plex
+// server with the vector store and waits for it to finish.
+func syncLibraries(ctx context.Context, full bool) error {
+	opts, err := syncOptions()
+	if err != nil {
+		return err
+	}
+	if full {
+		opts = append(opts, weaviate.WithFullSync())
+	}
+	return weaviate.Sync(ctx, ollamaEmbedder, opts...)
+}
+
+// secretMatches reports whether the secret a caller provided is
+// the configured one. Nothing matches a secret that isn't set.
+func secretMatches(provided, secret string) bool {
+	if secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) == 1
+}
+
+// handleWebhook reacts to the events from the named Plex
+// server that change what we would recommend.
+func handleWebhook(ctx context.Context, server string, p *plex.WebhookPayload) error {
+	switch p.Event {
+	case plex.WebhookLibraryNew:
+		return ingestNewMedia(ctx, server, p.Metadata)
+	case plex.WebhookMediaScrobble:
+		return handleScrobble(ctx, server, p)
+	}
+	return nil
+}
+
+// ingestNewMedia embeds and stores media that was just
+// added to the named Plex server.
+func ingestNewMedia(ctx context.Context, server string, m plex.WebhookMetadata) error {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Ingest New Media"))
+	defer span.End()
+	_, client, err := plexServer(server)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	serverSettings, _ := serverConfig.PlexServer(server)
+	section := plex.Section{Key: m.SectionID(), Type: m.LibrarySectionType}
+	if len(plex.FilterSections([]plex.Section{section}, serverSettings.LibrarySections)) == 0 {
+		log.Println("skipping new media in section ", section.Key)
+		span.SetStatus(codes.Ok, "section not ingested")
+		return nil
+	}
+
+	if section.IsMusic() {
+		return ingestNewAlbum(ctx, server, client, section, m)
+	}
+
+	ratingKey := m.MediaRatingKey()
+	if ratingKey == 0 {
+		err := fmt.Errorf("no rating key for new media %q", m.Title)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	video, err := plex.GetMetadata(ctx, client, ratingKey)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	video.SectionID = section.Key
+	video.Server = server
+
+	// a new episode of a show we already have doesn't
+	// need the show stored again
+	exists, err := weaviate.VideoExists(ctx, *video)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if exists {
+		span.SetStatus(codes.Ok, "media already stored")
+		return nil
+	}
+
+	if err := weaviate.InsertData(ctx, ollamaEmbedder, weaviate.WithVideos([]plex.VideoShort{*video})); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetStatus(codes.Ok, "new media stored")
+	return nil
+}
+
+// ingestNewAlbum embeds and stores the album that new music
+// belongs to. Artists are stored as part of their albums.
+func ingestNewAlbum(ctx context.Context, server string, client plex.Client, section plex.Section, m plex.WebhookMetadata) error {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Ingest New Album"))
+	defer span.End()
+	if m.Type == "artist" {
+		span.SetStatus(codes.Ok, "artists are stored with their albums")
+		return nil
+	}
+	ratingKey := m.MediaRatingKey()
+	if ratingKey == 0 {
+		err := fmt.Errorf("no rating key for new music %q", m.Title)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	album, err := plex.GetAlbum(ctx, client, ratingKey)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	album.SectionID = section.Key
+	album.Server = server
+
+	// a new track on an album we already have doesn't
+	// need the album stored again
+	exists, err := weaviate.AlbumExists(ctx, *album)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if exists {
+		span.SetStatus(codes.Ok, "album already stored")
+		return nil
+	}
+
+	if err := weaviate.InsertData(ctx, ollamaEmbedder, weaviate.WithAlbums([]plex.AlbumShort{*album})); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetStatus(codes.Ok, "new album stored")
+	return nil
+}
+
+// handleScrobble drops the cached recommendations that a
+// finished play on the named server makes stale, and optionally
+// generates the user's next recommendation ahead of time.
+func handleScrobble(ctx context.Context, server string, p *plex.WebhookPayload) error {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Handle Scrobble"))
+	defer span.End()
+	accountID := strconv.Itoa(p.Account.ID)
+	// the server wide recently viewed changes with
+	// every play, so those recommendations go too
+	if err := pg.DeleteData(ctx, server, "", accountID); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.AddEvent("cache invalidated")
+
+	if serverConfig.Webhooks.Pregenerate {
+		req := recommendationRequest{
+			server:  server,
+			section: p.Metadata.SectionID(),
+			limit:   serverConfig.RecentMovieCount,
+			user:    accountID,
+		}
+		music := plex.Section{Type: p.Metadata.LibrarySectionType}.IsMusic()
+		go func() {
+			ctx := context.WithoutCancel(ctx)
+			var err error
+			if music {
+				_, err = getMusicRecommendation(ctx, req)
+			} else {
+				_, err = getRecommendation(ctx, req)
+			}
+			if err != nil {
+				log.Println("could not pregenerate recommendation: ", err.Error())
+			}
+		}()
+		span.AddEvent("pregeneration started")
+	}
+	span.SetStatus(codes.Ok, "scrobble handled")
+	return nil
 }
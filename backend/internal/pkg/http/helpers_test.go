@@ -1,8 +1,16 @@
 package httpinternal
 
 import (
+	"encoding/json"
+	"errors"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex"
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/weaviate"
 )
 
 func TestBuildStringFromSlice(t *testing.T) {
@@ -42,3 +50,197 @@ func TestBuildStringFromSlice(t *testing.T) {
 		})
 	}
 }
+
+func TestMatchCollection(t *testing.T) {
+	collection := []plex.VideoShort{
+		{Title: "Movie A", PlexID: "plex://movie/a", RatingKey: 20, Type: "movie", Summary: "Action-packed"},
+		{Title: "The Office", PlexID: "plex://show/office", RatingKey: 10, Type: "show"},
+	}
+	videos := []*plex.VideoShort{
+		{Title: "Movie A (2001)", PlexID: "plex://movie/a"},
+		{Title: "the office"},
+		{Title: "Not In The Library"},
+		nil,
+	}
+
+	expected := []*plex.VideoShort{
+		&collection[0],
+		&collection[1],
+		{Title: "Not In The Library"},
+	}
+	if result := matchCollection(videos, collection); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestMatchAlbums(t *testing.T) {
+	collection := []plex.AlbumShort{
+		{Title: "Greatest Hits", Artist: "Queen", PlexID: "plex://album/queen-hits", RatingKey: 50},
+		{Title: "Greatest Hits", Artist: "ABBA", PlexID: "plex://album/abba-hits", RatingKey: 51},
+		{Title: "Kind of Blue", Artist: "Miles Davis", PlexID: "plex://album/kind-of-blue", RatingKey: 41},
+	}
+	albums := []*plex.AlbumShort{
+		{Title: "greatest hits", Artist: "abba"},
+		{Title: "Kind Of Blue (Legacy Edition)", PlexID: "plex://album/kind-of-blue"},
+		{Title: "Not In The Library", Artist: "Nobody"},
+		nil,
+	}
+
+	expected := []*plex.AlbumShort{
+		&collection[1],
+		&collection[2],
+		{Title: "Not In The Library", Artist: "Nobody"},
+	}
+	if result := matchAlbums(albums, collection); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestAddScores(t *testing.T) {
+	similar := []*plex.VideoShort{
+		{Title: "Spirited Away", PlexID: "plex://movie/spirited-away", Server: "home", Distance: ptr(0.0), Score: ptr(1.0)},
+		{Title: "Ponyo", Server: "home", Distance: ptr(0.3), Score: ptr(0.7)},
+		{Title: "Ponyo", Server: "cabin", Distance: ptr(0.2), Score: ptr(0.8)},
+	}
+	videos := []*plex.VideoShort{
+		{Title: "Spirited Away (2001)", PlexID: "plex://movie/spirited-away", Server: "home"},
+		{Title: "ponyo", Server: "cabin"},
+		{Title: "Not Retrieved", Server: "home"},
+	}
+
+	addScores(videos, similar)
+	expected := []*plex.VideoShort{
+		{Title: "Spirited Away (2001)", PlexID: "plex://movie/spirited-away", Server: "home", Distance: ptr(0.0), Score: ptr(1.0)},
+		{Title: "ponyo", Server: "cabin", Distance: ptr(0.2), Score: ptr(0.8)},
+		{Title: "Not Retrieved", Server: "home"},
+	}
+	if !reflect.DeepEqual(videos, expected) {
+		t.Errorf("expected %+v, got %+v", expected, videos)
+	}
+	// an exact match is still reported
+	if b, _ := json.Marshal(videos[0]); !strings.Contains(string(b), `"distance":0,`) {
+		t.Errorf("expected a distance of 0 in %s", b)
+	}
+}
+
+func ptr(f float64) *float64 {
+	return &f
+}
+
+func TestDropWatched(t *testing.T) {
+	videos := []*plex.VideoShort{
+		{Title: "Played Movie", RatingKey: 1, Type: "movie", ViewCount: 3},
+		{Title: "New Movie", RatingKey: 2, Type: "movie"},
+		{Title: "Recently Watched", RatingKey: 3, Type: "movie"},
+	}
+	history := []plex.VideoShort{{Title: "Recently Watched", RatingKey: 3}}
+
+	expected := []*plex.VideoShort{videos[1]}
+	watched := func(v plex.VideoShort) bool {
+		return plex.HasWatched(v, history)
+	}
+	if result := dropWatched(videos, watched); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestWeighHistory(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	watched := now.Unix()
+	history := []plex.VideoShort{
+		{Title: "Watched Once", Type: "movie", ViewCount: 1, LastViewedAt: watched},
+		{Title: "Rewatched", Type: "movie", ViewCount: 3, LastViewedAt: watched},
+		{Title: "Loved", Type: "movie", ViewCount: 1, UserRating: 10, LastViewedAt: watched},
+		{Title: "Half Watched", Type: "movie", Duration: 100, ViewOffset: 50, LastViewedAt: watched},
+		{Title: "Abandoned", Type: "movie", Duration: 100, ViewOffset: 10, LastViewedAt: watched},
+		{Title: "Finished Before", Type: "movie", ViewCount: 1, Duration: 100, ViewOffset: 10, LastViewedAt: watched},
+		{Title: "Long Ago", Type: "movie", ViewCount: 1, LastViewedAt: now.Add(-recencyHalfLife).Unix()},
+		{Title: "Binged Twice", Type: "show", EpisodeCount: 10, ViewCount: 20, LastViewedAt: watched},
+	}
+	expected := map[string]float64{
+		"Watched Once":    1,
+		"Rewatched":       2,
+		"Loved":           2,
+		"Half Watched":    0.5,
+		"Finished Before": 1,
+		"Long Ago":        0.75,
+		"Binged Twice":    1.5,
+	}
+
+	weighted := weighHistory(history, now)
+	if len(weighted) != len(expected) {
+		t.Fatalf("expected %d videos, got %+v", len(expected), weighted)
+	}
+	for _, w := range weighted {
+		want, ok := expected[w.video.Title]
+		if !ok {
+			t.Errorf("expected %s to be left out", w.video.Title)
+			continue
+		}
+		if math.Abs(w.weight-want) > 1e-9 {
+			t.Errorf("expected %s to weigh %v, got %v", w.video.Title, want, w.weight)
+		}
+	}
+
+	loved := weightedVideo{video: history[2], weight: 2, reasons: []string{"rated 10/10"}}
+	if s := loved.String(); s != "Loved (weight 2.00: rated 10/10)" {
+		t.Errorf("unexpected description %q", s)
+	}
+}
+
+func TestForAccount(t *testing.T) {
+	// what Plex has is the owner's, who rated Movie A
+	// and stopped part of the way through Movie B
+	history := []plex.VideoShort{
+		{Title: "Movie A", RatingKey: 20, Type: "movie", ViewCount: 1, UserRating: 2, LastViewedAt: 100, Server: "home"},
+		{Title: "Movie B", RatingKey: 21, Type: "movie", Duration: 100, ViewOffset: 10, Server: "home"},
+	}
+	accountWatched := []plex.VideoShort{
+		{Title: "Movie A", RatingKey: 20, Type: "movie", ViewCount: 3, LastViewedAt: 200, Server: "home"},
+	}
+
+	expected := []plex.VideoShort{
+		{Title: "Movie A", RatingKey: 20, Type: "movie", ViewCount: 3, LastViewedAt: 200, Server: "home"},
+		{Title: "Movie B", RatingKey: 21, Type: "movie", Duration: 100, Server: "home"},
+	}
+	if result := forAccount(history, accountWatched); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestWeightSettings(t *testing.T) {
+	weighted := []weightedVideo{
+		{video: plex.VideoShort{Title: "Movie B"}, weight: 0.5},
+		{video: plex.VideoShort{Title: "Movie A"}, weight: 2},
+	}
+	reordered := []weightedVideo{weighted[1], weighted[0]}
+	if weightSettings(weighted) != weightSettings(reordered) {
+		t.Error("expected the order of the history not to matter")
+	}
+	reweighed := []weightedVideo{weighted[0], {video: weighted[1].video, weight: 1}}
+	if weightSettings(weighted) == weightSettings(reweighed) {
+		t.Error("expected different weights to be told apart")
+	}
+}
+
+func TestExplainVectorQueryError(t *testing.T) {
+	err := explainVectorQueryError(weaviate.ErrNothingClose)
+	if !errors.Is(err, weaviate.ErrNothingClose) || !strings.Contains(err.Error(), "VECTOR_QUERY_FALLBACK") {
+		t.Errorf("expected the error to say how to find more, got %v", err)
+	}
+	other := errors.New("weaviate is down")
+	if explainVectorQueryError(other) != other {
+		t.Error("expected other errors to be left alone")
+	}
+}
+
+func TestDropMissingArtists(t *testing.T) {
+	artists := []*plex.ArtistShort{
+		{Name: "Radiohead", RatingKey: 30},
+		{Name: "Made Up By The LLM"},
+	}
+	expected := []*plex.ArtistShort{artists[0]}
+	if result := dropMissingArtists(artists); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
@@ -0,0 +1,492 @@
+package httpinternal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/config"
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex"
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex/plextest"
+)
+
+// usePlexServer points the server's default Plex server, named
+// home, at a fake Plex for the duration of the test.
+func usePlexServer(t *testing.T, server *plextest.Server) {
+	t.Helper()
+	previousClients, previousDefault, previousConfig := plexClients, defaultServer, serverConfig
+	t.Cleanup(func() {
+		plexClients, defaultServer, serverConfig = previousClients, previousDefault, previousConfig
+	})
+	plexClients = map[string]*plex.PlexClient{}
+	defaultServer = "home"
+	serverConfig = &config.Config{RecentMovieCount: 5, MaxHistorySize: 50}
+	addPlexServer(t, "home", server)
+}
+
+// addPlexServer adds another fake Plex server with the
+// provided name. Call it after usePlexServer.
+func addPlexServer(t *testing.T, name string, server *plextest.Server) {
+	t.Helper()
+	settings := config.PlexServer{
+		Name:                  name,
+		Token:                 server.Token(),
+		TokenFile:             filepath.Join(t.TempDir(), name+".token"),
+		Address:               server.URL,
+		DefaultLibrarySection: "1",
+	}
+	client, err := newPlexClient(settings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plexClients[name] = client
+	serverConfig.Plex = append(serverConfig.Plex, settings)
+}
+
+// kidToken is the token the Kid account uses on the test server.
+const kidToken = "kid-token"
+
+func newTestServer(t *testing.T) *plextest.Server {
+	t.Helper()
+	watchedAt := time.Now().Add(-time.Hour)
+	server := plextest.NewServer(
+		plextest.WithSection(plextest.Section{Key: "1", Type: "movie", Title: "Movies", Items: []plextest.Item{
+			{RatingKey: 20, Guid: "plex://movie/a", Title: "Movie A", Summary: "Action-packed"},
+			{RatingKey: 21, Guid: "plex://movie/b", Title: "Movie B", Summary: "Heartwarming"},
+		}}),
+		plextest.WithSection(plextest.Section{Key: "3", Type: "artist", Title: "Music", Items: []plextest.Item{
+			{RatingKey: 30, Guid: "plex://artist/radiohead", Title: "Radiohead", Albums: []plextest.Album{
+				{RatingKey: 31, Guid: "plex://album/ok-computer", Title: "OK Computer", Tracks: []plextest.Track{
+					{RatingKey: 32, Title: "Airbag", Index: 1},
+				}},
+			}},
+			{RatingKey: 40, Guid: "plex://artist/miles-davis", Title: "Miles Davis", Albums: []plextest.Album{
+				{RatingKey: 41, Guid: "plex://album/kind-of-blue", Title: "Kind of Blue", Tracks: []plextest.Track{
+					{RatingKey: 42, Title: "So What", Index: 1},
+				}},
+			}},
+		}}),
+		plextest.WithAccount(1, "owner"),
+		plextest.WithAccount(2, "Kid"),
+		plextest.WithAccountToken(2, kidToken),
+		plextest.WithPlays(
+			plextest.Play{AccountID: 2, RatingKey: 20, ViewedAt: watchedAt},
+			plextest.Play{AccountID: 1, RatingKey: 21, ViewedAt: watchedAt.AddDate(0, 0, -60)},
+			plextest.Play{AccountID: 1, RatingKey: 32, ViewedAt: watchedAt},
+			plextest.Play{AccountID: 2, RatingKey: 42, ViewedAt: watchedAt.AddDate(0, 0, -60)},
+		),
+	)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestUsersHandler(t *testing.T) {
+	usePlexServer(t, newTestServer(t))
+
+	recorder := httptest.NewRecorder()
+	usersHandler(recorder, httptest.NewRequest(http.MethodGet, "/users", nil))
+
+	var accounts []plex.Account
+	if err := json.Unmarshal(recorder.Body.Bytes(), &accounts); err != nil {
+		t.Fatalf("unexpected response %q: %v", recorder.Body.String(), err)
+	}
+	expected := []plex.Account{{ID: 1, Name: "owner"}, {ID: 2, Name: "Kid"}}
+	if !reflect.DeepEqual(accounts, expected) {
+		t.Errorf("expected %+v, got %+v", expected, accounts)
+	}
+}
+
+func TestGetHistory(t *testing.T) {
+	usePlexServer(t, newTestServer(t))
+
+	testCases := []struct {
+		name      string
+		req       recommendationRequest
+		expected  []string
+		accountID string
+	}{
+		{name: "Recently Viewed", req: recommendationRequest{section: "1"}, expected: []string{"Movie A", "Movie B"}},
+		{name: "User", req: recommendationRequest{section: "1", user: "kid"}, expected: []string{"Movie A"}, accountID: "2"},
+		{name: "Window", req: recommendationRequest{section: "1", since: time.Now().AddDate(0, 0, -30)}, expected: []string{"Movie A"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			history, accountID, err := getHistory(context.Background(), tc.req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			titles := make([]string, 0, len(history))
+			for _, video := range history {
+				if video.Server != "home" {
+					t.Errorf("expected %q on server home, got %q", video.Title, video.Server)
+				}
+				titles = append(titles, video.Title)
+			}
+			if !reflect.DeepEqual(titles, tc.expected) || accountID != tc.accountID {
+				t.Errorf("expected %v for account %q, got %v for %q", tc.expected, tc.accountID, titles, accountID)
+			}
+		})
+	}
+}
+
+func TestGetListeningHistory(t *testing.T) {
+	usePlexServer(t, newTestServer(t))
+
+	testCases := []struct {
+		name      string
+		req       recommendationRequest
+		expected  []string
+		accountID string
+	}{
+		{name: "Recently Played", req: recommendationRequest{section: "3"}, expected: []string{"OK Computer", "Kind of Blue"}},
+		{name: "User", req: recommendationRequest{section: "3", user: "kid"}, expected: []string{"Kind of Blue"}, accountID: "2"},
+		{name: "Window", req: recommendationRequest{section: "3", since: time.Now().AddDate(0, 0, -30)}, expected: []string{"OK Computer"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			history, accountID, err := getListeningHistory(context.Background(), tc.req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			titles := make([]string, 0, len(history))
+			for _, album := range history {
+				if album.Server != "home" {
+					t.Errorf("expected %q on server home, got %q", album.Title, album.Server)
+				}
+				titles = append(titles, album.Title)
+			}
+			if !reflect.DeepEqual(titles, tc.expected) || accountID != tc.accountID {
+				t.Errorf("expected %v for account %q, got %v for %q", tc.expected, tc.accountID, titles, accountID)
+			}
+		})
+	}
+}
+
+func TestWatchedBy(t *testing.T) {
+	server := newTestServer(t)
+	usePlexServer(t, server)
+
+	// the owner's play of Movie B shows up in its view count,
+	// which mustn't count as the kid having watched it
+	ownerPlayed := plex.VideoShort{Title: "Movie B", RatingKey: 21, Type: "movie", ViewCount: 1, Server: "home"}
+	kidPlayed := plex.VideoShort{Title: "Movie A", RatingKey: 20, Type: "movie", Server: "home"}
+
+	kidWatched, err := getWatched(context.Background(), recommendationRequest{section: "1"}, "2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	watched := watchedBy(nil, kidWatched, "2")
+	if !watched(kidPlayed) || watched(ownerPlayed) {
+		t.Errorf("expected only Movie A to be watched by the kid")
+	}
+
+	watched = watchedBy(nil, nil, "")
+	if !watched(ownerPlayed) {
+		t.Errorf("expected Movie B to be watched by the owner")
+	}
+}
+
+func TestPlayedBy(t *testing.T) {
+	server := newTestServer(t)
+	usePlexServer(t, server)
+
+	// the owner's plays of OK Computer show up in its view
+	// count, which mustn't count as the kid having heard it
+	okComputer := plex.AlbumShort{Title: "OK Computer", Artist: "Radiohead", RatingKey: 31, ArtistRatingKey: 30, ViewCount: 1, Server: "home"}
+	kindOfBlue := plex.AlbumShort{Title: "Kind of Blue", Artist: "Miles Davis", RatingKey: 41, ArtistRatingKey: 40, Server: "home"}
+	radiohead := plex.ArtistShort{Name: "Radiohead", RatingKey: 30, ViewCount: 1, Server: "home"}
+	milesDavis := plex.ArtistShort{Name: "Miles Davis", RatingKey: 40, Server: "home"}
+
+	kidListened, err := getListened(context.Background(), recommendationRequest{section: "3"}, "2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	played := playedBy(nil, kidListened, "2")
+	if !played(kindOfBlue) || played(okComputer) {
+		t.Errorf("expected only Kind of Blue to be played by the kid")
+	}
+	playedArtist := artistPlayedBy(nil, kidListened, "2")
+	if !playedArtist(milesDavis) || playedArtist(radiohead) {
+		t.Errorf("expected only Miles Davis to be played by the kid")
+	}
+
+	if !playedBy(nil, nil, "")(okComputer) || !artistPlayedBy(nil, nil, "")(radiohead) {
+		t.Errorf("expected Radiohead to be played by the owner")
+	}
+}
+
+func TestWriteBackPlaylist(t *testing.T) {
+	server := newTestServer(t)
+	usePlexServer(t, server)
+	tv := plextest.NewPlexTV()
+	t.Cleanup(tv.Close)
+	tv.Share(plextest.DefaultMachineIdentifier, server.Token(), 2, kidToken)
+	serverConfig.PlexTVURL = tv.URL
+
+	resp := &llmResponse{Videos: []*plex.VideoShort{
+		{Title: "Movie B", RatingKey: 21},
+		{Title: "On Another Server", RatingKey: 20, Server: "cabin"},
+	}}
+	result, err := writeBack(context.Background(), recommendationRequest{section: "1", user: "kid", writeback: writebackPlaylist}, resp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := writebackResult{Target: writebackPlaylist, Title: "Recommended for Kid", ItemCount: 1, Skipped: []string{"On Another Server"}}
+	if !reflect.DeepEqual(*result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, *result)
+	}
+
+	playlists := server.Playlists()
+	if len(playlists) != 1 || playlists[0].Title != "Recommended for Kid" || playlists[0].AccountID != 2 || !slices.Equal(playlists[0].Items, []int{21}) {
+		t.Errorf("unexpected playlists %+v", playlists)
+	}
+}
+
+func TestWriteBackWithoutRatingKeys(t *testing.T) {
+	server := newTestServer(t)
+	usePlexServer(t, server)
+
+	resp := &llmResponse{Videos: []*plex.VideoShort{
+		{Title: "Movie B", RatingKey: 21},
+		{Title: "Cached Before Rating Keys"},
+	}}
+	if _, err := writeBack(context.Background(), recommendationRequest{section: "1", writeback: writebackPlaylist}, resp); err == nil {
+		t.Fatal("expected an error")
+	}
+	if playlists := server.Playlists(); len(playlists) != 0 {
+		t.Errorf("expected no playlists, got %+v", playlists)
+	}
+}
+
+func TestImageHandler(t *testing.T) {
+	usePlexServer(t, newTestServer(t))
+
+	testCases := []struct {
+		name      string
+		ratingKey string
+		query     string
+		status    int
+		expected  string
+	}{
+		{name: "Thumb", ratingKey: "20", status: http.StatusOK, expected: "thumb of 20"},
+		{name: "Resized Art", ratingKey: "20", query: "?kind=art&width=300&height=450", status: http.StatusOK, expected: "art of 20 at 300x450"},
+		{name: "Capped Size", ratingKey: "20", query: "?width=9000&height=9000", status: http.StatusOK, expected: "thumb of 20 at 2000x2000"},
+		{name: "Unknown Kind", ratingKey: "20", query: "?kind=banner", status: http.StatusBadRequest},
+		{name: "Missing Media", ratingKey: "404", status: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/images/"+tc.ratingKey+tc.query, nil)
+			req.SetPathValue("ratingKey", tc.ratingKey)
+			recorder := httptest.NewRecorder()
+			imageHandler(recorder, req)
+
+			if recorder.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, recorder.Code, recorder.Body.String())
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			if recorder.Body.String() != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, recorder.Body.String())
+			}
+			if recorder.Header().Get("Cache-Control") != imageCacheControl || recorder.Header().Get("Content-Type") != "image/jpeg" {
+				t.Errorf("unexpected headers %v", recorder.Header())
+			}
+		})
+	}
+}
+
+func TestImageHandlerOnlyServesRecommendedSections(t *testing.T) {
+	server := plextest.NewServer(
+		plextest.WithSection(plextest.Section{Key: "1", Type: "movie", Title: "Movies", Items: []plextest.Item{
+			{RatingKey: 20, Guid: "plex://movie/a", Title: "Movie A"},
+		}}),
+		plextest.WithSection(plextest.Section{Key: "2", Type: "show", Title: "TV Shows", Items: []plextest.Item{
+			{RatingKey: 10, Guid: "plex://show/office", Title: "The Office"},
+		}}),
+		// photos are never ingested
+		plextest.WithSection(plextest.Section{Key: "5", Type: "photo", Title: "Photos", Items: []plextest.Item{
+			{RatingKey: 50, Title: "Holiday"},
+		}}),
+	)
+	t.Cleanup(server.Close)
+	usePlexServer(t, server)
+	// the movies are left out of recommendations
+	serverConfig.Plex[0].LibrarySections = []string{"2", "5"}
+
+	req := httptest.NewRequest(http.MethodGet, "/images/10", nil)
+	req.SetPathValue("ratingKey", "10")
+	recorder := httptest.NewRecorder()
+	imageHandler(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected a show's image, got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	for _, ratingKey := range []string{"20", "50"} {
+		req := httptest.NewRequest(http.MethodGet, "/images/"+ratingKey, nil)
+		req.SetPathValue("ratingKey", ratingKey)
+		recorder := httptest.NewRecorder()
+		imageHandler(recorder, req)
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("expected %s to be refused with status %d, got %d: %s", ratingKey, http.StatusNotFound, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+// newCabinServer serves a second Plex server sharing one
+// movie with newTestServer, under a different rating key.
+func newCabinServer(t *testing.T) *plextest.Server {
+	t.Helper()
+	server := plextest.NewServer(
+		plextest.WithMachineIdentifier("cabin-machine"),
+		plextest.WithSection(plextest.Section{Key: "4", Type: "movie", Title: "Cabin Movies", Items: []plextest.Item{
+			{RatingKey: 20, Guid: "plex://movie/c", Title: "Movie C", Summary: "Terrifying"},
+			{RatingKey: 21, Guid: "plex://movie/b", Title: "Movie B", Summary: "Heartwarming"},
+		}}),
+		plextest.WithSection(plextest.Section{Key: "5", Type: "show", Title: "Cabin Shows", Items: []plextest.Item{
+			{RatingKey: 30, Guid: "plex://show/x", Title: "Show X"},
+		}}),
+	)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetCollectionSpansServers(t *testing.T) {
+	usePlexServer(t, newTestServer(t))
+	addPlexServer(t, "cabin", newCabinServer(t))
+
+	testCases := []struct {
+		name     string
+		req      recommendationRequest
+		expected []string
+	}{
+		{name: "One Server", req: recommendationRequest{server: "home", section: "1"}, expected: []string{"home/Movie A", "home/Movie B"}},
+		{name: "Spanning", req: recommendationRequest{server: "home", section: "1", span: true}, expected: []string{"home/Movie A", "home/Movie B", "cabin/Movie C"}},
+		{name: "From Cabin", req: recommendationRequest{server: "cabin", section: "4", span: true}, expected: []string{"cabin/Movie C", "cabin/Movie B", "home/Movie A"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			collection, err := getCollection(context.Background(), tc.req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			titles := make([]string, 0, len(collection))
+			for _, video := range collection {
+				titles = append(titles, video.Server+"/"+video.Title)
+			}
+			if !reflect.DeepEqual(titles, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, titles)
+			}
+		})
+	}
+}
+
+func TestAddLinksPerServer(t *testing.T) {
+	usePlexServer(t, newTestServer(t))
+	addPlexServer(t, "cabin", newCabinServer(t))
+
+	videos := []*plex.VideoShort{
+		{Title: "Movie A", RatingKey: 20},
+		{Title: "Movie C", RatingKey: 20, Server: "cabin"},
+	}
+	if err := addLinks(context.Background(), "home", videos); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, machineIdentifier := range []string{plextest.DefaultMachineIdentifier, "cabin-machine"} {
+		expected := plex.AppURL(machineIdentifier, *videos[i])
+		if videos[i].AppURL != expected {
+			t.Errorf("expected %q, got %q", expected, videos[i].AppURL)
+		}
+	}
+	if videos[0].Server != "home" {
+		t.Errorf("expected untagged video to be on home, got %q", videos[0].Server)
+	}
+}
+
+func TestUnknownServer(t *testing.T) {
+	usePlexServer(t, newTestServer(t))
+
+	req := httptest.NewRequest(http.MethodGet, "/servers/attic/images/20", nil)
+	req.SetPathValue("server", "attic")
+	req.SetPathValue("ratingKey", "20")
+	recorder := httptest.NewRecorder()
+	imageHandler(recorder, req)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/servers/attic/recommendation/1", nil)
+	req.SetPathValue("server", "attic")
+	if _, err := parseRecommendationRequest(req); err == nil {
+		t.Error("expected an error for an unknown server")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	server := newTestServer(t)
+	usePlexServer(t, server)
+	tv := plextest.NewPlexTV()
+	t.Cleanup(tv.Close)
+	serverConfig.PlexTVURL = tv.URL
+	// start out signed in with a token Plex no longer accepts
+	plexClients["home"] = plex.New("revoked-token", server.URL, "1")
+	serverConfig.Plex[0].Token = "revoked-token"
+
+	recorder := httptest.NewRecorder()
+	loginHandler(recorder, httptest.NewRequest(http.MethodPost, "/login", nil))
+	var login loginResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &login); err != nil {
+		t.Fatalf("unexpected response %q: %v", recorder.Body.String(), err)
+	}
+	if login.Code == "" || login.LinkURL != plex.LinkURL {
+		t.Fatalf("unexpected login %+v", login)
+	}
+
+	checkStatus := func() loginStatusResponse {
+		t.Helper()
+		pinID := strconv.Itoa(login.PinID)
+		req := httptest.NewRequest(http.MethodGet, "/login/"+pinID, nil)
+		req.SetPathValue("pin", pinID)
+		recorder := httptest.NewRecorder()
+		loginStatusHandler(recorder, req)
+		var status loginStatusResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+			t.Fatalf("unexpected response %q: %v", recorder.Body.String(), err)
+		}
+		return status
+	}
+	if status := checkStatus(); status.Linked {
+		t.Fatalf("expected the pin to not be linked yet, got %+v", status)
+	}
+
+	if !tv.Link(login.Code, server.Token()) {
+		t.Fatalf("no pin with code %q", login.Code)
+	}
+	if status := checkStatus(); !status.Linked || status.Server != "home" {
+		t.Fatalf("expected home to be linked, got %+v", status)
+	}
+
+	saved, err := config.ReadToken(serverConfig.Plex[0].TokenFile)
+	if err != nil || saved != server.Token() {
+		t.Errorf("expected the token to be saved, got %q, %v", saved, err)
+	}
+	if settings, _ := serverSettings("home"); settings.Token != server.Token() {
+		t.Errorf("expected the configured token to be replaced, got %q", settings.Token)
+	}
+	_, client, _ := plexServer("home")
+	if _, err := plex.GetAccounts(context.Background(), client); err != nil {
+		t.Errorf("expected the new token to be used, got %v", err)
+	}
+}
@@ -2,11 +2,16 @@ package httpinternal
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 
 	"github.com/tmc/langchaingo/llms/ollama"
@@ -18,7 +23,14 @@ import (
 )
 
 var (
-	plexClient     *plex.PlexClient
+	serverConfig *config.Config
+	// plexClients are the configured Plex servers by name. A
+	// client is replaced when its server is signed in to again.
+	plexClients   map[string]*plex.PlexClient
+	plexClientsMu sync.RWMutex
+	// defaultServer is the Plex server used by
+	// routes that don't name one
+	defaultServer  string
 	ollamaLlm      *ollama.LLM
 	ollamaEmbedder *ollama.LLM
 )
@@ -28,16 +40,24 @@ var (
 func StartServer(ctx context.Context, c *config.Config, shutdownChan chan error) {
 	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Start Server"), telemetry.WithSpanPackage("httpinternal"))
 	defer span.End()
-	initPlex(ctx, c)
+	serverConfig = c
+	if err := initPlex(ctx, c); err != nil {
+		panic("could not initialize plex client: " + err.Error())
+	}
 	if err := initLLM(ctx, c); err != nil {
 		panic("could not initialize llms: " + err.Error())
 	}
-	if err := initVectorStore(ctx); err != nil {
-		panic("could not init vector store: " + err.Error())
-	}
+	// the cache store also keeps when libraries were last
+	// synced, which the vector store needs on start up
 	if err := initCacheStore(ctx, c); err != nil {
 		panic("could not init cache store: " + err.Error())
 	}
+	if err := initVectorStore(ctx, c); err != nil {
+		panic("could not init vector store: " + err.Error())
+	}
+	if c.Sync.Interval > 0 {
+		go runSyncSchedule(ctx, c.Sync.Interval)
+	}
 	initHttpServer(shutdownChan)
 }
 
@@ -54,8 +74,29 @@ func initHttpServer(s chan error) {
 		mux.Handle(pattern, handler)
 	}
 
-	// Register handlers.
-	handleFunc(recommendationPathway, recommendationHandler)
+	// Register handlers. Each route is served for the default
+	// Plex server and under /servers/{server} for any server.
+	routes := []struct {
+		pattern string
+		handler func(http.ResponseWriter, *http.Request)
+	}{
+		{recommendationPathway, recommendationHandler},
+		{writebackPathway, writebackHandler},
+		{sectionsPathway, sectionsHandler},
+		{usersPathway, usersHandler},
+		{webhookPathway, webhookHandler},
+		{imagesPathway, imageHandler},
+		{loginPathway, adminOnly(loginHandler)},
+		{loginStatusPathway, adminOnly(loginStatusHandler)},
+		{musicPathway, musicRecommendationHandler},
+	}
+	for _, route := range routes {
+		handleFunc(route.pattern, route.handler)
+		handleFunc(serverPattern(route.pattern), route.handler)
+	}
+	handleFunc(serversPathway, serversHandler)
+	handleFunc(syncStatusPathway, adminOnly(syncStatusHandler))
+	handleFunc(syncPathway, adminOnly(throttle(syncRequestInterval, syncHandler)))
 
 	// Add HTTP instrumentation for the whole server.
 	handler := otelhttp.NewHandler(mux, "/")
@@ -66,19 +107,61 @@ func initHttpServer(s chan error) {
 	s <- nil
 }
 
-// initPlex creates the Plex client the server uses to
-// connect to and execute queries against Plex
-func initPlex(ctx context.Context, c *config.Config) {
-	log.Println("initialzing plex client...")
+// initPlex creates the Plex clients the server uses to
+// connect to and execute queries against each Plex server
+func initPlex(ctx context.Context, c *config.Config) error {
+	log.Println("initialzing plex clients...")
 	defer log.Println("initialized")
 	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Init Plex"))
 	defer span.End()
-	if plexClient != nil {
-		span.SetStatus(codes.Ok, "Plex client initialized previously")
-		return
+	if plexClients != nil {
+		span.SetStatus(codes.Ok, "Plex clients initialized previously")
+		return nil
 	}
-	plexClient = plex.New(c.Plex.Token, c.Plex.Address, c.Plex.DefaultLibrarySection)
-	span.SetStatus(codes.Ok, "Plex client initialized")
+	if len(c.Plex) == 0 {
+		err := errors.New("no Plex servers configured")
+		span.RecordError(err)
+		return err
+	}
+
+	clients := make(map[string]*plex.PlexClient, len(c.Plex))
+	for _, server := range c.Plex {
+		if _, ok := clients[server.Name]; ok {
+			err := fmt.Errorf("Plex server %q is configured more than once", server.Name)
+			span.RecordError(err)
+			return err
+		}
+		if server.Token == "" {
+			log.Printf("no token for Plex server %s, sign in with the login command or POST /servers/%s/login\n", server.Name, server.Name)
+		}
+		client, err := newPlexClient(server)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		clients[server.Name] = client
+	}
+	plexClients = clients
+	defaultServer = c.Plex[0].Name
+	span.SetAttributes(attribute.Int("servers", len(clients)))
+	span.SetStatus(codes.Ok, "Plex clients initialized")
+	return nil
+}
+
+// newPlexClient creates a client for the configured Plex server.
+func newPlexClient(s config.PlexServer) (*plex.PlexClient, error) {
+	var opts []plex.ClientOption
+	if s.ClientIdentifier != "" {
+		opts = append(opts, plex.WithClientIdentifier(s.ClientIdentifier))
+	}
+	if s.CAFile != "" || s.TLSServerName != "" || s.InsecureSkipVerify {
+		tlsConfig, err := plex.NewTLSConfig(s.CAFile, s.TLSServerName, s.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, plex.WithTLSConfig(tlsConfig))
+	}
+	return plex.New(s.Token, s.Address, s.DefaultLibrarySection, opts...), nil
 }
 
 // initLLM creates the Ollama LLM client the server uses
@@ -98,11 +181,32 @@ func initLLM(ctx context.Context, c *config.Config) error {
 // initVectorStore connects to Weaviate for storing
 // Plex data and related embeddings and performs
 // any migrations required for startup.
-func initVectorStore(ctx context.Context) error {
-	if err := weaviate.InitWeaviate(ctx, plexClient, ollamaEmbedder); err != nil {
+func initVectorStore(ctx context.Context, c *config.Config) error {
+	opts, err := syncOptions()
+	if err != nil {
 		return err
 	}
-	return nil
+	opts = append(opts, weaviate.WithConnection(c.Weaviate))
+	return weaviate.InitWeaviate(ctx, ollamaEmbedder, opts...)
+}
+
+// runSyncSchedule syncs the Plex libraries every interval
+// until ctx is done. Only the sections Plex has updated since
+// they were last synced are synced.
+func runSyncSchedule(ctx context.Context, interval time.Duration) {
+	log.Println("syncing libraries every ", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := syncLibraries(ctx, false); err != nil {
+				log.Println("could not sync libraries: ", err.Error())
+			}
+		}
+	}
 }
 
 // initCacheStore connects to a database used for
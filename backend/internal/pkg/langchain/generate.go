@@ -12,14 +12,37 @@ import (
 	"github.com/tmc/langchaingo/llms/ollama"
 )
 
-func GenerateRecommendation(ctx context.Context, recentlyViewed, fullCollection string, llm *ollama.LLM) (string, error) {
+type generateOption struct {
+	weightedHistory string
+}
+
+type GenerateOption func(*generateOption)
+
+// WithWeightedHistory tells the LLM how much each title in the
+// watch history should count towards the recommendation.
+func WithWeightedHistory(s string) GenerateOption {
+	return func(g *generateOption) {
+		g.weightedHistory = s
+	}
+}
+
+// weightedHistoryGrounding is added to the prompt when the
+// watch history has been weighted.
+const weightedHistoryGrounding = `
+	Not everything I watched should count equally. Here is my watch history with a weight for each
+	title, and why it has that weight: %+v. Titles I rewatched or rated highly have a higher weight,
+	and titles I didn't finish have a lower one. Favour titles like those with the highest weights.
+	`
+
+func GenerateRecommendation(ctx context.Context, recentlyViewed, fullCollection string, llm *ollama.LLM, opts ...GenerateOption) (string, error) {
 	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GenerateRecommendation"))
 	defer span.End()
 	span.SetAttributes(attribute.String("package", "langchain"))
 	log.Println("generating recommendation...")
-	grounding := `Please recommend me up to 3 different movies to watch based on my recent watch
+	grounding := `Please recommend me up to 3 different movies or TV shows to watch based on my recent watch
 	history provided here: %+v. Please do not suggest any titles that do not exist in the following 
 	collection, and use this data to pull title, summary, and content rating information: %+v. 
+	Do not recommend me any titles from my recent watch history.
 	Do not recommend me any titles that have a content rating exceeding the highest
 	content rating in my recent watch history. Please provide your recommendation as a json array of
 	objects, whose members have this shape:
@@ -28,7 +51,10 @@ func GenerateRecommendation(ctx context.Context, recentlyViewed, fullCollection
 		"summary": summary,
 		"content_rating": content_rating,
 		"plex_id": plex_id,
+		"type": type,
 	}
+	The type is "movie" for movies and "show" for TV shows. Recommend a TV show as a whole rather
+	than an individual episode or season.
 	Please do not recommend more than 3 titles. Please do ensure your response is valid json before
 	returning it to me. If a content rating is not found, generate a rating of "NR" for not rated.
 
@@ -42,6 +68,7 @@ func GenerateRecommendation(ctx context.Context, recentlyViewed, fullCollection
 				"summary": summary,
 				"content_rating": content_rating,
 				"plex_id": plex_id,
+				"type": type,
 			}
 		], 
 		"justification": "I recommend watching these videos based on your recent watch history because..."
@@ -49,7 +76,16 @@ func GenerateRecommendation(ctx context.Context, recentlyViewed, fullCollection
 	with the "justification" being the actual reason why you recommended those videos based on my recent watch history.
 	`
 
-	recommendation, err := llms.GenerateFromSinglePrompt(ctx, llm, fmt.Sprintf(grounding, recentlyViewed, fullCollection))
+	options := &generateOption{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	prompt := fmt.Sprintf(grounding, recentlyViewed, fullCollection)
+	if options.weightedHistory != "" {
+		prompt += fmt.Sprintf(weightedHistoryGrounding, options.weightedHistory)
+	}
+
+	recommendation, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt)
 	if err != nil {
 		span.RecordError(err)
 		return "", err
@@ -60,3 +96,65 @@ func GenerateRecommendation(ctx context.Context, recentlyViewed, fullCollection
 	return recommendation, nil
 
 }
+
+// GenerateMusicRecommendation asks the LLM for albums and artists to
+// listen to, based on the albums recently listened to and the albums
+// in the library most similar to them.
+func GenerateMusicRecommendation(ctx context.Context, recentlyPlayed, similar, albums, artists string, llm *ollama.LLM) (string, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GenerateMusicRecommendation"), telemetry.WithSpanPackage("langchain"))
+	defer span.End()
+	log.Println("generating music recommendation...")
+	grounding := `Please recommend me up to 3 albums and up to 3 artists to listen to based on the albums I have
+	been listening to recently, provided here: %+v. These are the albums in my library that sound the most
+	like what I have been listening to: %+v. Pay attention to the genres, styles and moods of the albums
+	I have been listening to, and recommend music that fits the same mood.
+	Please do not suggest any albums that do not exist in the following collection, and use this data to
+	pull title, artist and plex_id information: %+v.
+	Please do not suggest any artists that do not exist in the following list of artists: %+v.
+	Do not recommend me any albums I have been listening to recently, and prefer artists I have not been
+	listening to recently. Please provide your recommendation as json, whose albums have this shape:
+	{
+		"title": title,
+		"artist": artist,
+		"plex_id": plex_id,
+	}
+	and whose artists have this shape:
+	{
+		"name": name,
+		"plex_id": plex_id,
+	}
+	Please do not recommend more than 3 albums or more than 3 artists. Please do ensure your response is
+	valid json before returning it to me.
+
+	Finally, return a justification for why you recommended this music. This justification should be outside of the
+	arrays of albums and artists, and be on the "justification" member of the response json. Your final output
+	should take this shape:
+	{
+		"albums": [
+			{
+				"title": title,
+				"artist": artist,
+				"plex_id": plex_id,
+			}
+		],
+		"artists": [
+			{
+				"name": name,
+				"plex_id": plex_id,
+			}
+		],
+		"justification": "I recommend listening to this music based on what you have been listening to because..."
+	}
+	with the "justification" being the actual reason why you recommended that music based on what I have been listening to.
+	`
+
+	recommendation, err := llms.GenerateFromSinglePrompt(ctx, llm, fmt.Sprintf(grounding, recentlyPlayed, similar, albums, artists))
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+	span.SetStatus(codes.Ok, "Generated music recommendation")
+
+	log.Println("generated")
+	return recommendation, nil
+}
@@ -34,7 +34,7 @@ func InitPostgres(ctx context.Context, c *config.Config) error {
 	}
 	span.AddEvent("Connected to Postgres")
 	log.Println("automigrating db")
-	if err := client.AutoMigrate(&RecommendationCache{}); err != nil {
+	if err := client.AutoMigrate(&RecommendationCache{}, &SectionSync{}); err != nil {
 		span.RecordError(err)
 		return err
 	}
@@ -43,16 +43,75 @@ func InitPostgres(ctx context.Context, c *config.Config) error {
 	return nil
 }
 
-func InsertData(ctx context.Context, input []string, response string) error {
+type insertOption struct {
+	accountID string
+	rewatch   bool
+	server    string
+	span      bool
+	settings  string
+}
+
+type InsertOption func(*insertOption)
+
+// WithAccount records the Plex account the
+// recommendation was generated for.
+func WithAccount(a string) InsertOption {
+	return func(i *insertOption) {
+		i.accountID = a
+	}
+}
+
+// WithRewatch records whether already watched titles
+// were allowed in the recommendation.
+func WithRewatch(r bool) InsertOption {
+	return func(i *insertOption) {
+		i.rewatch = r
+	}
+}
+
+// WithServer records the Plex server the
+// recommendation was generated for.
+func WithServer(s string) InsertOption {
+	return func(i *insertOption) {
+		i.server = s
+	}
+}
+
+// WithSpan records whether the recommendation could
+// include media from every Plex server.
+func WithSpan(s bool) InsertOption {
+	return func(i *insertOption) {
+		i.span = s
+	}
+}
+
+// WithSettings records anything else the recommendation
+// depended on. Only a hash of it is stored.
+func WithSettings(s string) InsertOption {
+	return func(i *insertOption) {
+		i.settings = toHash(s)
+	}
+}
+
+func InsertData(ctx context.Context, input []string, response string, opts ...InsertOption) error {
 	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("InsertData"))
 	defer span.End()
 	span.SetAttributes(attribute.String("package", "pg"))
+	options := &insertOption{}
+	for _, opt := range opts {
+		opt(options)
+	}
 	// sort the incoming titles slice so recently viewed is
 	// indifferent to order of recent viewing.
 	slices.Sort(input)
 	cache := &RecommendationCache{
 		InputTitles:     toBase64(buildStringFromSlice(input)),
 		GeneratedOutput: response,
+		AccountID:       options.accountID,
+		Rewatch:         options.rewatch,
+		Server:          options.server,
+		Span:            options.span,
+		Settings:        options.settings,
 	}
 	if err := client.Create(cache).Error; err != nil {
 		span.RecordError(err)
@@ -64,8 +123,13 @@ func InsertData(ctx context.Context, input []string, response string) error {
 }
 
 type queryOption struct {
-	input    string
-	response string
+	input     string
+	response  string
+	accountID string
+	rewatch   bool
+	server    string
+	span      bool
+	settings  string
 }
 
 type QueryOption func(*queryOption)
@@ -83,6 +147,51 @@ func WithResponse(r string) QueryOption {
 	}
 }
 
+// WithAccountID limits the query to recommendations generated
+// for the provided Plex account. Without it, only recommendations
+// based on the whole server are returned.
+func WithAccountID(a string) QueryOption {
+	return func(q *queryOption) {
+		q.accountID = a
+	}
+}
+
+// WithRewatchAllowed limits the query to recommendations that
+// were allowed to include already watched titles. Without it,
+// only recommendations of unwatched titles are returned.
+func WithRewatchAllowed(r bool) QueryOption {
+	return func(q *queryOption) {
+		q.rewatch = r
+	}
+}
+
+// WithServerName limits the query to recommendations
+// generated for the provided Plex server.
+func WithServerName(s string) QueryOption {
+	return func(q *queryOption) {
+		q.server = s
+	}
+}
+
+// WithSpanning limits the query to recommendations that could
+// include media from every Plex server. Without it, only
+// recommendations from a single server are returned.
+func WithSpanning(s bool) QueryOption {
+	return func(q *queryOption) {
+		q.span = s
+	}
+}
+
+// WithSameSettings limits the query to recommendations that
+// depended on the same settings they were inserted WithSettings.
+// Without it, only recommendations inserted without any are
+// returned.
+func WithSameSettings(s string) QueryOption {
+	return func(q *queryOption) {
+		q.settings = toHash(s)
+	}
+}
+
 func QueryData(ctx context.Context, opts ...QueryOption) (*RecommendationCache, error) {
 	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("QueryData"))
 	defer span.End()
@@ -102,7 +211,16 @@ func QueryData(ctx context.Context, opts ...QueryOption) (*RecommendationCache,
 		q.GeneratedOutput = query.response
 	}
 	var response = RecommendationCache{}
-	result := client.Where(&q).First(&response)
+	// the struct condition skips zero values, so the account,
+	// rewatch, server, span and settings are matched explicitly
+	// to keep the caches apart.
+	result := client.Where(&q).
+		Where("account_id = ?", query.accountID).
+		Where("rewatch = ?", query.rewatch).
+		Where("server = ?", query.server).
+		Where("span = ?", query.span).
+		Where("settings = ?", query.settings).
+		First(&response)
 	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
 		span.RecordError(result.Error)
 		return nil, result.Error
@@ -110,3 +228,62 @@ func QueryData(ctx context.Context, opts ...QueryOption) (*RecommendationCache,
 	span.SetStatus(codes.Ok, "query succeeded")
 	return &response, nil
 }
+
+// DeleteByID removes the cached recommendation with the
+// provided ID, leaving every other one alone.
+func DeleteByID(ctx context.Context, id uint) error {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("DeleteByID"))
+	defer span.End()
+	span.SetAttributes(attribute.String("package", "pg"), attribute.Int("id", int(id)))
+	if err := client.Delete(&RecommendationCache{}, id).Error; err != nil {
+		span.RecordError(err)
+		return err
+	}
+	span.SetStatus(codes.Ok, "delete complete")
+	return nil
+}
+
+// DeleteData removes the cached recommendations for the provided
+// Plex accounts on the named server. Pass an empty account ID to
+// remove recommendations based on the whole server.
+func DeleteData(ctx context.Context, server string, accountIDs ...string) error {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("DeleteData"))
+	defer span.End()
+	span.SetAttributes(attribute.String("package", "pg"))
+	result := client.Where("server = ? AND account_id IN ?", server, accountIDs).Delete(&RecommendationCache{})
+	if result.Error != nil {
+		span.RecordError(result.Error)
+		return result.Error
+	}
+	span.SetAttributes(attribute.Int64("deleted", result.RowsAffected))
+	span.SetStatus(codes.Ok, "delete complete")
+	return nil
+}
+
+// SaveSectionSync records that a library section was synced,
+// replacing what was recorded for it before.
+func SaveSectionSync(ctx context.Context, s SectionSync) error {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("SaveSectionSync"), telemetry.WithSpanPackage("pg"))
+	defer span.End()
+	span.SetAttributes(attribute.String("server", s.Server), attribute.String("section", s.Section))
+	if err := client.Save(&s).Error; err != nil {
+		span.RecordError(err)
+		return err
+	}
+	span.SetStatus(codes.Ok, "section sync saved")
+	return nil
+}
+
+// GetSectionSyncs returns every library section synced before.
+func GetSectionSyncs(ctx context.Context) ([]SectionSync, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetSectionSyncs"), telemetry.WithSpanPackage("pg"))
+	defer span.End()
+	var syncs []SectionSync
+	if err := client.Find(&syncs).Error; err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("count", len(syncs)))
+	span.SetStatus(codes.Ok, "section syncs retrieved")
+	return syncs, nil
+}
@@ -38,6 +38,26 @@ func TestQueryOptions(t *testing.T) {
 			options:  []QueryOption{WithInputTitles(anotherTitle), WithResponse("result")},
 			expected: queryOption{response: "result", input: base64.StdEncoding.EncodeToString([]byte(anotherString))},
 		},
+		{
+			name:     "With Account ID",
+			options:  []QueryOption{WithAccountID("2")},
+			expected: queryOption{accountID: "2"},
+		},
+		{
+			name:     "With Rewatch Allowed",
+			options:  []QueryOption{WithRewatchAllowed(true)},
+			expected: queryOption{rewatch: true},
+		},
+		{
+			name:     "With Server Spanning",
+			options:  []QueryOption{WithServerName("cabin"), WithSpanning(true)},
+			expected: queryOption{server: "cabin", span: true},
+		},
+		{
+			name:     "With Same Settings",
+			options:  []QueryOption{WithSameSettings("limit=10")},
+			expected: queryOption{settings: toHash("limit=10")},
+		},
 	}
 
 	for _, tc := range tests {
@@ -47,7 +67,7 @@ func TestQueryOptions(t *testing.T) {
 				opt(&qo)
 			}
 
-			if qo.input != tc.expected.input || qo.response != tc.expected.response {
+			if qo != tc.expected {
 				t.Errorf("Expected: %v, Got: %v", tc.expected, qo)
 			}
 		})
@@ -1,7 +1,9 @@
 package pg
 
 import (
+	"crypto/sha256"
 	b64 "encoding/base64"
+	"encoding/hex"
 	"fmt"
 )
 
@@ -12,3 +14,11 @@ func buildStringFromSlice[T any](slice []T) string {
 func toBase64(i string) string {
 	return b64.StdEncoding.EncodeToString([]byte(i))
 }
+
+func toHash(i string) string {
+	if i == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(i))
+	return hex.EncodeToString(sum[:])
+}
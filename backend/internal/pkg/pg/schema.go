@@ -1,6 +1,8 @@
 package pg
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -14,4 +16,33 @@ type RecommendationCache struct {
 	// when ased for a recommendation using the
 	// provided InputTitles
 	GeneratedOutput string
+	// AccountID is the Plex account whose watch history
+	// the recommendation was generated for. It is empty
+	// for recommendations based on the whole server.
+	AccountID string `gorm:"not null;default:'';index"`
+	// Rewatch is whether already watched titles were
+	// allowed in the recommendation.
+	Rewatch bool `gorm:"not null;default:false"`
+	// Server is the name of the Plex server whose watch
+	// history the recommendation was generated from.
+	Server string `gorm:"not null;default:'';index"`
+	// Span is whether the recommendation could include
+	// media from every configured Plex server.
+	Span bool `gorm:"not null;default:false"`
+	// Settings is a hash of anything else the recommendation
+	// depended on, such as how the watch history was weighted.
+	Settings string `gorm:"not null;default:''"`
+}
+
+// SectionSync records when a Plex library section was last
+// synced into the vector store, so a restart only syncs the
+// sections Plex has updated since.
+type SectionSync struct {
+	Server  string `gorm:"primaryKey"`
+	Section string `gorm:"primaryKey"`
+	Title   string
+	// PlexUpdatedAt is when Plex had last updated the
+	// section as of the sync.
+	PlexUpdatedAt int64
+	SyncedAt      time.Time
 }
@@ -3,26 +3,49 @@ package plex
 import (
 	"context"
 	"encoding/xml"
+	"fmt"
 	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"io"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 const allMovies = true
 
+// Plex metadata types we know how to turn into recommendable media.
+const (
+	movieType   = "movie"
+	showType    = "show"
+	episodeType = "episode"
+)
+
+// Watch states reported on a show's VideoShort.ShowStatus. Plex doesn't
+// track whether a series is still airing, so the status reflects how much
+// of the show has been watched on this server.
+const (
+	showStatusUnwatched  = "unwatched"
+	showStatusInProgress = "in progress"
+	showStatusWatched    = "watched"
+)
+
 type MediaContainer struct {
 	XMLName             xml.Name `xml:"MediaContainer"`
 	Size                int      `xml:"size,attr"`
+	TotalSize           int      `xml:"totalSize,attr"`
 	AllowSync           int      `xml:"allowSync,attr"`
 	Art                 string   `xml:"art,attr"`
 	Identifier          string   `xml:"identifier,attr"`
 	LibrarySectionID    int      `xml:"librarySectionID,attr"`
 	LibrarySectionTitle string   `xml:"librarySectionTitle,attr"`
 	// ... (other MediaContainer attributes)
-	Videos []Video `xml:"Video"`
+	Videos      []Video     `xml:"Video"`
+	Directories []Directory `xml:"Directory"`
+	Tracks      []Track     `xml:"Track"`
 }
 
 type Video struct {
@@ -36,40 +59,481 @@ type Video struct {
 	Title         string   `xml:"title,attr"`
 	ContentRating string   `xml:"contentRating,attr"`
 	Summary       string   `xml:"summary,attr"`
+	Year          int      `xml:"year,attr"`
+	// Thumb and Art are the paths of the poster and
+	// background images on the Plex server
+	Thumb string `xml:"thumb,attr"`
+	Art   string `xml:"art,attr"`
+	// Duration is the runtime in milliseconds
+	Duration              int     `xml:"duration,attr"`
+	Rating                float64 `xml:"rating,attr"`
+	AudienceRating        float64 `xml:"audienceRating,attr"`
+	OriginallyAvailableAt string  `xml:"originallyAvailableAt,attr"`
+	Genres                []Tag   `xml:"Genre"`
+	Directors             []Tag   `xml:"Director"`
+	Writers               []Tag   `xml:"Writer"`
+	Roles                 []Tag   `xml:"Role"`
+	Countries             []Tag   `xml:"Country"`
+	// ViewCount, LastViewedAt, ViewOffset and UserRating are
+	// the play history of the account whose token is used to
+	// connect to Plex. ViewOffset is how far into the video a
+	// play that hasn't finished got, in milliseconds, and
+	// UserRating is out of 10.
+	ViewCount    int     `xml:"viewCount,attr"`
+	LastViewedAt int64   `xml:"lastViewedAt,attr"`
+	ViewOffset   int     `xml:"viewOffset,attr"`
+	UserRating   float64 `xml:"userRating,attr"`
+	// ViewedAt is when a play in the watch history
+	// happened, whichever account played it.
+	ViewedAt int64 `xml:"viewedAt,attr"`
+	// Episode only attributes. The parent is the season and
+	// the grandparent is the show the episode belongs to.
+	ParentRatingKey      int    `xml:"parentRatingKey,attr"`
+	GrandparentRatingKey int    `xml:"grandparentRatingKey,attr"`
+	GrandparentKey       string `xml:"grandparentKey,attr"`
+	GrandparentGuid      string `xml:"grandparentGuid,attr"`
+	GrandparentTitle     string `xml:"grandparentTitle,attr"`
+	GrandparentThumb     string `xml:"grandparentThumb,attr"`
+	GrandparentArt       string `xml:"grandparentArt,attr"`
+	ParentIndex          int    `xml:"parentIndex,attr"`
+	Index                int    `xml:"index,attr"`
+}
+
+// Directory is a Plex container element. In a TV library
+// these are shows and seasons, and in a music library they
+// are artists and albums. The parent of an album is its artist.
+type Directory struct {
+	XMLName         xml.Name `xml:"Directory"`
+	RatingKey       int      `xml:"ratingKey,attr"`
+	Key             string   `xml:"key,attr"`
+	ParentRatingKey int      `xml:"parentRatingKey,attr"`
+	ParentKey       string   `xml:"parentKey,attr"`
+	ParentGuid      string   `xml:"parentGuid,attr"`
+	ParentTitle     string   `xml:"parentTitle,attr"`
+	Guid            string   `xml:"guid,attr"`
+	Studio          string   `xml:"studio,attr"`
+	Type            string   `xml:"type,attr"`
+	Title           string   `xml:"title,attr"`
+	ContentRating   string   `xml:"contentRating,attr"`
+	Summary         string   `xml:"summary,attr"`
+	Index           int      `xml:"index,attr"`
+	Year            int      `xml:"year,attr"`
+	Thumb           string   `xml:"thumb,attr"`
+	Art             string   `xml:"art,attr"`
+	// Duration is the typical episode runtime in milliseconds
+	Duration              int     `xml:"duration,attr"`
+	Rating                float64 `xml:"rating,attr"`
+	AudienceRating        float64 `xml:"audienceRating,attr"`
+	OriginallyAvailableAt string  `xml:"originallyAvailableAt,attr"`
+	Genres                []Tag   `xml:"Genre"`
+	Roles                 []Tag   `xml:"Role"`
+	Countries             []Tag   `xml:"Country"`
+	Styles                []Tag   `xml:"Style"`
+	Moods                 []Tag   `xml:"Mood"`
+	// ChildCount is the number of seasons for a show
+	// or albums for an artist
+	ChildCount int `xml:"childCount,attr"`
+	// LeafCount is the number of episodes for a show or
+	// season, or tracks for an artist or album
+	LeafCount       int     `xml:"leafCount,attr"`
+	ViewedLeafCount int     `xml:"viewedLeafCount,attr"`
+	ViewCount       int     `xml:"viewCount,attr"`
+	LastViewedAt    int64   `xml:"lastViewedAt,attr"`
+	UserRating      float64 `xml:"userRating,attr"`
+}
+
+// Tag is a Plex tag element such as a
+// genre, director or cast member.
+type Tag struct {
+	Tag string `xml:"tag,attr"`
+}
+
+// tagNames flattens Plex tags to their names.
+func tagNames(tags []Tag) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, tag.Tag)
+	}
+	return names
 }
 
 type VideoShort struct {
-	Title         string `json:"title"`
-	Summary       string `json:"summary"`
-	ContentRating string `json:"content_rating"`
-	PlexID        string `json:"plex_id"`
+	Title                 string   `json:"title"`
+	Summary               string   `json:"summary"`
+	ContentRating         string   `json:"content_rating"`
+	PlexID                string   `json:"plex_id"`
+	RatingKey             int      `json:"rating_key,omitempty"`
+	Key                   string   `json:"key,omitempty"`
+	Type                  string   `json:"type,omitempty"`
+	SeasonCount           int      `json:"season_count,omitempty"`
+	EpisodeCount          int      `json:"episode_count,omitempty"`
+	ShowStatus            string   `json:"show_status,omitempty"`
+	SectionID             string   `json:"section_id,omitempty"`
+	Year                  int      `json:"year,omitempty"`
+	Duration              int      `json:"duration,omitempty"`
+	Rating                float64  `json:"rating,omitempty"`
+	AudienceRating        float64  `json:"audience_rating,omitempty"`
+	OriginallyAvailableAt string   `json:"originally_available_at,omitempty"`
+	Genres                []string `json:"genres,omitempty"`
+	Directors             []string `json:"directors,omitempty"`
+	Writers               []string `json:"writers,omitempty"`
+	Cast                  []string `json:"cast,omitempty"`
+	Countries             []string `json:"countries,omitempty"`
+	ViewCount             int      `json:"view_count,omitempty"`
+	// LastViewedAt is a Unix timestamp
+	LastViewedAt int64 `json:"last_viewed_at,omitempty"`
+	// ViewOffset is how far into the video an unfinished
+	// play got, in milliseconds.
+	ViewOffset int `json:"view_offset,omitempty"`
+	// UserRating is the viewer's own rating out of 10.
+	UserRating float64 `json:"user_rating,omitempty"`
+	// Thumb and Art are paths on the Plex server. They're
+	// served without the token by GET /images/{ratingKey}.
+	Thumb string `json:"thumb,omitempty"`
+	Art   string `json:"art,omitempty"`
+	// WebURL and AppURL open the video in Plex Web and
+	// in the Plex apps.
+	WebURL string `json:"web_url,omitempty"`
+	AppURL string `json:"app_url,omitempty"`
+	// Server is the name of the configured Plex
+	// server the video is on.
+	Server string `json:"server,omitempty"`
+	// Distance is how far the video was from the watch history
+	// when it was found by a vector search, from 0 to 2, and
+	// Score is how similar it was, 1 - Distance clamped to 0.
+	// They're nil otherwise.
+	Distance *float64 `json:"distance,omitempty"`
+	Score    *float64 `json:"score,omitempty"`
+}
+
+// Watched reports whether the movie has been played, or the
+// show started, by the account connected to Plex.
+func (v VideoShort) Watched() bool {
+	if v.Type == showType && (v.ShowStatus == showStatusInProgress || v.ShowStatus == showStatusWatched) {
+		return true
+	}
+	return v.ViewCount > 0
+}
+
+// Progress is how far through the video its last play got, from
+// 0 to 1. Videos without an unfinished play are at 1, since Plex
+// clears the offset once a video is watched to the end.
+func (v VideoShort) Progress() float64 {
+	if v.ViewOffset <= 0 || v.Duration <= 0 {
+		return 1
+	}
+	return min(float64(v.ViewOffset)/float64(v.Duration), 1)
+}
+
+// TimesWatched is how many times the video has been watched all
+// the way through. Plex counts every episode played towards a
+// show's views, so a show has been watched once for every time
+// each of its episodes has been.
+func (v VideoShort) TimesWatched() int {
+	if v.Type == showType && v.EpisodeCount > 0 {
+		return v.ViewCount / v.EpisodeCount
+	}
+	return v.ViewCount
 }
 
 func (v VideoShort) String() string {
-	return "Title: " + v.Title +
+	s := "Title: " + v.Title +
 		"\nSummary: " + v.Summary +
 		"\nContent Rating: " + v.ContentRating +
 		"\nPlex ID: " + v.PlexID
+	if v.Year != 0 {
+		s += "\nYear: " + strconv.Itoa(v.Year)
+	}
+	if v.OriginallyAvailableAt != "" {
+		s += "\nReleased: " + v.OriginallyAvailableAt
+	}
+	if v.Duration != 0 {
+		s += "\nRuntime: " + strconv.Itoa(v.Duration/60000) + " minutes"
+	}
+	if len(v.Genres) > 0 {
+		s += "\nGenres: " + strings.Join(v.Genres, ", ")
+	}
+	if len(v.Directors) > 0 {
+		s += "\nDirectors: " + strings.Join(v.Directors, ", ")
+	}
+	if len(v.Writers) > 0 {
+		s += "\nWriters: " + strings.Join(v.Writers, ", ")
+	}
+	if len(v.Cast) > 0 {
+		s += "\nCast: " + strings.Join(v.Cast, ", ")
+	}
+	if len(v.Countries) > 0 {
+		s += "\nCountries: " + strings.Join(v.Countries, ", ")
+	}
+	if v.Rating != 0 {
+		s += "\nCritic Rating: " + strconv.FormatFloat(v.Rating, 'f', 1, 64)
+	}
+	if v.AudienceRating != 0 {
+		s += "\nAudience Rating: " + strconv.FormatFloat(v.AudienceRating, 'f', 1, 64)
+	}
+	if v.Type == showType {
+		s += "\nType: TV show" +
+			"\nSeasons: " + strconv.Itoa(v.SeasonCount) +
+			"\nEpisodes: " + strconv.Itoa(v.EpisodeCount)
+	}
+	return s
+}
+
+// HasWatched reports whether the video has been watched, either
+// going by Plex's play count for it or because it's in history.
+// Play counts are the server owner's, so for anyone else use
+// InHistory with their own history instead.
+func HasWatched(v VideoShort, history []VideoShort) bool {
+	return v.Watched() || InHistory(v, history)
+}
+
+// InHistory reports whether the video is in history. Rating keys
+// are only unique to a server, so they're only compared for videos
+// on the same server.
+func InHistory(v VideoShort, history []VideoShort) bool {
+	return slices.ContainsFunc(history, func(h VideoShort) bool {
+		return (v.RatingKey != 0 && h.RatingKey == v.RatingKey && h.Server == v.Server) ||
+			(v.PlexID != "" && h.PlexID == v.PlexID)
+	})
+}
+
+// Unwatched returns the videos that haven't been watched.
+func Unwatched(videos, history []VideoShort) []VideoShort {
+	unwatched := make([]VideoShort, 0, len(videos))
+	for _, video := range videos {
+		if HasWatched(video, history) {
+			continue
+		}
+		unwatched = append(unwatched, video)
+	}
+	return unwatched
+}
+
+// showStatus describes how far through a show the server's
+// viewers are.
+func showStatus(leafCount, viewedLeafCount int) string {
+	switch {
+	case leafCount > 0 && viewedLeafCount >= leafCount:
+		return showStatusWatched
+	case viewedLeafCount > 0:
+		return showStatusInProgress
+	default:
+		return showStatusUnwatched
+	}
 }
 
+func (d Directory) toShort() VideoShort {
+	return VideoShort{
+		Title:                 d.Title,
+		Summary:               d.Summary,
+		ContentRating:         d.ContentRating,
+		PlexID:                d.Guid,
+		RatingKey:             d.RatingKey,
+		Key:                   d.Key,
+		Type:                  showType,
+		SeasonCount:           d.ChildCount,
+		EpisodeCount:          d.LeafCount,
+		ShowStatus:            showStatus(d.LeafCount, d.ViewedLeafCount),
+		Year:                  d.Year,
+		Duration:              d.Duration,
+		Rating:                d.Rating,
+		AudienceRating:        d.AudienceRating,
+		OriginallyAvailableAt: d.OriginallyAvailableAt,
+		Genres:                tagNames(d.Genres),
+		Cast:                  tagNames(d.Roles),
+		Countries:             tagNames(d.Countries),
+		ViewCount:             d.ViewCount,
+		LastViewedAt:          d.LastViewedAt,
+		UserRating:            d.UserRating,
+		Thumb:                 d.Thumb,
+		Art:                   d.Art,
+	}
+}
+
+// fullToShort converts up to limit Plex videos to their short form.
+// Episodes are rolled up into their parent show so a binge of one
+// series counts as a single item against the limit.
 func fullToShort(vids []Video, limit int) []VideoShort {
 	shorts := make([]VideoShort, 0, limit)
-	for i, vid := range vids {
-		if i >= limit {
+	seenShows := make(map[int]bool)
+	for _, vid := range vids {
+		if len(shorts) >= limit {
 			break
 		}
+		if vid.Type == episodeType {
+			showKey := vid.GrandparentRatingKey
+			if showKey == 0 {
+				// watch history only provides the show's key
+				showKey = ratingKeyFromKey(vid.GrandparentKey)
+			}
+			if seenShows[showKey] {
+				continue
+			}
+			seenShows[showKey] = true
+			shorts = append(shorts, VideoShort{
+				Title:         vid.GrandparentTitle,
+				ContentRating: vid.ContentRating,
+				PlexID:        vid.GrandparentGuid,
+				RatingKey:     showKey,
+				Key:           vid.GrandparentKey,
+				Type:          showType,
+				Thumb:         vid.GrandparentThumb,
+				Art:           vid.GrandparentArt,
+			})
+			continue
+		}
 		shorts = append(shorts, VideoShort{
-			Title:         vid.Title,
-			Summary:       vid.Summary,
-			ContentRating: vid.ContentRating,
-			PlexID:        vid.Guid,
+			Title:                 vid.Title,
+			Summary:               vid.Summary,
+			ContentRating:         vid.ContentRating,
+			PlexID:                vid.Guid,
+			RatingKey:             vid.RatingKey,
+			Key:                   vid.Key,
+			Type:                  vid.Type,
+			Year:                  vid.Year,
+			Duration:              vid.Duration,
+			Rating:                vid.Rating,
+			AudienceRating:        vid.AudienceRating,
+			OriginallyAvailableAt: vid.OriginallyAvailableAt,
+			Genres:                tagNames(vid.Genres),
+			Directors:             tagNames(vid.Directors),
+			Writers:               tagNames(vid.Writers),
+			Cast:                  tagNames(vid.Roles),
+			Countries:             tagNames(vid.Countries),
+			ViewCount:             vid.ViewCount,
+			LastViewedAt:          vid.LastViewedAt,
+			ViewOffset:            vid.ViewOffset,
+			UserRating:            vid.UserRating,
+			Thumb:                 vid.Thumb,
+			Art:                   vid.Art,
 		})
 	}
 
 	return shorts
 }
 
+// ratingKeyFromKey parses the rating key out of a metadata
+// key such as /library/metadata/123. Zero is returned if the
+// key isn't in that form.
+func ratingKeyFromKey(key string) int {
+	ratingKey, err := strconv.Atoi(strings.TrimPrefix(key, "/library/metadata/"))
+	if err != nil {
+		return 0
+	}
+	return ratingKey
+}
+
+// showsToShort converts the shows in a list of Plex directories
+// to their short form, skipping seasons and any other containers.
+func showsToShort(dirs []Directory) []VideoShort {
+	shorts := make([]VideoShort, 0, len(dirs))
+	for _, dir := range dirs {
+		if dir.Type != showType {
+			continue
+		}
+		shorts = append(shorts, dir.toShort())
+	}
+	return shorts
+}
+
+// getXML requests the provided Plex URI and decodes the
+// XML it responds with into v.
+func getXML(ctx context.Context, c Client, uri string, v any) error {
+	return requestXML(ctx, c, uri, http.MethodGet, v)
+}
+
+// requestXML makes a request with the provided method to the
+// Plex URI and decodes the XML it responds with into v. The
+// response is discarded when v is nil.
+func requestXML(ctx context.Context, c Client, uri, method string, v any) error {
+	resp, err := c.MakeNetworkRequest(ctx, uri, method)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if v == nil {
+		_, err := io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// GetMetadata retrieves the movie or show with the provided
+// rating key.
+func GetMetadata(ctx context.Context, c Client, ratingKey int) (*VideoShort, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetMetadata"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.Int("ratingKey", ratingKey))
+	var container MediaContainer
+	if err := getXML(ctx, c, c.Connect(WithPath("/library/metadata/"+strconv.Itoa(ratingKey))), &container); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	shorts := fullToShort(container.Videos, len(container.Videos))
+	shorts = append(shorts, showsToShort(container.Directories)...)
+	if len(shorts) == 0 {
+		err := fmt.Errorf("no metadata found for rating key %d", ratingKey)
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetStatus(codes.Ok, "metadata retrieved")
+	return &shorts[0], nil
+}
+
+// GetLibrarySectionID returns the key of the library
+// section the media with the provided rating key is in.
+func GetLibrarySectionID(ctx context.Context, c Client, ratingKey int) (string, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetLibrarySectionID"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.Int("ratingKey", ratingKey))
+	var container MediaContainer
+	if err := getXML(ctx, c, c.Connect(WithPath("/library/metadata/"+strconv.Itoa(ratingKey))), &container); err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+	if container.LibrarySectionID == 0 {
+		err := fmt.Errorf("no library section found for rating key %d", ratingKey)
+		span.RecordError(err)
+		return "", err
+	}
+	span.SetStatus(codes.Ok, "library section retrieved")
+	return strconv.Itoa(container.LibrarySectionID), nil
+}
+
+// setSectionID records the library section the
+// videos were retrieved from.
+func setSectionID(shorts []VideoShort, sectionId string) {
+	for i := range shorts {
+		shorts[i].SectionID = sectionId
+	}
+}
+
+// hydrateMetadata replaces videos we only know part of, such as
+// shows rolled up from episode plays or watch history entries,
+// with their full metadata from Plex. Videos that can't be
+// retrieved keep what we already know about them.
+func hydrateMetadata(ctx context.Context, c Client, shorts []VideoShort) {
+	for i, short := range shorts {
+		if short.Summary != "" || short.RatingKey == 0 {
+			continue
+		}
+		full, err := GetMetadata(ctx, c, short.RatingKey)
+		if err != nil {
+			log.Printf("could not get metadata for %q: %v\n", short.Title, err)
+			continue
+		}
+		shorts[i] = *full
+	}
+}
+
 func GetRecentlyPlayed(ctx context.Context, c Client, sectionId string, limit int) ([]VideoShort, error) {
+	if sectionId == "" {
+		sectionId = c.GetDefaultLibrarySection()
+	}
 	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetRecentlyPlayed"))
 	defer span.End()
 	log.Println("connecting to Plex...")
@@ -101,45 +565,32 @@ func GetRecentlyPlayed(ctx context.Context, c Client, sectionId string, limit in
 	log.Printf("total count: %v\n", len(container.Videos))
 	span.SetAttributes(attribute.Int("total count", len(container.Videos)))
 	shorts := fullToShort(container.Videos, limit)
+	hydrateMetadata(ctx, c, shorts)
+	setSectionID(shorts, sectionId)
 	log.Printf("returning %v recently watched\n", limit)
 	span.SetStatus(codes.Ok, "recently watched complete")
 	return shorts, nil
 }
 
-
+// GetAllVideos retrieves every movie and show in the section. Use a
+// VideoPager instead to work through a large section a page at a time.
 func GetAllVideos(ctx context.Context, c Client, sectionId string) ([]VideoShort, error) {
 	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetAllVideos"))
 	defer span.End()
 
 	span.SetAttributes(attribute.String("package", "plex"))
-	log.Println("connecting to Plex...")
-	connectionUri := c.Connect(WithSectionID(sectionId), WithAllMovies(allMovies))
-	log.Println("connected")
-	span.AddEvent("connected to plex")
-
 	log.Println("getting all videos...")
-	resp, err := c.MakeNetworkRequest(ctx, connectionUri, http.MethodGet)
-	if err != nil {
-		span.RecordError(err)
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		span.RecordError(err)
-		return nil, err
+	pager := NewVideoPager(c, sectionId, DefaultPageSize)
+	shorts := make([]VideoShort, 0)
+	for pager.Next(ctx) {
+		shorts = append(shorts, pager.Page()...)
 	}
-	log.Println("received")
-
-	var container MediaContainer
-	if err := xml.Unmarshal(bodyBytes, &container); err != nil {
+	if err := pager.Err(); err != nil {
 		span.RecordError(err)
 		return nil, err
 	}
-	log.Printf("total count: %v\n", len(container.Videos))
-	shorts := fullToShort(container.Videos, len(container.Videos))
-	log.Println("returning all movies")
+	log.Printf("total count: %v\n", len(shorts))
+	log.Println("returning all videos")
 	span.SetStatus(codes.Ok, "all movies complete")
 	return shorts, nil
 }
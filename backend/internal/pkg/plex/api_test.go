@@ -1,6 +1,9 @@
 package plex
 
 import (
+	"encoding/xml"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -42,6 +45,19 @@ func TestFullToShort(t *testing.T) {
 				{Title: "Movie A", Summary: "Action-packed", ContentRating: "R"},
 			},
 		},
+		{
+			name: "Episodes Roll Up To Show",
+			videos: []Video{
+				{Type: episodeType, Title: "Pilot", GrandparentTitle: "The Office", GrandparentRatingKey: 10, GrandparentGuid: "plex://show/office", ContentRating: "TV-14"},
+				{Type: episodeType, Title: "Diversity Day", GrandparentTitle: "The Office", GrandparentRatingKey: 10, GrandparentGuid: "plex://show/office", ContentRating: "TV-14"},
+				{Type: movieType, Title: "Movie A", Summary: "Action-packed", ContentRating: "R", RatingKey: 20},
+			},
+			limit: 2,
+			expected: []VideoShort{
+				{Title: "The Office", ContentRating: "TV-14", PlexID: "plex://show/office", RatingKey: 10, Type: showType},
+				{Title: "Movie A", Summary: "Action-packed", ContentRating: "R", RatingKey: 20, Type: movieType},
+			},
+		},
 		// Add more test cases here if you want to cover other scenarios
 	}
 
@@ -52,10 +68,155 @@ func TestFullToShort(t *testing.T) {
 				t.Fatalf("Length mismatch: expected %d, got %d", len(tc.expected), len(result))
 			}
 			for i, short := range result {
-				if short != tc.expected[i] {
+				if !reflect.DeepEqual(short, tc.expected[i]) {
 					t.Errorf("Mismatch at index %d:\nExpected: %+v\nGot:      %+v", i, tc.expected[i], short)
 				}
 			}
 		})
 	}
 }
+
+func TestShowsToShort(t *testing.T) {
+	dirs := []Directory{
+		{Type: showType, Title: "Unwatched", RatingKey: 1, ChildCount: 2, LeafCount: 20},
+		{Type: "season", Title: "Season 1", RatingKey: 2, LeafCount: 10},
+		{Type: showType, Title: "Started", RatingKey: 3, ChildCount: 1, LeafCount: 8, ViewedLeafCount: 3},
+		{Type: showType, Title: "Finished", RatingKey: 4, ChildCount: 1, LeafCount: 8, ViewedLeafCount: 8},
+	}
+	expected := []VideoShort{
+		{Title: "Unwatched", RatingKey: 1, Type: showType, SeasonCount: 2, EpisodeCount: 20, ShowStatus: showStatusUnwatched},
+		{Title: "Started", RatingKey: 3, Type: showType, SeasonCount: 1, EpisodeCount: 8, ShowStatus: showStatusInProgress},
+		{Title: "Finished", RatingKey: 4, Type: showType, SeasonCount: 1, EpisodeCount: 8, ShowStatus: showStatusWatched},
+	}
+
+	result := showsToShort(dirs)
+	if len(result) != len(expected) {
+		t.Fatalf("Length mismatch: expected %d, got %d", len(expected), len(result))
+	}
+	for i, short := range result {
+		if !reflect.DeepEqual(short, expected[i]) {
+			t.Errorf("Mismatch at index %d:\nExpected: %+v\nGot:      %+v", i, expected[i], short)
+		}
+	}
+}
+
+func TestMediaContainerParsesShows(t *testing.T) {
+	body := `<MediaContainer size="2" librarySectionID="2" librarySectionTitle="TV Shows">
+	<Directory ratingKey="10" key="/library/metadata/10/children" guid="plex://show/office" type="show" title="The Office" contentRating="TV-14" summary="A mockumentary." childCount="9" leafCount="201" viewedLeafCount="12"/>
+	<Video ratingKey="11" type="episode" title="Pilot" grandparentRatingKey="10" grandparentTitle="The Office" parentIndex="1" index="1"/>
+</MediaContainer>`
+
+	var container MediaContainer
+	if err := xml.Unmarshal([]byte(body), &container); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(container.Directories) != 1 || container.Directories[0].LeafCount != 201 {
+		t.Errorf("expected one show with 201 episodes, got %+v", container.Directories)
+	}
+	if len(container.Videos) != 1 || container.Videos[0].GrandparentRatingKey != 10 {
+		t.Errorf("expected one episode of show 10, got %+v", container.Videos)
+	}
+}
+
+func TestMediaContainerParsesRichMetadata(t *testing.T) {
+	body := `<MediaContainer size="1" librarySectionID="1" librarySectionTitle="Movies">
+	<Video ratingKey="20" guid="plex://movie/matrix" type="movie" title="The Matrix" contentRating="R" summary="A hacker learns the truth." year="1999" duration="8160000" rating="8.7" audienceRating="8.5" originallyAvailableAt="1999-03-31" viewCount="2" lastViewedAt="1716000000" viewOffset="2040000" userRating="9.0">
+		<Genre tag="Action"/>
+		<Genre tag="Science Fiction"/>
+		<Director tag="Lana Wachowski"/>
+		<Director tag="Lilly Wachowski"/>
+		<Writer tag="Lana Wachowski"/>
+		<Country tag="United States of America"/>
+		<Role tag="Keanu Reeves"/>
+		<Role tag="Carrie-Anne Moss"/>
+	</Video>
+</MediaContainer>`
+
+	var container MediaContainer
+	if err := xml.Unmarshal([]byte(body), &container); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := VideoShort{
+		Title:                 "The Matrix",
+		Summary:               "A hacker learns the truth.",
+		ContentRating:         "R",
+		PlexID:                "plex://movie/matrix",
+		RatingKey:             20,
+		Type:                  movieType,
+		Year:                  1999,
+		Duration:              8160000,
+		Rating:                8.7,
+		AudienceRating:        8.5,
+		OriginallyAvailableAt: "1999-03-31",
+		Genres:                []string{"Action", "Science Fiction"},
+		Directors:             []string{"Lana Wachowski", "Lilly Wachowski"},
+		Writers:               []string{"Lana Wachowski"},
+		Cast:                  []string{"Keanu Reeves", "Carrie-Anne Moss"},
+		Countries:             []string{"United States of America"},
+		ViewCount:             2,
+		LastViewedAt:          1716000000,
+		ViewOffset:            2040000,
+		UserRating:            9,
+	}
+	shorts := fullToShort(container.Videos, 1)
+	if len(shorts) != 1 || !reflect.DeepEqual(shorts[0], expected) {
+		t.Fatalf("expected %+v, got %+v", expected, shorts)
+	}
+
+	if progress := shorts[0].Progress(); progress != 0.25 {
+		t.Errorf("expected a quarter of the way through, got %v", progress)
+	}
+	if times := shorts[0].TimesWatched(); times != 2 {
+		t.Errorf("expected to have been watched twice, got %d", times)
+	}
+
+	text := shorts[0].String()
+	for _, want := range []string{"Year: 1999", "Runtime: 136 minutes", "Genres: Action, Science Fiction", "Cast: Keanu Reeves, Carrie-Anne Moss", "Audience Rating: 8.5"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected embedding text to contain %q, got %q", want, text)
+		}
+	}
+}
+
+func TestShowTimesWatched(t *testing.T) {
+	for _, tc := range []struct {
+		viewCount, expected int
+	}{
+		{viewCount: 0, expected: 0},
+		{viewCount: 5, expected: 0},
+		{viewCount: 8, expected: 1},
+		{viewCount: 20, expected: 2},
+	} {
+		show := VideoShort{Type: showType, EpisodeCount: 8, ViewCount: tc.viewCount}
+		if times := show.TimesWatched(); times != tc.expected {
+			t.Errorf("expected %d episode plays to be %d watches, got %d", tc.viewCount, tc.expected, times)
+		}
+	}
+}
+
+func TestUnwatched(t *testing.T) {
+	videos := []VideoShort{
+		{Title: "Played Movie", RatingKey: 1, Type: movieType, ViewCount: 1},
+		{Title: "New Movie", RatingKey: 2, Type: movieType},
+		{Title: "Started Show", RatingKey: 3, Type: showType, ShowStatus: showStatusInProgress},
+		{Title: "New Show", RatingKey: 4, Type: showType, ShowStatus: showStatusUnwatched},
+		{Title: "In History", RatingKey: 5, Type: movieType},
+		{Title: "In History By ID", PlexID: "plex://movie/six", Type: movieType},
+		{Title: "Same Key On Cabin", RatingKey: 5, Type: movieType, Server: "cabin"},
+	}
+	history := []VideoShort{
+		{Title: "In History", RatingKey: 5},
+		{Title: "In History By ID", PlexID: "plex://movie/six"},
+	}
+
+	result := Unwatched(videos, history)
+	titles := make([]string, 0, len(result))
+	for _, video := range result {
+		titles = append(titles, video.Title)
+	}
+	expected := []string{"New Movie", "New Show", "Same Key On Cabin"}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("expected %v, got %v", expected, titles)
+	}
+}
@@ -2,13 +2,22 @@ package plex
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Client interface {
@@ -17,27 +26,190 @@ type Client interface {
 	MakeNetworkRequest(context.Context, string, string) (*http.Response, error)
 }
 
+// defaultPort is the port Plex Media Server listens on
+// unless it's been configured otherwise.
+const defaultPort = "32400"
+
+// Identify ourselves to Plex the way its own clients do.
+// These show up under Settings > Authorized Devices.
+const (
+	product                 = "Plex Recommendation"
+	defaultClientIdentifier = "plex-recommendation"
+)
+
 type PlexClient struct {
-	accessToken           string
+	accessToken string
+	// address is either a bare host, which is reached over
+	// HTTP on the default Plex port, or a full base URL.
 	address               string
 	httpClient            *http.Client
 	defaultLibrarySection string
+	clientIdentifier      string
+	retry                 retryPolicy
+	breaker               *circuitBreaker
+	identity              *identityCache
 }
 
-func New(accesstoken, address, defaultLibrarySection string) *PlexClient {
-	return &PlexClient{
-		accessToken: accesstoken,
-		address:     address,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+type clientOptions struct {
+	tlsConfig        *tls.Config
+	clientIdentifier string
+	retry            retryPolicy
+	failureThreshold int
+	cooldown         time.Duration
+}
+
+type ClientOption func(*clientOptions)
+
+// WithTLSConfig sets the TLS configuration used to
+// connect to a Plex server over HTTPS.
+func WithTLSConfig(c *tls.Config) ClientOption {
+	return func(o *clientOptions) {
+		o.tlsConfig = c
+	}
+}
+
+// WithClientIdentifier sets the X-Plex-Client-Identifier sent
+// with every request. It should be unique to this deployment
+// and stay the same across restarts.
+func WithClientIdentifier(id string) ClientOption {
+	return func(o *clientOptions) {
+		o.clientIdentifier = id
+	}
+}
+
+// WithRetryPolicy sets how many times an idempotent request is
+// retried after a transient failure, and the bounds of the
+// exponential backoff between attempts.
+func WithRetryPolicy(maxRetries int, baseDelay, maxDelay time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.retry = retryPolicy{
+			maxRetries: maxRetries,
+			baseDelay:  baseDelay,
+			maxDelay:   maxDelay,
+		}
+	}
+}
+
+// WithCircuitBreaker sets how many consecutive failures mark the
+// Plex server as down, and how long requests fail fast before
+// the server is tried again.
+func WithCircuitBreaker(failureThreshold int, cooldown time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.failureThreshold = failureThreshold
+		o.cooldown = cooldown
+	}
+}
+
+// New creates a Plex client. The address may be a host, such as
+// 192.168.1.5, or a full base URL, such as https://plex.example.com
+// or https://192-168-1-5.<hash>.plex.direct:32400.
+func New(accesstoken, address, defaultLibrarySection string, opts ...ClientOption) *PlexClient {
+	options := clientOptions{
+		clientIdentifier: defaultClientIdentifier,
+		retry: retryPolicy{
+			maxRetries: defaultMaxRetries,
+			baseDelay:  defaultBaseDelay,
+			maxDelay:   defaultMaxDelay,
 		},
+		failureThreshold: defaultFailureThreshold,
+		cooldown:         defaultCooldown,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	httpClient := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	if options.tlsConfig != nil {
+		transport := defaultTransport()
+		transport.TLSClientConfig = options.tlsConfig
+		httpClient.Transport = transport
+	}
+
+	return &PlexClient{
+		accessToken:           accesstoken,
+		address:               address,
+		httpClient:            httpClient,
 		defaultLibrarySection: defaultLibrarySection,
+		clientIdentifier:      options.clientIdentifier,
+		retry:                 options.retry,
+		breaker:               newCircuitBreaker(options.failureThreshold, options.cooldown),
+		identity:              &identityCache{},
+	}
+}
+
+// defaultTransport returns a copy of http.DefaultTransport. It may
+// have been wrapped, such as by instrumentation, in which case a
+// transport with the same settings as the standard library's
+// default is returned instead.
+func defaultTransport() *http.Transport {
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		return transport.Clone()
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// NewTLSConfig builds the TLS configuration for a Plex server.
+// Certificates in caFile are trusted alongside the system roots.
+// serverName overrides the name the certificate is verified
+// against, which lets a server reached by IP be verified against
+// its plex.direct certificate. insecureSkipVerify disables
+// verification entirely for self-signed setups.
+func NewTLSConfig(caFile, serverName string, insecureSkipVerify bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	if insecureSkipVerify {
+		log.Println("WARNING: Plex TLS certificate verification is disabled")
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + caFile)
+		}
+		cfg.RootCAs = pool
 	}
+	return cfg, nil
+}
+
+// baseURL returns the scheme, host, port and any path prefix
+// that Plex requests are made against.
+func baseURL(address string) string {
+	if strings.Contains(address, "://") {
+		return strings.TrimRight(address, "/")
+	}
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return "http://" + address
+	}
+	return "http://" + net.JoinHostPort(address, defaultPort)
 }
 
 type connectOptions struct {
 	sectionId string
 	allMovies bool
+	path      string
+	query     url.Values
 }
 type ConnectOption func(*connectOptions)
 
@@ -53,11 +225,30 @@ func WithAllMovies(allMovies bool) ConnectOption {
 	}
 }
 
-// Connect returns a string with our address and token
-// for the provided sectionId
+// WithPath requests an arbitrary Plex path, such as
+// /library/metadata/{ratingKey}, instead of a library section.
+func WithPath(path string) ConnectOption {
+	return func(o *connectOptions) {
+		o.path = path
+	}
+}
+
+// WithQuery adds a query parameter to the connection string.
+func WithQuery(key, value string) ConnectOption {
+	return func(o *connectOptions) {
+		if o.query == nil {
+			o.query = url.Values{}
+		}
+		o.query.Add(key, value)
+	}
+}
+
+// Connect returns a string with our address for the provided
+// sectionId. The token is sent as a header by MakeNetworkRequest
+// so it never appears in the URL.
 func (pc PlexClient) Connect(opts ...ConnectOption) string {
 	log.Println("generating connection string")
-	var options = connectOptions{}
+	var options = connectOptions{query: url.Values{}}
 	for _, opt := range opts {
 		opt(&options)
 	}
@@ -71,13 +262,16 @@ func (pc PlexClient) Connect(opts ...ConnectOption) string {
 		sectionId = options.sectionId
 	}
 
-	return "http://" +
-		pc.address +
-		":32400/library/sections/" +
-		sectionId +
-		endpoint +
-		"?X-Plex-Token=" +
-		pc.accessToken
+	path := "/library/sections/" + sectionId + endpoint
+	if options.path != "" {
+		path = options.path
+	}
+
+	uri := baseURL(pc.address) + path
+	if len(options.query) > 0 {
+		uri += "?" + options.query.Encode()
+	}
+	return uri
 }
 
 // GetDefaultLibrarySection returns the library section to
@@ -88,23 +282,111 @@ func (pc PlexClient) GetDefaultLibrarySection() string {
 }
 
 // MakeNetworkRequest makes an HTTP request with the provided method
-// to the provided endpoint
+// to the provided endpoint. Any response that isn't a success is
+// returned as a *StatusError. Idempotent requests that fail for a
+// transient reason are retried with backoff, and requests fail
+// fast with ErrCircuitOpen while Plex is considered down.
 func (pc PlexClient) MakeNetworkRequest(ctx context.Context, endpoint, method string) (*http.Response, error) {
 	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("MakeNetworkRequest"))
 	defer span.End()
-	span.SetAttributes(attribute.String("endpoint", endpoint))
+	span.SetAttributes(attribute.String("endpoint", telemetry.RedactURL(endpoint)))
 	span.SetAttributes(attribute.String("method", method))
-	req, err := http.NewRequest(method, endpoint, nil)
+
+	var trial bool
+	if pc.breaker != nil {
+		var ok bool
+		if ok, trial = pc.breaker.allow(); !ok {
+			span.RecordError(ErrCircuitOpen)
+			return nil, ErrCircuitOpen
+		}
+	}
+
+	maxRetries := 0
+	if isIdempotent(method) {
+		maxRetries = pc.retry.maxRetries
+	}
+
+	var resp *http.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		resp, err = pc.do(ctx, endpoint, method)
+		if err == nil || attempt >= maxRetries || !isTransient(ctx, err) {
+			break
+		}
+
+		var wait time.Duration
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			wait = statusErr.RetryAfter
+		}
+		delay := pc.retry.backoff(attempt, wait)
+		log.Printf("plex request failed, retrying in %v: %v\n", delay, err)
+		span.AddEvent("retrying", trace.WithAttributes(
+			attribute.Int("attempt", attempt+1),
+			attribute.String("error", err.Error()),
+		))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = ctx.Err()
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if pc.breaker != nil {
+		pc.breaker.record(ctx, trial, err)
+	}
 	if err != nil {
 		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil, err
 	}
+	span.SetStatus(codes.Ok, resp.Status)
+	return resp, nil
+}
+
+// do sends a single attempt of a request, converting
+// unsuccessful responses to a *StatusError.
+func (pc PlexClient) do(ctx context.Context, endpoint, method string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	pc.setHeaders(req)
 
 	resp, err := pc.httpClient.Do(req)
 	if err != nil {
-		span.RecordError(err)
 		return nil, err
 	}
-	span.SetStatus(codes.Ok, resp.Status)
+	if resp.StatusCode >= http.StatusBadRequest {
+		// drain so the connection can be reused
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, &StatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Method:     method,
+			Endpoint:   telemetry.RedactURL(endpoint),
+			RetryAfter: retryAfter(resp),
+		}
+	}
 	return resp, nil
 }
+
+// setHeaders authenticates the request and identifies
+// this app to Plex.
+func (pc PlexClient) setHeaders(req *http.Request) {
+	clientIdentifier := pc.clientIdentifier
+	if clientIdentifier == "" {
+		clientIdentifier = defaultClientIdentifier
+	}
+	req.Header.Set("X-Plex-Token", pc.accessToken)
+	req.Header.Set("X-Plex-Client-Identifier", clientIdentifier)
+	req.Header.Set("X-Plex-Product", product)
+	req.Header.Set("Accept", "application/xml")
+}
@@ -2,8 +2,12 @@ package plex
 
 import (
 	"context"
+	"encoding/pem"
 	"errors"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/jarcoal/httpmock"
@@ -27,13 +31,13 @@ func TestPlexClientConnect(t *testing.T) {
 			name:        "All Movies",
 			sectionID:   "123",
 			allMovies:   true,
-			expectedURL: "http://localhost:32400/library/sections/123/all?X-Plex-Token=random_token_value",
+			expectedURL: "http://localhost:32400/library/sections/123/all",
 		},
 		{
 			name:        "Recently Viewed",
 			sectionID:   "456", // Different section ID for variety
 			allMovies:   false,
-			expectedURL: "http://localhost:32400/library/sections/456/recentlyViewed?X-Plex-Token=random_token_value",
+			expectedURL: "http://localhost:32400/library/sections/456/recentlyViewed",
 		},
 	}
 
@@ -45,6 +49,144 @@ func TestPlexClientConnect(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("Path", func(t *testing.T) {
+		expectedURL := "http://localhost:32400/library/metadata/10"
+		actualURL := testClient.Connect(WithSectionID("123"), WithPath("/library/metadata/10"))
+		if actualURL != expectedURL {
+			t.Errorf("URL mismatch: expected %s, got %s", expectedURL, actualURL)
+		}
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		expectedURL := "http://localhost:32400/library/sections/123/all?X-Plex-Container-Size=0"
+		actualURL := testClient.Connect(WithSectionID("123"), WithAllMovies(true), WithQuery("X-Plex-Container-Size", "0"))
+		if actualURL != expectedURL {
+			t.Errorf("URL mismatch: expected %s, got %s", expectedURL, actualURL)
+		}
+	})
+}
+
+func TestBaseURL(t *testing.T) {
+	testCases := []struct {
+		name     string
+		address  string
+		expected string
+	}{
+		{name: "Bare Host", address: "192.168.1.5", expected: "http://192.168.1.5:32400"},
+		{name: "Host And Port", address: "nas.local:32401", expected: "http://nas.local:32401"},
+		{name: "HTTPS URL", address: "https://plex.example.com", expected: "https://plex.example.com"},
+		{name: "Reverse Proxy Path", address: "https://example.com/plex/", expected: "https://example.com/plex"},
+		{name: "Plex Direct", address: "https://192-168-1-5.abc123.plex.direct:32400", expected: "https://192-168-1-5.abc123.plex.direct:32400"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := baseURL(tc.address); actual != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<MediaContainer size="0"/>`))
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPem, 0o600); err != nil {
+		t.Fatalf("could not write CA file: %v", err)
+	}
+
+	t.Run("Untrusted", func(t *testing.T) {
+		c := New("randomToken", server.URL, "1")
+		if _, err := c.MakeNetworkRequest(context.Background(), c.Connect(), http.MethodGet); err == nil {
+			t.Error("expected an untrusted certificate to fail")
+		}
+	})
+
+	t.Run("Custom CA", func(t *testing.T) {
+		tlsConfig, err := NewTLSConfig(caFile, "", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c := New("randomToken", server.URL, "1", WithTLSConfig(tlsConfig))
+		resp, err := c.MakeNetworkRequest(context.Background(), c.Connect(), http.MethodGet)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	})
+
+	t.Run("Skip Verify", func(t *testing.T) {
+		tlsConfig, err := NewTLSConfig("", "", true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c := New("randomToken", server.URL, "1", WithTLSConfig(tlsConfig))
+		resp, err := c.MakeNetworkRequest(context.Background(), c.Connect(), http.MethodGet)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	})
+
+	t.Run("Wrapped Default Transport", func(t *testing.T) {
+		previous := http.DefaultTransport
+		t.Cleanup(func() { http.DefaultTransport = previous })
+		http.DefaultTransport = roundTripperFunc(previous.RoundTrip)
+
+		tlsConfig, err := NewTLSConfig(caFile, "", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c := New("randomToken", server.URL, "1", WithTLSConfig(tlsConfig))
+		resp, err := c.MakeNetworkRequest(context.Background(), c.Connect(), http.MethodGet)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	})
+
+	t.Run("Missing CA File", func(t *testing.T) {
+		if _, err := NewTLSConfig(filepath.Join(t.TempDir(), "missing.pem"), "", false); err == nil {
+			t.Error("expected an error for a missing CA file")
+		}
+	})
+}
+
+func TestPlexClientSendsHeaders(t *testing.T) {
+	var got http.Header
+	var gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		gotQuery = r.URL.RawQuery
+	}))
+	defer server.Close()
+
+	c := New("secretToken", server.URL, "1", WithClientIdentifier("test-client"))
+	resp, err := c.MakeNetworkRequest(context.Background(), c.Connect(WithPath("/identity")), http.MethodGet)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	expected := map[string]string{
+		"X-Plex-Token":             "secretToken",
+		"X-Plex-Client-Identifier": "test-client",
+		"X-Plex-Product":           product,
+	}
+	for header, value := range expected {
+		if got.Get(header) != value {
+			t.Errorf("expected %s header %q, got %q", header, value, got.Get(header))
+		}
+	}
+	if gotQuery != "" {
+		t.Errorf("expected no query string, got %q", gotQuery)
+	}
 }
 
 // TestPlexClientMakeNetworkRequest was written entirely
@@ -111,3 +253,28 @@ func TestPlexClientMakeNetworkRequest(t *testing.T) {
 		})
 	}
 }
+
+// roundTripperFunc stands in for a transport that wraps another.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// wrappedTransport stands in for instrumentation
+// wrapping http.DefaultTransport.
+type wrappedTransport struct {
+	http.RoundTripper
+}
+
+func TestDefaultTransportWhenWrapped(t *testing.T) {
+	previous := http.DefaultTransport
+	t.Cleanup(func() { http.DefaultTransport = previous })
+	http.DefaultTransport = wrappedTransport{previous}
+
+	transport := defaultTransport()
+	if transport.Proxy == nil || transport.DialContext == nil || transport.TLSHandshakeTimeout == 0 ||
+		transport.IdleConnTimeout == 0 || !transport.ForceAttemptHTTP2 {
+		t.Errorf("expected the standard library's defaults, got %+v", transport)
+	}
+}
@@ -0,0 +1,154 @@
+package plex
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// metadataTypeIDs are Plex's numeric IDs for the metadata types
+// we recommend. Plex needs them to edit several items at once.
+var metadataTypeIDs = map[string]int{
+	movieType: 1,
+	showType:  2,
+}
+
+// GetCollection returns the collection in the section with the
+// provided title. Nil is returned if there isn't one.
+func GetCollection(ctx context.Context, c Client, sectionId, title string) (*Directory, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetCollection"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.String("section", sectionId), attribute.String("title", title))
+	var container MediaContainer
+	if err := getXML(ctx, c, c.Connect(WithPath("/library/sections/"+sectionId+"/collections")), &container); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	for _, dir := range container.Directories {
+		if dir.Title == title {
+			span.SetStatus(codes.Ok, "collection found")
+			return &dir, nil
+		}
+	}
+	span.SetStatus(codes.Ok, "no collection found")
+	return nil, nil
+}
+
+// GetCollectionItems returns the movies and shows in the
+// collection with the provided rating key.
+func GetCollectionItems(ctx context.Context, c Client, ratingKey int) ([]VideoShort, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetCollectionItems"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.Int("ratingKey", ratingKey))
+	var container MediaContainer
+	path := fmt.Sprintf("/library/collections/%d/children", ratingKey)
+	if err := getXML(ctx, c, c.Connect(WithPath(path)), &container); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	shorts := fullToShort(container.Videos, len(container.Videos))
+	shorts = append(shorts, showsToShort(container.Directories)...)
+	span.SetAttributes(attribute.Int("count", len(shorts)))
+	span.SetStatus(codes.Ok, "collection items retrieved")
+	return shorts, nil
+}
+
+// SetCollection makes the collection with the provided title
+// in the section hold exactly the provided videos. Videos that
+// are no longer wanted have the collection removed from them
+// and only videos not already collected are tagged, so repeated
+// calls replace the contents rather than add to them. Plex
+// creates the collection when the first video is tagged.
+func SetCollection(ctx context.Context, c Client, sectionId, title string, videos []VideoShort) error {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("SetCollection"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.String("section", sectionId), attribute.String("title", title))
+	if len(videos) == 0 {
+		err := fmt.Errorf("no media to add to collection %q", title)
+		span.RecordError(err)
+		return err
+	}
+
+	var current []VideoShort
+	collection, err := GetCollection(ctx, c, sectionId, title)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if collection != nil {
+		current, err = GetCollectionItems(ctx, c, collection.RatingKey)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	for videoType, ratingKeys := range groupRatingKeys(current, videos) {
+		if err := editCollection(ctx, c, sectionId, title, videoType, ratingKeys, true); err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+	span.AddEvent("stale items removed")
+
+	for videoType, ratingKeys := range groupRatingKeys(videos, current) {
+		if err := editCollection(ctx, c, sectionId, title, videoType, ratingKeys, false); err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+	span.AddEvent("new items added")
+	span.SetStatus(codes.Ok, "collection set")
+	return nil
+}
+
+// groupRatingKeys returns the rating keys of the videos that
+// aren't in exclude, grouped by the videos' type.
+func groupRatingKeys(videos, exclude []VideoShort) map[string][]int {
+	excluded := make(map[int]bool, len(exclude))
+	for _, video := range exclude {
+		excluded[video.RatingKey] = true
+	}
+	groups := make(map[string][]int)
+	for _, video := range videos {
+		if video.RatingKey == 0 || excluded[video.RatingKey] {
+			continue
+		}
+		if slices.Contains(groups[video.Type], video.RatingKey) {
+			continue
+		}
+		groups[video.Type] = append(groups[video.Type], video.RatingKey)
+	}
+	return groups
+}
+
+// editCollection adds the collection to, or removes it from,
+// the videos with the provided rating keys. The collection is
+// locked so Plex's agents don't change it on a refresh.
+func editCollection(ctx context.Context, c Client, sectionId, title, videoType string, ratingKeys []int, remove bool) error {
+	typeID, ok := metadataTypeIDs[videoType]
+	if !ok {
+		log.Printf("skipping %d items of type %q in collection %q\n", len(ratingKeys), videoType, title)
+		return nil
+	}
+	opts := []ConnectOption{
+		WithSectionID(sectionId),
+		WithAllMovies(allMovies),
+		WithQuery("type", strconv.Itoa(typeID)),
+		WithQuery("id", joinRatingKeys(ratingKeys)),
+		WithQuery("collection.locked", "1"),
+	}
+	if remove {
+		opts = append(opts, WithQuery("collection[].tag.tag-", title))
+	} else {
+		opts = append(opts, WithQuery("collection[0].tag.tag", title))
+	}
+	return requestXML(ctx, c, c.Connect(opts...), http.MethodPut, nil)
+}
@@ -0,0 +1,73 @@
+package plex
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestSetCollection(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/library/sections/1/collections",
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="1">
+	<Directory ratingKey="500" type="collection" title="Recommended for Kid" subtype="movie" childCount="2"/>
+</MediaContainer>`))
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/library/collections/500/children",
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="2">
+	<Video ratingKey="20" type="movie" title="Movie A"/>
+	<Video ratingKey="21" type="movie" title="Movie B"/>
+</MediaContainer>`))
+	httpmock.RegisterResponderWithQuery(http.MethodPut, "http://localhost:32400/library/sections/1/all",
+		map[string]string{
+			"type":                  "1",
+			"id":                    "21",
+			"collection.locked":     "1",
+			"collection[].tag.tag-": "Recommended for Kid",
+		},
+		httpmock.NewStringResponder(http.StatusOK, ""))
+	httpmock.RegisterResponderWithQuery(http.MethodPut, "http://localhost:32400/library/sections/1/all",
+		map[string]string{
+			"type":                  "1",
+			"id":                    "22",
+			"collection.locked":     "1",
+			"collection[0].tag.tag": "Recommended for Kid",
+		},
+		httpmock.NewStringResponder(http.StatusOK, ""))
+
+	videos := []VideoShort{
+		{Title: "Movie A", RatingKey: 20, Type: movieType},
+		{Title: "Movie C", RatingKey: 22, Type: movieType},
+	}
+	if err := SetCollection(context.Background(), New("randomToken", "localhost", "1"), "1", "Recommended for Kid", videos); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the collection is looked up, Movie B is removed
+	// and only Movie C is added
+	if total := httpmock.GetTotalCallCount(); total != 4 {
+		t.Errorf("expected 4 requests, got %d: %v", total, httpmock.GetCallCountInfo())
+	}
+}
+
+func TestGroupRatingKeys(t *testing.T) {
+	videos := []VideoShort{
+		{RatingKey: 20, Type: movieType},
+		{RatingKey: 10, Type: showType},
+		{RatingKey: 20, Type: movieType},
+		{RatingKey: 21, Type: movieType},
+		{Title: "No Key", Type: movieType},
+	}
+	exclude := []VideoShort{{RatingKey: 21, Type: movieType}}
+
+	expected := map[string][]int{
+		movieType: {20},
+		showType:  {10},
+	}
+	if result := groupRatingKeys(videos, exclude); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
@@ -0,0 +1,51 @@
+package plex
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrUnauthorized is returned when Plex rejects our token.
+	ErrUnauthorized = errors.New("plex: unauthorized")
+	// ErrNotFound is returned when the requested Plex resource
+	// doesn't exist, such as an unknown section or rating key.
+	ErrNotFound = errors.New("plex: not found")
+	// ErrServer is returned when Plex fails to handle a request.
+	ErrServer = errors.New("plex: server error")
+	// ErrCircuitOpen is returned without contacting Plex while
+	// the server is considered down after repeated failures.
+	ErrCircuitOpen = errors.New("plex: circuit open, server unavailable")
+)
+
+// StatusError is returned for any response from Plex that isn't
+// a success. Use errors.Is with ErrUnauthorized, ErrNotFound or
+// ErrServer to check what kind of failure it was.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Method     string
+	// Endpoint is redacted so the error is safe to log
+	Endpoint string
+	// RetryAfter is how long Plex asked us to wait
+	// before trying again, if it said.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("plex: %s %s: %s", e.Method, e.Endpoint, e.Status)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
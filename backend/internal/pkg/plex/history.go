@@ -0,0 +1,227 @@
+package plex
+
+import (
+	"context"
+	"encoding/xml"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// historyPageSize is how many history entries are requested from
+// Plex at a time. Episodes are rolled up into their show after
+// retrieval, so this is larger than the number of videos we
+// usually return.
+const historyPageSize = 100
+
+// historyMaxPages stops a long history window from paging
+// through years of plays.
+const historyMaxPages = 20
+
+// Account is a Plex user with access to the server, including
+// the owner, Plex Home members and managed accounts.
+type Account struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type accountsContainer struct {
+	XMLName  xml.Name  `xml:"MediaContainer"`
+	Accounts []Account `xml:"Account"`
+}
+
+// GetAccounts lists the accounts that can watch media
+// on the Plex server.
+func GetAccounts(ctx context.Context, c Client) ([]Account, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetAccounts"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	var container accountsContainer
+	if err := getXML(ctx, c, c.Connect(WithPath("/accounts")), &container); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	// Plex reports a nameless system account with ID 0
+	// that never has watch history of its own.
+	accounts := make([]Account, 0, len(container.Accounts))
+	for _, account := range container.Accounts {
+		if account.ID == 0 || account.Name == "" {
+			continue
+		}
+		accounts = append(accounts, account)
+	}
+	span.SetAttributes(attribute.Int("count", len(accounts)))
+	span.SetStatus(codes.Ok, "accounts retrieved")
+	return accounts, nil
+}
+
+// FindAccount returns the account whose ID or name, ignoring
+// case, matches user.
+func FindAccount(accounts []Account, user string) (*Account, bool) {
+	id, err := strconv.Atoi(user)
+	for _, account := range accounts {
+		if (err == nil && account.ID == id) || strings.EqualFold(account.Name, user) {
+			return &account, true
+		}
+	}
+	return nil, false
+}
+
+type historyOptions struct {
+	accountID int
+	since     time.Time
+	until     time.Time
+}
+
+type HistoryOption func(*historyOptions)
+
+// WithAccountID limits watch history to a single Plex account.
+func WithAccountID(id int) HistoryOption {
+	return func(o *historyOptions) {
+		o.accountID = id
+	}
+}
+
+// WithViewedSince limits watch history to plays after t.
+func WithViewedSince(t time.Time) HistoryOption {
+	return func(o *historyOptions) {
+		o.since = t
+	}
+}
+
+// WithViewedUntil limits watch history to plays before t.
+func WithViewedUntil(t time.Time) HistoryOption {
+	return func(o *historyOptions) {
+		o.until = t
+	}
+}
+
+// GetWatchHistory returns up to limit of the most recently watched
+// videos in the section from the server's watch history. History
+// is paged through until limit videos are found, so a window of
+// plays can reach further back than the most recent page.
+func GetWatchHistory(ctx context.Context, c Client, sectionId string, limit int, opts ...HistoryOption) ([]VideoShort, error) {
+	if sectionId == "" {
+		sectionId = c.GetDefaultLibrarySection()
+	}
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetWatchHistory"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+
+	log.Println("getting watch history...")
+	var history []Video
+	err := readHistory(ctx, c, sectionId, opts, func(page MediaContainer) bool {
+		history = append(history, page.Videos...)
+		return len(fullToShort(history, limit)) >= limit
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	log.Printf("history count: %v\n", len(history))
+	span.SetAttributes(attribute.Int("total count", len(history)))
+
+	// history entries don't carry summaries or ratings, so the
+	// full metadata is filled in for each video we return.
+	shorts := fullToShort(history, limit)
+	hydrateMetadata(ctx, c, shorts)
+	setSectionID(shorts, sectionId)
+	span.SetStatus(codes.Ok, "watch history complete")
+	return shorts, nil
+}
+
+// GetWatched returns every video in the section's watch history,
+// most recently watched first, without filling in the rest of their
+// metadata. Each video's ViewCount is how many times it was played
+// in the history, counting every episode of a show, and LastViewedAt
+// is when it last was. Plex's own play counts, progress and ratings
+// belong to the account that owns the server, so with WithAccountID
+// this is how to tell what anyone else has seen.
+func GetWatched(ctx context.Context, c Client, sectionId string, opts ...HistoryOption) ([]VideoShort, error) {
+	if sectionId == "" {
+		sectionId = c.GetDefaultLibrarySection()
+	}
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetWatched"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+
+	var history []Video
+	err := readHistory(ctx, c, sectionId, opts, func(page MediaContainer) bool {
+		history = append(history, page.Videos...)
+		return false
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	plays := make(map[int]int)
+	lastViewed := make(map[int]int64)
+	for _, vid := range history {
+		key := vid.RatingKey
+		if vid.Type == episodeType {
+			key = vid.GrandparentRatingKey
+			if key == 0 {
+				key = ratingKeyFromKey(vid.GrandparentKey)
+			}
+		}
+		plays[key]++
+		lastViewed[key] = max(lastViewed[key], vid.ViewedAt)
+	}
+	shorts := fullToShort(history, len(history))
+	for i := range shorts {
+		shorts[i].ViewCount = plays[shorts[i].RatingKey]
+		shorts[i].LastViewedAt = lastViewed[shorts[i].RatingKey]
+	}
+	setSectionID(shorts, sectionId)
+	span.SetAttributes(attribute.Int("watched", len(shorts)))
+	span.SetStatus(codes.Ok, "watched complete")
+	return shorts, nil
+}
+
+// readHistory pages through the section's history, most recent
+// first, handing each page to add until add reports that it has
+// enough or there's no more history.
+func readHistory(ctx context.Context, c Client, sectionId string, opts []HistoryOption, add func(MediaContainer) bool) error {
+	span := trace.SpanFromContext(ctx)
+	options := historyOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	span.SetAttributes(attribute.String("section", sectionId))
+
+	connectOpts := []ConnectOption{
+		WithPath("/status/sessions/history/all"),
+		WithQuery("librarySectionID", sectionId),
+		WithQuery("sort", "viewedAt:desc"),
+		WithQuery("X-Plex-Container-Size", strconv.Itoa(historyPageSize)),
+	}
+	if options.accountID != 0 {
+		span.SetAttributes(attribute.Int("accountID", options.accountID))
+		connectOpts = append(connectOpts, WithQuery("accountID", strconv.Itoa(options.accountID)))
+	}
+	if !options.since.IsZero() {
+		span.SetAttributes(attribute.String("since", options.since.Format(time.RFC3339)))
+		connectOpts = append(connectOpts, WithQuery("viewedAt>", strconv.FormatInt(options.since.Unix(), 10)))
+	}
+	if !options.until.IsZero() {
+		span.SetAttributes(attribute.String("until", options.until.Format(time.RFC3339)))
+		connectOpts = append(connectOpts, WithQuery("viewedAt<", strconv.FormatInt(options.until.Unix(), 10)))
+	}
+
+	for page := 0; page < historyMaxPages; page++ {
+		pageOpts := append(slices.Clip(connectOpts), WithQuery("X-Plex-Container-Start", strconv.Itoa(page*historyPageSize)))
+		var container MediaContainer
+		if err := getXML(ctx, c, c.Connect(pageOpts...), &container); err != nil {
+			return err
+		}
+		if add(container) || len(container.Videos)+len(container.Tracks) < historyPageSize {
+			return nil
+		}
+	}
+	return nil
+}
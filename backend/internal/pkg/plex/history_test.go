@@ -0,0 +1,188 @@
+package plex
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestGetAccounts(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/accounts",
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="3">
+	<Account id="0" key="/accounts/0" name=""/>
+	<Account id="1" key="/accounts/1" name="owner"/>
+	<Account id="2" key="/accounts/2" name="Kid"/>
+</MediaContainer>`))
+
+	accounts, err := GetAccounts(context.Background(), New("randomToken", "localhost", "1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Account{{ID: 1, Name: "owner"}, {ID: 2, Name: "Kid"}}
+	if !reflect.DeepEqual(accounts, expected) {
+		t.Errorf("expected %+v, got %+v", expected, accounts)
+	}
+}
+
+func TestFindAccount(t *testing.T) {
+	accounts := []Account{{ID: 1, Name: "owner"}, {ID: 2, Name: "Kid"}}
+
+	testCases := []struct {
+		name       string
+		user       string
+		expectedID int
+		found      bool
+	}{
+		{name: "By ID", user: "2", expectedID: 2, found: true},
+		{name: "By Name Ignoring Case", user: "kid", expectedID: 2, found: true},
+		{name: "Unknown", user: "guest", found: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			account, ok := FindAccount(accounts, tc.user)
+			if ok != tc.found {
+				t.Fatalf("expected found %v, got %v", tc.found, ok)
+			}
+			if ok && account.ID != tc.expectedID {
+				t.Errorf("expected account %d, got %d", tc.expectedID, account.ID)
+			}
+		})
+	}
+}
+
+func TestGetWatchHistory(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponderWithQuery(http.MethodGet, "http://localhost:32400/status/sessions/history/all",
+		map[string]string{
+			"accountID":              "2",
+			"librarySectionID":       "2",
+			"sort":                   "viewedAt:desc",
+			"X-Plex-Container-Start": "0",
+			"X-Plex-Container-Size":  "100",
+		},
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="3">
+	<Video historyKey="/status/sessions/history/3" key="/library/metadata/12" ratingKey="12" grandparentKey="/library/metadata/10" title="Diversity Day" grandparentTitle="The Office" type="episode" viewedAt="1716000300" accountID="2"/>
+	<Video historyKey="/status/sessions/history/2" key="/library/metadata/11" ratingKey="11" grandparentKey="/library/metadata/10" title="Pilot" grandparentTitle="The Office" type="episode" viewedAt="1716000200" accountID="2"/>
+	<Video historyKey="/status/sessions/history/1" key="/library/metadata/20" ratingKey="20" title="Movie A" type="movie" viewedAt="1716000100" accountID="2"/>
+</MediaContainer>`))
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/library/metadata/10",
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="1">
+	<Directory ratingKey="10" guid="plex://show/office" type="show" title="The Office" summary="A mockumentary." contentRating="TV-14" childCount="9" leafCount="201" viewedLeafCount="2"/>
+</MediaContainer>`))
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/library/metadata/20",
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="1">
+	<Video ratingKey="20" guid="plex://movie/a" type="movie" title="Movie A" summary="Action-packed" contentRating="R"/>
+</MediaContainer>`))
+
+	history, err := GetWatchHistory(context.Background(), New("randomToken", "localhost", "1"), "2", 5, WithAccountID(2))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []VideoShort{
+		{Title: "The Office", Summary: "A mockumentary.", ContentRating: "TV-14", PlexID: "plex://show/office", RatingKey: 10, Type: showType, SeasonCount: 9, EpisodeCount: 201, ShowStatus: showStatusInProgress, SectionID: "2"},
+		{Title: "Movie A", Summary: "Action-packed", ContentRating: "R", PlexID: "plex://movie/a", RatingKey: 20, Type: movieType, SectionID: "2"},
+	}
+	if !reflect.DeepEqual(history, expected) {
+		t.Errorf("expected %+v, got %+v", expected, history)
+	}
+}
+
+func TestGetWatched(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponderWithQuery(http.MethodGet, "http://localhost:32400/status/sessions/history/all",
+		map[string]string{
+			"accountID":              "2",
+			"librarySectionID":       "2",
+			"sort":                   "viewedAt:desc",
+			"X-Plex-Container-Start": "0",
+			"X-Plex-Container-Size":  "100",
+		},
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="3">
+	<Video key="/library/metadata/12" ratingKey="12" grandparentKey="/library/metadata/10" title="Diversity Day" grandparentTitle="The Office" type="episode" viewedAt="1716000300" accountID="2"/>
+	<Video key="/library/metadata/11" ratingKey="11" grandparentKey="/library/metadata/10" title="Pilot" grandparentTitle="The Office" type="episode" viewedAt="1716000200" accountID="2"/>
+	<Video key="/library/metadata/20" ratingKey="20" title="Movie A" type="movie" viewedAt="1716000100" viewCount="7" accountID="2"/>
+</MediaContainer>`))
+
+	watched, err := GetWatched(context.Background(), New("randomToken", "localhost", "1"), "2", WithAccountID(2))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []VideoShort{
+		{Title: "The Office", RatingKey: 10, Key: "/library/metadata/10", Type: showType, ViewCount: 2, LastViewedAt: 1716000300, SectionID: "2"},
+		{Title: "Movie A", RatingKey: 20, Key: "/library/metadata/20", Type: movieType, ViewCount: 1, LastViewedAt: 1716000100, SectionID: "2"},
+	}
+	if !reflect.DeepEqual(watched, expected) {
+		t.Errorf("expected %+v, got %+v", expected, watched)
+	}
+	// nothing else is looked up for the videos
+	if calls := httpmock.GetTotalCallCount(); calls != 1 {
+		t.Errorf("expected 1 request, got %d", calls)
+	}
+}
+
+func TestGetWatchHistoryPagesThroughWindow(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	// a full first page of one show's episodes rolls up into a
+	// single video, so the second page is needed to reach the limit
+	var firstPage strings.Builder
+	firstPage.WriteString(`<MediaContainer size="100">`)
+	for i := 0; i < historyPageSize; i++ {
+		fmt.Fprintf(&firstPage, `<Video ratingKey="%d" grandparentKey="/library/metadata/10" grandparentTitle="The Office" title="Episode" type="episode" summary="An episode."/>`, 100+i)
+	}
+	firstPage.WriteString(`</MediaContainer>`)
+
+	since := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	query := func(start string) map[string]string {
+		return map[string]string{
+			"librarySectionID":       "2",
+			"sort":                   "viewedAt:desc",
+			"viewedAt>":              "1711929600",
+			"viewedAt<":              "1714521600",
+			"X-Plex-Container-Start": start,
+			"X-Plex-Container-Size":  "100",
+		}
+	}
+	httpmock.RegisterResponderWithQuery(http.MethodGet, "http://localhost:32400/status/sessions/history/all", query("0"),
+		httpmock.NewStringResponder(http.StatusOK, firstPage.String()))
+	httpmock.RegisterResponderWithQuery(http.MethodGet, "http://localhost:32400/status/sessions/history/all", query("100"),
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="1">
+	<Video ratingKey="20" guid="plex://movie/a" type="movie" title="Movie A" summary="Action-packed"/>
+</MediaContainer>`))
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/library/metadata/10",
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="1">
+	<Directory ratingKey="10" guid="plex://show/office" type="show" title="The Office" summary="A mockumentary."/>
+</MediaContainer>`))
+
+	history, err := GetWatchHistory(context.Background(), New("randomToken", "localhost", "1"), "2", 2,
+		WithViewedSince(since), WithViewedUntil(until))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	titles := make([]string, 0, len(history))
+	for _, video := range history {
+		titles = append(titles, video.Title)
+	}
+	expected := []string{"The Office", "Movie A"}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("expected %v, got %v", expected, titles)
+	}
+}
@@ -0,0 +1,69 @@
+package plex
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"sync"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// identityCache remembers the server's machine identifier,
+// which doesn't change for the life of the server.
+type identityCache struct {
+	mu                sync.Mutex
+	machineIdentifier string
+}
+
+// identityCache returns the client's cache of the
+// server's identity.
+func (pc PlexClient) identityCache() *identityCache {
+	return pc.identity
+}
+
+// identityCacher is a Client that can remember the
+// server's identity between requests.
+type identityCacher interface {
+	identityCache() *identityCache
+}
+
+type identityContainer struct {
+	XMLName           xml.Name `xml:"MediaContainer"`
+	MachineIdentifier string   `xml:"machineIdentifier,attr"`
+}
+
+// GetMachineIdentifier returns the unique ID of the Plex server.
+// Plex uses it to address the server's media in playlist URIs
+// and app links. It's only requested from Plex once per client.
+func GetMachineIdentifier(ctx context.Context, c Client) (string, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetMachineIdentifier"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	var cache *identityCache
+	if cacher, ok := c.(identityCacher); ok && cacher.identityCache() != nil {
+		cache = cacher.identityCache()
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		if cache.machineIdentifier != "" {
+			span.SetStatus(codes.Ok, "machine identifier cached")
+			return cache.machineIdentifier, nil
+		}
+	}
+
+	var container identityContainer
+	if err := getXML(ctx, c, c.Connect(WithPath("/identity")), &container); err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+	if container.MachineIdentifier == "" {
+		err := errors.New("plex did not report a machine identifier")
+		span.RecordError(err)
+		return "", err
+	}
+	if cache != nil {
+		cache.machineIdentifier = container.MachineIdentifier
+	}
+	span.SetStatus(codes.Ok, "machine identifier retrieved")
+	return container.MachineIdentifier, nil
+}
@@ -0,0 +1,76 @@
+package plex
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// The kinds of artwork Plex keeps for a movie or show.
+const (
+	ImageThumb = "thumb"
+	ImageArt   = "art"
+)
+
+type imageOptions struct {
+	width  int
+	height int
+}
+
+type ImageOption func(*imageOptions)
+
+// WithImageSize has Plex's transcoder resize the image to fit
+// within width by height, keeping its aspect ratio.
+func WithImageSize(width, height int) ImageOption {
+	return func(o *imageOptions) {
+		o.width = width
+		o.height = height
+	}
+}
+
+// GetImage requests the poster or background image of the movie
+// or show with the provided rating key. The image is streamed
+// from the response, and the caller must close its body.
+func GetImage(ctx context.Context, c Client, ratingKey int, kind string, opts ...ImageOption) (*http.Response, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetImage"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.Int("ratingKey", ratingKey), attribute.String("kind", kind))
+	if kind != ImageThumb && kind != ImageArt {
+		err := fmt.Errorf("unknown image kind %q, use %q or %q", kind, ImageThumb, ImageArt)
+		span.RecordError(err)
+		return nil, err
+	}
+	options := imageOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	// Plex serves the current image at this path, so the
+	// versioned path on the metadata isn't needed
+	imagePath := fmt.Sprintf("/library/metadata/%d/%s", ratingKey, kind)
+	uri := c.Connect(WithPath(imagePath))
+	if options.width > 0 && options.height > 0 {
+		span.SetAttributes(attribute.Int("width", options.width), attribute.Int("height", options.height))
+		uri = c.Connect(
+			WithPath("/photo/:/transcode"),
+			WithQuery("url", imagePath),
+			WithQuery("width", strconv.Itoa(options.width)),
+			WithQuery("height", strconv.Itoa(options.height)),
+			WithQuery("minSize", "1"),
+			WithQuery("upscale", "1"),
+		)
+	}
+
+	resp, err := c.MakeNetworkRequest(ctx, uri, http.MethodGet)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetStatus(codes.Ok, "image retrieved")
+	return resp, nil
+}
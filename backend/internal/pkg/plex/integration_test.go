@@ -0,0 +1,306 @@
+package plex
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex/plextest"
+)
+
+// newTestServer serves a small movie, TV and music library where
+// the owner watched a movie and listened to an album, and a kid
+// binged a show and listened to some jazz.
+func newTestServer(t *testing.T) *plextest.Server {
+	t.Helper()
+	watchedAt := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+	server := plextest.NewServer(
+		plextest.WithSection(plextest.Section{Key: "1", Type: movieType, Title: "Movies", Items: []plextest.Item{
+			{RatingKey: 20, Guid: "plex://movie/a", Title: "Movie A", Summary: "Action-packed", ContentRating: "R", Year: 2001, Genres: []string{"Action"}, ViewCount: 1},
+			{RatingKey: 21, Guid: "plex://movie/b", Title: "Movie B", Summary: "Heartwarming", ContentRating: "PG"},
+			{RatingKey: 22, Guid: "plex://movie/c", Title: "Movie C", Summary: "Terrifying", ContentRating: "R"},
+		}}),
+		plextest.WithSection(plextest.Section{Key: "2", Type: showType, Title: "TV Shows", Items: []plextest.Item{
+			{RatingKey: 10, Guid: "plex://show/office", Title: "The Office", Summary: "A mockumentary.", ContentRating: "TV-14", Episodes: []plextest.Episode{
+				{RatingKey: 11, Title: "Pilot", Season: 1, Index: 1, ViewCount: 1},
+				{RatingKey: 12, Title: "Diversity Day", Season: 1, Index: 2, ViewCount: 1},
+				{RatingKey: 13, Title: "The Dundies", Season: 2, Index: 1},
+			}},
+		}}),
+		plextest.WithSection(plextest.Section{Key: "3", Type: artistType, Title: "Music", Items: []plextest.Item{
+			{RatingKey: 30, Guid: "plex://artist/radiohead", Title: "Radiohead", Albums: []plextest.Album{
+				{RatingKey: 31, Guid: "plex://album/ok-computer", Title: "OK Computer", Year: 1997, Genres: []string{"Alternative"}, Moods: []string{"Brooding"}, ViewCount: 2, Tracks: []plextest.Track{
+					{RatingKey: 32, Title: "Airbag", Index: 1, ViewCount: 1},
+					{RatingKey: 33, Title: "Paranoid Android", Index: 2, ViewCount: 1},
+				}},
+				{RatingKey: 34, Guid: "plex://album/in-rainbows", Title: "In Rainbows", Year: 2007, Tracks: []plextest.Track{
+					{RatingKey: 35, Title: "15 Step", Index: 1},
+				}},
+			}},
+			{RatingKey: 40, Guid: "plex://artist/miles-davis", Title: "Miles Davis", Albums: []plextest.Album{
+				{RatingKey: 41, Guid: "plex://album/kind-of-blue", Title: "Kind of Blue", Year: 1959, Genres: []string{"Jazz"}, Moods: []string{"Cool"}, Tracks: []plextest.Track{
+					{RatingKey: 42, Title: "So What", Index: 1},
+				}},
+			}},
+		}}),
+		plextest.WithAccount(1, "owner"),
+		plextest.WithAccount(2, "Kid"),
+		plextest.WithPlays(
+			plextest.Play{AccountID: 1, RatingKey: 20, ViewedAt: watchedAt.AddDate(0, -2, 0)},
+			plextest.Play{AccountID: 2, RatingKey: 11, ViewedAt: watchedAt},
+			plextest.Play{AccountID: 2, RatingKey: 12, ViewedAt: watchedAt.Add(time.Hour)},
+			plextest.Play{AccountID: 1, RatingKey: 32, ViewedAt: watchedAt.AddDate(0, 0, -1)},
+			plextest.Play{AccountID: 1, RatingKey: 33, ViewedAt: watchedAt.AddDate(0, 0, -1).Add(5 * time.Minute)},
+			plextest.Play{AccountID: 2, RatingKey: 42, ViewedAt: watchedAt.Add(2 * time.Hour)},
+		),
+	)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestIntegrationSectionSummaries(t *testing.T) {
+	server := newTestServer(t)
+	summaries, err := GetSectionSummaries(context.Background(), New(server.Token(), server.URL, "1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []SectionSummary{
+		{ID: "1", Title: "Movies", Type: movieType, ItemCount: 3},
+		{ID: "2", Title: "TV Shows", Type: showType, ItemCount: 1},
+		{ID: "3", Title: "Music", Type: artistType, ItemCount: 2},
+	}
+	if !reflect.DeepEqual(summaries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, summaries)
+	}
+}
+
+func TestIntegrationVideoPager(t *testing.T) {
+	server := newTestServer(t)
+	pager := NewVideoPager(New(server.Token(), server.URL, "1"), "1", 2)
+	var titles []string
+	var pages int
+	for pager.Next(context.Background()) {
+		pages++
+		for _, video := range pager.Page() {
+			if video.SectionID != "1" {
+				t.Errorf("expected section 1, got %q", video.SectionID)
+			}
+			titles = append(titles, video.Title)
+		}
+	}
+	if err := pager.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"Movie A", "Movie B", "Movie C"}
+	if pages != 2 || !reflect.DeepEqual(titles, expected) {
+		t.Errorf("expected %v over 2 pages, got %v over %d", expected, titles, pages)
+	}
+}
+
+func TestIntegrationRecentlyPlayed(t *testing.T) {
+	server := newTestServer(t)
+	recent, err := GetRecentlyPlayed(context.Background(), New(server.Token(), server.URL, "1"), "2", 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []VideoShort{{
+		Title:         "The Office",
+		Summary:       "A mockumentary.",
+		ContentRating: "TV-14",
+		PlexID:        "plex://show/office",
+		RatingKey:     10,
+		Key:           "/library/metadata/10/children",
+		Type:          showType,
+		SeasonCount:   2,
+		EpisodeCount:  3,
+		ShowStatus:    showStatusInProgress,
+		SectionID:     "2",
+		Thumb:         "/library/metadata/10/thumb/1700000000",
+		Art:           "/library/metadata/10/art/1700000000",
+	}}
+	if !reflect.DeepEqual(recent, expected) {
+		t.Errorf("expected %+v, got %+v", expected, recent)
+	}
+}
+
+func TestIntegrationWatchHistory(t *testing.T) {
+	server := newTestServer(t)
+	c := New(server.Token(), server.URL, "1")
+	since := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		section  string
+		opts     []HistoryOption
+		expected []string
+	}{
+		{name: "Kid's Shows", section: "2", opts: []HistoryOption{WithAccountID(2)}, expected: []string{"The Office"}},
+		{name: "Owner's Shows", section: "2", opts: []HistoryOption{WithAccountID(1)}, expected: []string{}},
+		{name: "All Movies", section: "1", expected: []string{"Movie A"}},
+		{name: "Movies In Window", section: "1", opts: []HistoryOption{WithViewedSince(since)}, expected: []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			history, err := GetWatchHistory(context.Background(), c, tc.section, 5, tc.opts...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			titles := make([]string, 0, len(history))
+			for _, video := range history {
+				titles = append(titles, video.Title)
+			}
+			if !reflect.DeepEqual(titles, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, titles)
+			}
+		})
+	}
+}
+
+func TestIntegrationSetPlaylistIsIdempotent(t *testing.T) {
+	server := newTestServer(t)
+	c := New(server.Token(), server.URL, "1")
+
+	for _, ratingKeys := range [][]int{{20, 21}, {22, 10}} {
+		if _, err := SetPlaylist(context.Background(), c, "Recommended for Kid", ratingKeys); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	playlists := server.Playlists()
+	if len(playlists) != 1 {
+		t.Fatalf("expected 1 playlist, got %+v", playlists)
+	}
+	// the show is added as its episodes
+	expected := []int{22, 11, 12, 13}
+	if !slices.Equal(playlists[0].Items, expected) {
+		t.Errorf("expected items %v, got %v", expected, playlists[0].Items)
+	}
+}
+
+func TestIntegrationRejectsBadToken(t *testing.T) {
+	server := newTestServer(t)
+	_, err := GetLibrarySections(context.Background(), New("wrongToken", server.URL, "1"))
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestIntegrationGetImage(t *testing.T) {
+	server := newTestServer(t)
+	c := New(server.Token(), server.URL, "1")
+
+	testCases := []struct {
+		name     string
+		kind     string
+		opts     []ImageOption
+		expected string
+	}{
+		{name: "Original", kind: ImageThumb, expected: "thumb of 20"},
+		{name: "Resized", kind: ImageArt, opts: []ImageOption{WithImageSize(300, 450)}, expected: "art of 20 at 300x450"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := GetImage(context.Background(), c, 20, tc.kind, tc.opts...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(body) != tc.expected || resp.Header.Get("Content-Type") != "image/jpeg" {
+				t.Errorf("expected %q, got %q (%s)", tc.expected, body, resp.Header.Get("Content-Type"))
+			}
+		})
+	}
+
+	if _, err := GetImage(context.Background(), c, 404, ImageThumb); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := GetImage(context.Background(), c, 20, "banner"); err == nil {
+		t.Error("expected an error for an unknown image kind")
+	}
+}
+
+func TestIntegrationMusicLibrary(t *testing.T) {
+	server := newTestServer(t)
+	c := New(server.Token(), server.URL, "1")
+
+	artists, err := GetAllArtists(context.Background(), c, "3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedArtists := []ArtistShort{
+		{Name: "Radiohead", PlexID: "plex://artist/radiohead", RatingKey: 30, Key: "/library/metadata/30/children", SectionID: "3", AlbumCount: 2,
+			Thumb: "/library/metadata/30/thumb/1700000000", Art: "/library/metadata/30/art/1700000000"},
+		{Name: "Miles Davis", PlexID: "plex://artist/miles-davis", RatingKey: 40, Key: "/library/metadata/40/children", SectionID: "3", AlbumCount: 1,
+			Thumb: "/library/metadata/40/thumb/1700000000", Art: "/library/metadata/40/art/1700000000"},
+	}
+	if !reflect.DeepEqual(artists, expectedArtists) {
+		t.Errorf("expected %+v, got %+v", expectedArtists, artists)
+	}
+
+	albums, err := GetAllAlbums(context.Background(), c, "3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var titles []string
+	for _, album := range albums {
+		if album.SectionID != "3" {
+			t.Errorf("expected section 3, got %q", album.SectionID)
+		}
+		titles = append(titles, album.Artist+" - "+album.Title)
+	}
+	expectedTitles := []string{"Radiohead - OK Computer", "Radiohead - In Rainbows", "Miles Davis - Kind of Blue"}
+	if !reflect.DeepEqual(titles, expectedTitles) {
+		t.Errorf("expected %v, got %v", expectedTitles, titles)
+	}
+	if !reflect.DeepEqual(albums[0].Moods, []string{"Brooding"}) || albums[0].ArtistRatingKey != 30 || albums[0].TrackCount != 2 {
+		t.Errorf("unexpected album %+v", albums[0])
+	}
+}
+
+func TestIntegrationRecentlyPlayedAlbums(t *testing.T) {
+	server := newTestServer(t)
+	c := New(server.Token(), server.URL, "1")
+
+	recent, err := GetRecentlyPlayedAlbums(context.Background(), c, "3", 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := AlbumShort{
+		Title:           "Kind of Blue",
+		Artist:          "Miles Davis",
+		PlexID:          "plex://album/kind-of-blue",
+		RatingKey:       41,
+		Key:             "/library/metadata/41/children",
+		ArtistRatingKey: 40,
+		ArtistPlexID:    "plex://artist/miles-davis",
+		SectionID:       "3",
+		Year:            1959,
+		TrackCount:      1,
+		Genres:          []string{"Jazz"},
+		Moods:           []string{"Cool"},
+		Thumb:           "/library/metadata/41/thumb/1700000000",
+		Art:             "/library/metadata/40/art/1700000000",
+	}
+	// both tracks from OK Computer count as one album
+	if len(recent) != 2 || !reflect.DeepEqual(recent[0], expected) || recent[1].Title != "OK Computer" {
+		t.Errorf("expected %+v then OK Computer, got %+v", expected, recent)
+	}
+
+	history, err := GetListeningHistory(context.Background(), c, "3", 5, WithAccountID(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(history) != 1 || history[0].Title != "OK Computer" || history[0].Year != 1997 {
+		t.Errorf("expected the owner to have listened to OK Computer, got %+v", history)
+	}
+}
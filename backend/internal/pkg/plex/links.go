@@ -0,0 +1,49 @@
+package plex
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// plexWebURL is where Plex Web is hosted.
+const plexWebURL = "https://app.plex.tv/desktop"
+
+// detailsKey is the metadata key Plex's apps use to open a
+// video's details page. The key Plex lists a show with points
+// at its seasons, so the rating key is preferred.
+func detailsKey(v VideoShort) string {
+	if v.RatingKey != 0 {
+		return "/library/metadata/" + strconv.Itoa(v.RatingKey)
+	}
+	return v.Key
+}
+
+// WebURL returns the link that opens the video in Plex Web.
+func WebURL(machineIdentifier string, v VideoShort) string {
+	return fmt.Sprintf("%s#!/server/%s/details?key=%s", plexWebURL, machineIdentifier, url.QueryEscape(detailsKey(v)))
+}
+
+// AppURL returns the link that opens the video in the
+// Plex apps on phones, tablets and TVs.
+func AppURL(machineIdentifier string, v VideoShort) string {
+	return fmt.Sprintf("plex://preplay/?metadataKey=%s&server=%s", url.QueryEscape(detailsKey(v)), machineIdentifier)
+}
+
+// AddLinks fills in the links that open each video in Plex.
+// Videos that Plex doesn't know the key of are left alone.
+func AddLinks(ctx context.Context, c Client, videos []*VideoShort) error {
+	machineIdentifier, err := GetMachineIdentifier(ctx, c)
+	if err != nil {
+		return err
+	}
+	for _, video := range videos {
+		if detailsKey(*video) == "" {
+			continue
+		}
+		video.WebURL = WebURL(machineIdentifier, *video)
+		video.AppURL = AppURL(machineIdentifier, *video)
+	}
+	return nil
+}
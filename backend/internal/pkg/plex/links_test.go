@@ -0,0 +1,68 @@
+package plex
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestLinks(t *testing.T) {
+	testCases := []struct {
+		name        string
+		video       VideoShort
+		expectedWeb string
+		expectedApp string
+	}{
+		{
+			name:        "Movie",
+			video:       VideoShort{RatingKey: 20, Key: "/library/metadata/20"},
+			expectedWeb: "https://app.plex.tv/desktop#!/server/abc123/details?key=%2Flibrary%2Fmetadata%2F20",
+			expectedApp: "plex://preplay/?metadataKey=%2Flibrary%2Fmetadata%2F20&server=abc123",
+		},
+		{
+			name:        "Show Listed By Its Children",
+			video:       VideoShort{RatingKey: 10, Key: "/library/metadata/10/children", Type: showType},
+			expectedWeb: "https://app.plex.tv/desktop#!/server/abc123/details?key=%2Flibrary%2Fmetadata%2F10",
+			expectedApp: "plex://preplay/?metadataKey=%2Flibrary%2Fmetadata%2F10&server=abc123",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if web := WebURL("abc123", tc.video); web != tc.expectedWeb {
+				t.Errorf("expected %q, got %q", tc.expectedWeb, web)
+			}
+			if app := AppURL("abc123", tc.video); app != tc.expectedApp {
+				t.Errorf("expected %q, got %q", tc.expectedApp, app)
+			}
+		})
+	}
+}
+
+func TestAddLinksCachesMachineIdentifier(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/identity",
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="0" machineIdentifier="abc123"/>`))
+
+	c := New("randomToken", "localhost", "1")
+	videos := []*VideoShort{{Title: "Movie A", RatingKey: 20}, {Title: "Unknown"}}
+	for i := 0; i < 2; i++ {
+		if err := AddLinks(context.Background(), c, videos); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if videos[0].WebURL == "" || videos[0].AppURL == "" {
+		t.Errorf("expected links on %+v", *videos[0])
+	}
+	if videos[1].WebURL != "" || videos[1].AppURL != "" {
+		t.Errorf("expected no links on %+v", *videos[1])
+	}
+	if calls := httpmock.GetTotalCallCount(); calls != 1 {
+		t.Errorf("expected the identity to be requested once, got %d", calls)
+	}
+}
@@ -0,0 +1,521 @@
+package plex
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Plex metadata types in a music library. Artists and albums
+// are directories, and tracks are their own element.
+const (
+	artistType = "artist"
+	albumType  = "album"
+	trackType  = "track"
+)
+
+// Plex's search types, which pick what a music section's
+// listing is made of. Artists are listed by default.
+const (
+	artistSearchType = "8"
+	albumSearchType  = "9"
+)
+
+// Track is a song in a Plex music library. The parent is the
+// album and the grandparent is the artist the track belongs to.
+type Track struct {
+	XMLName   xml.Name `xml:"Track"`
+	RatingKey int      `xml:"ratingKey,attr"`
+	Key       string   `xml:"key,attr"`
+	Guid      string   `xml:"guid,attr"`
+	Type      string   `xml:"type,attr"`
+	Title     string   `xml:"title,attr"`
+	// Duration is the length of the track in milliseconds
+	Duration             int     `xml:"duration,attr"`
+	ViewCount            int     `xml:"viewCount,attr"`
+	LastViewedAt         int64   `xml:"lastViewedAt,attr"`
+	UserRating           float64 `xml:"userRating,attr"`
+	ParentRatingKey      int     `xml:"parentRatingKey,attr"`
+	ParentKey            string  `xml:"parentKey,attr"`
+	ParentGuid           string  `xml:"parentGuid,attr"`
+	ParentTitle          string  `xml:"parentTitle,attr"`
+	ParentThumb          string  `xml:"parentThumb,attr"`
+	ParentYear           int     `xml:"parentYear,attr"`
+	GrandparentRatingKey int     `xml:"grandparentRatingKey,attr"`
+	GrandparentKey       string  `xml:"grandparentKey,attr"`
+	GrandparentTitle     string  `xml:"grandparentTitle,attr"`
+}
+
+// AlbumShort is an album in a music library, which is what
+// music is stored and recommended as.
+type AlbumShort struct {
+	Title     string `json:"title"`
+	Artist    string `json:"artist"`
+	Summary   string `json:"summary"`
+	PlexID    string `json:"plex_id"`
+	RatingKey int    `json:"rating_key,omitempty"`
+	Key       string `json:"key,omitempty"`
+	// ArtistRatingKey and ArtistPlexID identify
+	// the artist the album belongs to.
+	ArtistRatingKey       int      `json:"artist_rating_key,omitempty"`
+	ArtistPlexID          string   `json:"artist_plex_id,omitempty"`
+	SectionID             string   `json:"section_id,omitempty"`
+	Year                  int      `json:"year,omitempty"`
+	OriginallyAvailableAt string   `json:"originally_available_at,omitempty"`
+	TrackCount            int      `json:"track_count,omitempty"`
+	Genres                []string `json:"genres,omitempty"`
+	Styles                []string `json:"styles,omitempty"`
+	Moods                 []string `json:"moods,omitempty"`
+	// ViewCount is how many times the album's tracks have
+	// been played by the account connected to Plex.
+	ViewCount int `json:"view_count,omitempty"`
+	// LastViewedAt is a Unix timestamp
+	LastViewedAt int64 `json:"last_viewed_at,omitempty"`
+	// UserRating is the listener's own rating out of 10.
+	UserRating float64 `json:"user_rating,omitempty"`
+	// Thumb and Art are paths on the Plex server. They're
+	// served without the token by GET /images/{ratingKey}.
+	Thumb string `json:"thumb,omitempty"`
+	Art   string `json:"art,omitempty"`
+	// Server is the name of the configured Plex
+	// server the album is on.
+	Server string `json:"server,omitempty"`
+	// Distance and Score are how far from and how similar to
+	// the listening history the album was when it was found by
+	// a vector search, the same as for videos. They're nil
+	// otherwise.
+	Distance *float64 `json:"distance,omitempty"`
+	Score    *float64 `json:"score,omitempty"`
+}
+
+// Played reports whether any of the album has been played
+// by the account connected to Plex.
+func (a AlbumShort) Played() bool {
+	return a.ViewCount > 0
+}
+
+func (a AlbumShort) String() string {
+	s := "Album: " + a.Title +
+		"\nArtist: " + a.Artist +
+		"\nSummary: " + a.Summary +
+		"\nPlex ID: " + a.PlexID
+	if a.Year != 0 {
+		s += "\nYear: " + strconv.Itoa(a.Year)
+	}
+	if a.OriginallyAvailableAt != "" {
+		s += "\nReleased: " + a.OriginallyAvailableAt
+	}
+	if a.TrackCount != 0 {
+		s += "\nTracks: " + strconv.Itoa(a.TrackCount)
+	}
+	if len(a.Genres) > 0 {
+		s += "\nGenres: " + strings.Join(a.Genres, ", ")
+	}
+	if len(a.Styles) > 0 {
+		s += "\nStyles: " + strings.Join(a.Styles, ", ")
+	}
+	if len(a.Moods) > 0 {
+		s += "\nMoods: " + strings.Join(a.Moods, ", ")
+	}
+	return s
+}
+
+// ArtistShort is an artist in a music library.
+type ArtistShort struct {
+	Name       string   `json:"name"`
+	Summary    string   `json:"summary"`
+	PlexID     string   `json:"plex_id"`
+	RatingKey  int      `json:"rating_key,omitempty"`
+	Key        string   `json:"key,omitempty"`
+	SectionID  string   `json:"section_id,omitempty"`
+	AlbumCount int      `json:"album_count,omitempty"`
+	Genres     []string `json:"genres,omitempty"`
+	Styles     []string `json:"styles,omitempty"`
+	Moods      []string `json:"moods,omitempty"`
+	Countries  []string `json:"countries,omitempty"`
+	ViewCount  int      `json:"view_count,omitempty"`
+	// LastViewedAt is a Unix timestamp
+	LastViewedAt int64  `json:"last_viewed_at,omitempty"`
+	Thumb        string `json:"thumb,omitempty"`
+	Art          string `json:"art,omitempty"`
+	// Server is the name of the configured Plex
+	// server the artist is on.
+	Server string `json:"server,omitempty"`
+}
+
+func (a ArtistShort) String() string {
+	s := "Artist: " + a.Name +
+		"\nPlex ID: " + a.PlexID
+	if a.AlbumCount != 0 {
+		s += "\nAlbums: " + strconv.Itoa(a.AlbumCount)
+	}
+	if len(a.Genres) > 0 {
+		s += "\nGenres: " + strings.Join(a.Genres, ", ")
+	}
+	if len(a.Styles) > 0 {
+		s += "\nStyles: " + strings.Join(a.Styles, ", ")
+	}
+	if len(a.Moods) > 0 {
+		s += "\nMoods: " + strings.Join(a.Moods, ", ")
+	}
+	if len(a.Countries) > 0 {
+		s += "\nCountries: " + strings.Join(a.Countries, ", ")
+	}
+	return s
+}
+
+// HasPlayed reports whether the album has been played, either
+// going by Plex's play count for it or because it's in history.
+// Play counts are the server owner's, so for anyone else use
+// AlbumInHistory with their own history instead.
+func HasPlayed(a AlbumShort, history []AlbumShort) bool {
+	return a.Played() || AlbumInHistory(a, history)
+}
+
+// AlbumInHistory reports whether the album is in history. Rating
+// keys are only unique to a server, so they're only compared for
+// albums on the same server.
+func AlbumInHistory(a AlbumShort, history []AlbumShort) bool {
+	return slices.ContainsFunc(history, func(h AlbumShort) bool {
+		return (a.RatingKey != 0 && h.RatingKey == a.RatingKey && h.Server == a.Server) ||
+			(a.PlexID != "" && h.PlexID == a.PlexID)
+	})
+}
+
+// ArtistInHistory reports whether any of the artist's albums are
+// in history. Artists are matched by rating key on the same server,
+// or else by name, since history doesn't carry the artist's Plex ID.
+func ArtistInHistory(a ArtistShort, history []AlbumShort) bool {
+	return slices.ContainsFunc(history, func(h AlbumShort) bool {
+		if a.RatingKey != 0 && h.ArtistRatingKey != 0 {
+			return h.ArtistRatingKey == a.RatingKey && h.Server == a.Server
+		}
+		return a.Name != "" && strings.EqualFold(h.Artist, a.Name)
+	})
+}
+
+// Unplayed returns the albums that haven't been played.
+func Unplayed(albums, history []AlbumShort) []AlbumShort {
+	unplayed := make([]AlbumShort, 0, len(albums))
+	for _, album := range albums {
+		if HasPlayed(album, history) {
+			continue
+		}
+		unplayed = append(unplayed, album)
+	}
+	return unplayed
+}
+
+func (d Directory) toAlbumShort() AlbumShort {
+	return AlbumShort{
+		Title:                 d.Title,
+		Artist:                d.ParentTitle,
+		Summary:               d.Summary,
+		PlexID:                d.Guid,
+		RatingKey:             d.RatingKey,
+		Key:                   d.Key,
+		ArtistRatingKey:       d.ParentRatingKey,
+		ArtistPlexID:          d.ParentGuid,
+		Year:                  d.Year,
+		OriginallyAvailableAt: d.OriginallyAvailableAt,
+		TrackCount:            d.LeafCount,
+		Genres:                tagNames(d.Genres),
+		Styles:                tagNames(d.Styles),
+		Moods:                 tagNames(d.Moods),
+		ViewCount:             d.ViewCount,
+		LastViewedAt:          d.LastViewedAt,
+		UserRating:            d.UserRating,
+		Thumb:                 d.Thumb,
+		Art:                   d.Art,
+	}
+}
+
+func (d Directory) toArtistShort() ArtistShort {
+	return ArtistShort{
+		Name:         d.Title,
+		Summary:      d.Summary,
+		PlexID:       d.Guid,
+		RatingKey:    d.RatingKey,
+		Key:          d.Key,
+		AlbumCount:   d.ChildCount,
+		Genres:       tagNames(d.Genres),
+		Styles:       tagNames(d.Styles),
+		Moods:        tagNames(d.Moods),
+		Countries:    tagNames(d.Countries),
+		ViewCount:    d.ViewCount,
+		LastViewedAt: d.LastViewedAt,
+		Thumb:        d.Thumb,
+		Art:          d.Art,
+	}
+}
+
+// albumsToShort converts the albums in a list of Plex directories
+// to their short form, skipping artists and any other containers.
+func albumsToShort(dirs []Directory) []AlbumShort {
+	shorts := make([]AlbumShort, 0, len(dirs))
+	for _, dir := range dirs {
+		if dir.Type != albumType {
+			continue
+		}
+		shorts = append(shorts, dir.toAlbumShort())
+	}
+	return shorts
+}
+
+// artistsToShort converts the artists in a list of Plex
+// directories to their short form.
+func artistsToShort(dirs []Directory) []ArtistShort {
+	shorts := make([]ArtistShort, 0, len(dirs))
+	for _, dir := range dirs {
+		if dir.Type != artistType {
+			continue
+		}
+		shorts = append(shorts, dir.toArtistShort())
+	}
+	return shorts
+}
+
+// tracksToAlbums rolls up to limit played tracks into the albums
+// they belong to, so listening to a whole album counts as a single
+// item against the limit.
+func tracksToAlbums(tracks []Track, limit int) []AlbumShort {
+	shorts := make([]AlbumShort, 0, limit)
+	seen := make(map[int]bool)
+	for _, track := range tracks {
+		if len(shorts) >= limit {
+			break
+		}
+		albumKey := track.ParentRatingKey
+		if albumKey == 0 {
+			// listening history only provides the album's key
+			albumKey = ratingKeyFromKey(track.ParentKey)
+		}
+		if albumKey == 0 || seen[albumKey] {
+			continue
+		}
+		seen[albumKey] = true
+		shorts = append(shorts, AlbumShort{
+			Title:           track.ParentTitle,
+			Artist:          track.GrandparentTitle,
+			PlexID:          track.ParentGuid,
+			RatingKey:       albumKey,
+			Key:             track.ParentKey,
+			ArtistRatingKey: track.GrandparentRatingKey,
+			Year:            track.ParentYear,
+			LastViewedAt:    track.LastViewedAt,
+			Thumb:           track.ParentThumb,
+		})
+	}
+	return shorts
+}
+
+// setAlbumSectionID records the library section the
+// albums were retrieved from.
+func setAlbumSectionID(shorts []AlbumShort, sectionId string) {
+	for i := range shorts {
+		shorts[i].SectionID = sectionId
+	}
+}
+
+// GetAlbum retrieves the album with the provided rating key.
+func GetAlbum(ctx context.Context, c Client, ratingKey int) (*AlbumShort, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetAlbum"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.Int("ratingKey", ratingKey))
+	var container MediaContainer
+	if err := getXML(ctx, c, c.Connect(WithPath("/library/metadata/"+strconv.Itoa(ratingKey))), &container); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	shorts := albumsToShort(container.Directories)
+	if len(shorts) == 0 {
+		err := fmt.Errorf("no album found for rating key %d", ratingKey)
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetStatus(codes.Ok, "album retrieved")
+	return &shorts[0], nil
+}
+
+// hydrateAlbums replaces albums rolled up from the tracks that
+// were played with their full metadata from Plex. Albums that
+// can't be retrieved keep what we already know about them.
+func hydrateAlbums(ctx context.Context, c Client, shorts []AlbumShort) {
+	for i, short := range shorts {
+		full, err := GetAlbum(ctx, c, short.RatingKey)
+		if err != nil {
+			log.Printf("could not get album %q: %v\n", short.Title, err)
+			continue
+		}
+		shorts[i] = *full
+	}
+}
+
+// GetRecentlyPlayedAlbums returns up to limit of the albums most
+// recently played in the music section.
+func GetRecentlyPlayedAlbums(ctx context.Context, c Client, sectionId string, limit int) ([]AlbumShort, error) {
+	if sectionId == "" {
+		sectionId = c.GetDefaultLibrarySection()
+	}
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetRecentlyPlayedAlbums"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.String("section", sectionId))
+
+	log.Println("getting recently played albums...")
+	var container MediaContainer
+	if err := getXML(ctx, c, c.Connect(WithSectionID(sectionId)), &container); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	// Plex lists the tracks that were played, but
+	// whole albums are kept in case it lists those
+	shorts := tracksToAlbums(container.Tracks, limit)
+	for _, album := range albumsToShort(container.Directories) {
+		if len(shorts) >= limit {
+			break
+		}
+		if !slices.ContainsFunc(shorts, func(a AlbumShort) bool { return a.RatingKey == album.RatingKey }) {
+			shorts = append(shorts, album)
+		}
+	}
+	hydrateAlbums(ctx, c, shorts)
+	setAlbumSectionID(shorts, sectionId)
+	span.SetAttributes(attribute.Int("count", len(shorts)))
+	span.SetStatus(codes.Ok, "recently played albums complete")
+	return shorts, nil
+}
+
+// GetListeningHistory returns up to limit of the most recently
+// played albums in the music section from the server's history.
+// It takes the same options as GetWatchHistory.
+func GetListeningHistory(ctx context.Context, c Client, sectionId string, limit int, opts ...HistoryOption) ([]AlbumShort, error) {
+	if sectionId == "" {
+		sectionId = c.GetDefaultLibrarySection()
+	}
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetListeningHistory"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+
+	log.Println("getting listening history...")
+	var history []Track
+	err := readHistory(ctx, c, sectionId, opts, func(page MediaContainer) bool {
+		history = append(history, page.Tracks...)
+		return len(tracksToAlbums(history, limit)) >= limit
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("total count", len(history)))
+
+	shorts := tracksToAlbums(history, limit)
+	hydrateAlbums(ctx, c, shorts)
+	setAlbumSectionID(shorts, sectionId)
+	span.SetStatus(codes.Ok, "listening history complete")
+	return shorts, nil
+}
+
+// GetListened returns every album in the section's listening
+// history, most recently played first, without filling in the rest
+// of their metadata. Plex's own play counts belong to the account
+// that owns the server, so with WithAccountID this is how to tell
+// what anyone else has listened to.
+func GetListened(ctx context.Context, c Client, sectionId string, opts ...HistoryOption) ([]AlbumShort, error) {
+	if sectionId == "" {
+		sectionId = c.GetDefaultLibrarySection()
+	}
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetListened"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+
+	var history []Track
+	err := readHistory(ctx, c, sectionId, opts, func(page MediaContainer) bool {
+		history = append(history, page.Tracks...)
+		return false
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	shorts := tracksToAlbums(history, len(history))
+	setAlbumSectionID(shorts, sectionId)
+	span.SetAttributes(attribute.Int("listened", len(shorts)))
+	span.SetStatus(codes.Ok, "listened complete")
+	return shorts, nil
+}
+
+// getSectionDirectories pages through every directory of the
+// search type in the section, such as its artists or albums.
+func getSectionDirectories(ctx context.Context, c Client, sectionId, searchType string) ([]Directory, error) {
+	var dirs []Directory
+	for start := 0; ; start += DefaultPageSize {
+		var container MediaContainer
+		uri := c.Connect(
+			WithSectionID(sectionId),
+			WithAllMovies(allMovies),
+			WithQuery("type", searchType),
+			WithQuery("X-Plex-Container-Start", strconv.Itoa(start)),
+			WithQuery("X-Plex-Container-Size", strconv.Itoa(DefaultPageSize)),
+		)
+		if err := getXML(ctx, c, uri, &container); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, container.Directories...)
+		// older servers don't report totalSize, so a short
+		// page is the only sign we've reached the end
+		if len(container.Directories) < DefaultPageSize || (container.TotalSize > 0 && len(dirs) >= container.TotalSize) {
+			return dirs, nil
+		}
+	}
+}
+
+// GetAllAlbums retrieves every album in the music section.
+func GetAllAlbums(ctx context.Context, c Client, sectionId string) ([]AlbumShort, error) {
+	if sectionId == "" {
+		sectionId = c.GetDefaultLibrarySection()
+	}
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetAllAlbums"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.String("section", sectionId))
+	log.Println("getting all albums...")
+	dirs, err := getSectionDirectories(ctx, c, sectionId, albumSearchType)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	shorts := albumsToShort(dirs)
+	setAlbumSectionID(shorts, sectionId)
+	log.Printf("total count: %v\n", len(shorts))
+	span.SetAttributes(attribute.Int("count", len(shorts)))
+	span.SetStatus(codes.Ok, "all albums complete")
+	return shorts, nil
+}
+
+// GetAllArtists retrieves every artist in the music section.
+func GetAllArtists(ctx context.Context, c Client, sectionId string) ([]ArtistShort, error) {
+	if sectionId == "" {
+		sectionId = c.GetDefaultLibrarySection()
+	}
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetAllArtists"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.String("section", sectionId))
+	log.Println("getting all artists...")
+	dirs, err := getSectionDirectories(ctx, c, sectionId, artistSearchType)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	shorts := artistsToShort(dirs)
+	for i := range shorts {
+		shorts[i].SectionID = sectionId
+	}
+	span.SetAttributes(attribute.Int("count", len(shorts)))
+	span.SetStatus(codes.Ok, "all artists complete")
+	return shorts, nil
+}
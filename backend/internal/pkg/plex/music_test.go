@@ -0,0 +1,123 @@
+package plex
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMediaContainerParsesMusic(t *testing.T) {
+	body := `<MediaContainer size="3" librarySectionID="3" librarySectionTitle="Music">
+	<Directory ratingKey="30" key="/library/metadata/30/children" guid="plex://artist/radiohead" type="artist" title="Radiohead" childCount="9"><Genre tag="Alternative"/><Country tag="United Kingdom"/></Directory>
+	<Directory ratingKey="31" key="/library/metadata/31/children" parentRatingKey="30" guid="plex://album/ok-computer" parentGuid="plex://artist/radiohead" type="album" title="OK Computer" parentKey="/library/metadata/30" parentTitle="Radiohead" year="1997" leafCount="12" viewCount="24" userRating="10">
+		<Genre tag="Alternative"/><Style tag="Art Rock"/><Mood tag="Brooding"/><Mood tag="Paranoid"/>
+	</Directory>
+	<Track ratingKey="32" key="/library/metadata/32" parentRatingKey="31" grandparentRatingKey="30" type="track" title="Airbag" parentTitle="OK Computer" grandparentTitle="Radiohead" parentYear="1997" viewCount="3"/>
+</MediaContainer>`
+
+	var container MediaContainer
+	if err := xml.Unmarshal([]byte(body), &container); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	artists := artistsToShort(container.Directories)
+	if len(artists) != 1 || artists[0].Name != "Radiohead" || artists[0].AlbumCount != 9 ||
+		!reflect.DeepEqual(artists[0].Countries, []string{"United Kingdom"}) {
+		t.Errorf("unexpected artists %+v", artists)
+	}
+
+	albums := albumsToShort(container.Directories)
+	expected := AlbumShort{
+		Title:           "OK Computer",
+		Artist:          "Radiohead",
+		PlexID:          "plex://album/ok-computer",
+		RatingKey:       31,
+		Key:             "/library/metadata/31/children",
+		ArtistRatingKey: 30,
+		ArtistPlexID:    "plex://artist/radiohead",
+		Year:            1997,
+		TrackCount:      12,
+		Genres:          []string{"Alternative"},
+		Styles:          []string{"Art Rock"},
+		Moods:           []string{"Brooding", "Paranoid"},
+		ViewCount:       24,
+		UserRating:      10,
+	}
+	if len(albums) != 1 || !reflect.DeepEqual(albums[0], expected) {
+		t.Fatalf("expected %+v, got %+v", expected, albums)
+	}
+	text := albums[0].String()
+	for _, want := range []string{"Album: OK Computer", "Artist: Radiohead", "Styles: Art Rock", "Moods: Brooding, Paranoid"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected embedding text to contain %q, got %q", want, text)
+		}
+	}
+
+	rolledUp := tracksToAlbums(container.Tracks, 5)
+	if len(rolledUp) != 1 || rolledUp[0].Title != "OK Computer" || rolledUp[0].Artist != "Radiohead" || rolledUp[0].RatingKey != 31 {
+		t.Errorf("expected the track to roll up to its album, got %+v", rolledUp)
+	}
+}
+
+func TestTracksToAlbums(t *testing.T) {
+	tracks := []Track{
+		{Title: "Airbag", ParentRatingKey: 31, ParentTitle: "OK Computer"},
+		{Title: "Paranoid Android", ParentRatingKey: 31, ParentTitle: "OK Computer"},
+		// history entries only have the album's key
+		{Title: "So What", ParentKey: "/library/metadata/41", ParentTitle: "Kind of Blue"},
+		{Title: "15 Step", ParentRatingKey: 34, ParentTitle: "In Rainbows"},
+	}
+	albums := tracksToAlbums(tracks, 2)
+	titles := make([]string, 0, len(albums))
+	for _, album := range albums {
+		titles = append(titles, album.Title)
+	}
+	expected := []string{"OK Computer", "Kind of Blue"}
+	if !reflect.DeepEqual(titles, expected) || albums[1].RatingKey != 41 {
+		t.Errorf("expected %v, got %+v", expected, albums)
+	}
+}
+
+func TestUnplayed(t *testing.T) {
+	albums := []AlbumShort{
+		{Title: "Played", RatingKey: 1, ViewCount: 4},
+		{Title: "New", RatingKey: 2},
+		{Title: "In History", RatingKey: 3},
+		{Title: "Same Key On Cabin", RatingKey: 3, Server: "cabin"},
+	}
+	history := []AlbumShort{{Title: "In History", RatingKey: 3}}
+
+	var titles []string
+	for _, album := range Unplayed(albums, history) {
+		titles = append(titles, album.Title)
+	}
+	expected := []string{"New", "Same Key On Cabin"}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("expected %v, got %v", expected, titles)
+	}
+}
+
+func TestArtistInHistory(t *testing.T) {
+	history := []AlbumShort{
+		{Title: "OK Computer", Artist: "Radiohead", ArtistRatingKey: 30},
+		{Title: "Kind of Blue", Artist: "Miles Davis"},
+	}
+	testCases := []struct {
+		name     string
+		artist   ArtistShort
+		expected bool
+	}{
+		{name: "Same Rating Key", artist: ArtistShort{Name: "Radiohead", RatingKey: 30}, expected: true},
+		{name: "Same Key On Cabin", artist: ArtistShort{Name: "Radiohead", RatingKey: 30, Server: "cabin"}, expected: false},
+		{name: "Same Name", artist: ArtistShort{Name: "miles davis", RatingKey: 40}, expected: true},
+		{name: "Not Listened To", artist: ArtistShort{Name: "Björk", RatingKey: 50}, expected: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := ArtistInHistory(tc.artist, history); result != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package plex
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// DefaultPageSize is how many items are requested from a
+// library section at a time when no page size is provided.
+const DefaultPageSize = 250
+
+// VideoPager pages through every movie or show in a library
+// section. Each page is decoded as it streams in, so large
+// libraries are never held in memory as a single document.
+//
+//	pager := plex.NewVideoPager(c, sectionId, plex.DefaultPageSize)
+//	for pager.Next(ctx) {
+//		videos := pager.Page()
+//	}
+//	if err := pager.Err(); err != nil {
+//		...
+//	}
+type VideoPager struct {
+	c         Client
+	sectionId string
+	pageSize  int
+	start     int
+	// total is the size of the section reported by
+	// Plex, or -1 if it hasn't been reported.
+	total int
+	page  []VideoShort
+	err   error
+	done  bool
+}
+
+// NewVideoPager creates a pager over the provided library
+// section. The default section is used if sectionId is empty.
+func NewVideoPager(c Client, sectionId string, pageSize int) *VideoPager {
+	if sectionId == "" {
+		sectionId = c.GetDefaultLibrarySection()
+	}
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	return &VideoPager{
+		c:         c,
+		sectionId: sectionId,
+		pageSize:  pageSize,
+		total:     -1,
+	}
+}
+
+// Next retrieves the next page of videos. It returns false once
+// every page has been read or when a request fails, in which case
+// Err reports what went wrong.
+func (p *VideoPager) Next(ctx context.Context) bool {
+	if p.done || p.err != nil {
+		return false
+	}
+	if p.total >= 0 && p.start >= p.total {
+		p.done = true
+		return false
+	}
+
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("VideoPager Next"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.String("section", p.sectionId))
+	span.SetAttributes(attribute.Int("start", p.start))
+	span.SetAttributes(attribute.Int("size", p.pageSize))
+
+	uri := p.c.Connect(
+		WithSectionID(p.sectionId),
+		WithAllMovies(allMovies),
+		WithQuery("X-Plex-Container-Start", strconv.Itoa(p.start)),
+		WithQuery("X-Plex-Container-Size", strconv.Itoa(p.pageSize)),
+	)
+	resp, err := p.c.MakeNetworkRequest(ctx, uri, http.MethodGet)
+	if err != nil {
+		span.RecordError(err)
+		p.err = err
+		return false
+	}
+	defer resp.Body.Close()
+
+	page, total, err := decodePage(resp.Body)
+	if err != nil {
+		span.RecordError(err)
+		p.err = err
+		return false
+	}
+	setSectionID(page, p.sectionId)
+
+	p.page = page
+	p.total = total
+	p.start += p.pageSize
+	// older servers don't report totalSize, so a short
+	// page is the only sign we've reached the end
+	if len(page) == 0 || (total < 0 && len(page) < p.pageSize) {
+		p.done = true
+	}
+	log.Printf("retrieved %v videos from section %v\n", len(page), p.sectionId)
+	span.SetStatus(codes.Ok, "page retrieved")
+	return len(page) > 0
+}
+
+// Page returns the videos retrieved by the last call to Next.
+func (p *VideoPager) Page() []VideoShort {
+	return p.page
+}
+
+// Total returns the number of items in the section, or -1
+// if Plex hasn't reported it yet.
+func (p *VideoPager) Total() int {
+	return p.total
+}
+
+// Err returns the error that stopped the pager, if any.
+func (p *VideoPager) Err() error {
+	return p.err
+}
+
+// decodePage streams a MediaContainer from r, converting each
+// movie and show to its short form as it's decoded. The
+// container's totalSize is returned, or -1 if it's missing.
+func decodePage(r io.Reader) ([]VideoShort, int, error) {
+	decoder := xml.NewDecoder(r)
+	shorts := make([]VideoShort, 0)
+	total := -1
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return shorts, total, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "MediaContainer":
+			for _, attr := range start.Attr {
+				if attr.Name.Local != "totalSize" {
+					continue
+				}
+				if total, err = strconv.Atoi(attr.Value); err != nil {
+					return nil, 0, err
+				}
+			}
+		case "Video":
+			var vid Video
+			if err := decoder.DecodeElement(&vid, &start); err != nil {
+				return nil, 0, err
+			}
+			shorts = append(shorts, fullToShort([]Video{vid}, 1)...)
+		case "Directory":
+			var dir Directory
+			if err := decoder.DecodeElement(&dir, &start); err != nil {
+				return nil, 0, err
+			}
+			shorts = append(shorts, showsToShort([]Directory{dir})...)
+		}
+	}
+}
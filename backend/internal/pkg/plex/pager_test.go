@@ -0,0 +1,104 @@
+package plex
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestVideoPager(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	pages := map[string]string{
+		"0": `<Video ratingKey="1" type="movie" title="Movie 1"/><Video ratingKey="2" type="movie" title="Movie 2"/>`,
+		"2": `<Video ratingKey="3" type="movie" title="Movie 3"/><Video ratingKey="4" type="movie" title="Movie 4"/>`,
+		"4": `<Video ratingKey="5" type="movie" title="Movie 5"/>`,
+	}
+	for start, videos := range pages {
+		httpmock.RegisterResponderWithQuery(http.MethodGet, "http://localhost:32400/library/sections/1/all",
+			map[string]string{
+				"X-Plex-Container-Start": start,
+				"X-Plex-Container-Size":  "2",
+			},
+			httpmock.NewStringResponder(http.StatusOK, fmt.Sprintf(`<MediaContainer size="2" totalSize="5">%s</MediaContainer>`, videos)))
+	}
+
+	pager := NewVideoPager(New("randomToken", "localhost", "1"), "", 2)
+	var pageSizes []int
+	var titles []string
+	for pager.Next(context.Background()) {
+		pageSizes = append(pageSizes, len(pager.Page()))
+		for _, vid := range pager.Page() {
+			titles = append(titles, vid.Title)
+			if vid.SectionID != "1" {
+				t.Errorf("expected section 1, got %q", vid.SectionID)
+			}
+		}
+	}
+	if err := pager.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(pageSizes, []int{2, 2, 1}) {
+		t.Errorf("expected pages of [2 2 1], got %v", pageSizes)
+	}
+	if len(titles) != 5 || pager.Total() != 5 {
+		t.Errorf("expected 5 videos, got %v of %d", titles, pager.Total())
+	}
+	if calls := httpmock.GetTotalCallCount(); calls != 3 {
+		t.Errorf("expected 3 requests, got %d", calls)
+	}
+}
+
+func TestDecodePage(t *testing.T) {
+	testCases := []struct {
+		name          string
+		body          string
+		expectedTotal int
+		expected      []string
+		wantErr       bool
+	}{
+		{
+			name:          "Movies And Shows",
+			body:          `<MediaContainer size="2" totalSize="10"><Video type="movie" title="Movie A"/><Directory type="show" title="Show B"/></MediaContainer>`,
+			expectedTotal: 10,
+			expected:      []string{"Movie A", "Show B"},
+		},
+		{
+			name:          "No Total Size",
+			body:          `<MediaContainer size="1"><Video type="movie" title="Movie A"/></MediaContainer>`,
+			expectedTotal: -1,
+			expected:      []string{"Movie A"},
+		},
+		{
+			name:    "Malformed",
+			body:    `<MediaContainer size="1"><Video type="movie" title="Movie A">`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			shorts, total, err := decodePage(strings.NewReader(tc.body))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: expected %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+			titles := make([]string, 0, len(shorts))
+			for _, short := range shorts {
+				titles = append(titles, short.Title)
+			}
+			if total != tc.expectedTotal || !reflect.DeepEqual(titles, tc.expected) {
+				t.Errorf("expected %v of %d, got %v of %d", tc.expected, tc.expectedTotal, titles, total)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package plex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// plexTVURL is where Plex's account service is hosted.
+const plexTVURL = "https://plex.tv"
+
+// LinkURL is where the code of a PIN is entered
+// to link this app to a Plex account.
+const LinkURL = "https://plex.tv/link"
+
+// ErrPinExpired is returned when a PIN expires, or is
+// unknown to Plex, before its code has been entered.
+var ErrPinExpired = errors.New("plex: pin expired")
+
+// Pin is a request to link this app to a Plex account. Once
+// someone signs in to Plex and enters its code, the PIN holds
+// an auth token for their account.
+type Pin struct {
+	ID        int       `json:"id"`
+	Code      string    `json:"code"`
+	AuthToken string    `json:"authToken"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Linked reports whether the PIN's code has been
+// entered and it holds an auth token.
+func (p Pin) Linked() bool {
+	return p.AuthToken != ""
+}
+
+// PinClient signs in to Plex with PINs, the same way
+// Plex's own apps on TVs and consoles do. It also looks up
+// the tokens of the accounts a server is shared with.
+type PinClient struct {
+	baseURL          string
+	clientIdentifier string
+	httpClient       *http.Client
+}
+
+type PinOption func(*PinClient)
+
+// WithPlexTVURL sets where Plex's account service is
+// hosted. An empty URL leaves the default in place.
+func WithPlexTVURL(u string) PinOption {
+	return func(pc *PinClient) {
+		if u != "" {
+			pc.baseURL = strings.TrimRight(u, "/")
+		}
+	}
+}
+
+// WithPinClientIdentifier sets the X-Plex-Client-Identifier the
+// PIN is requested with. The token it yields is tied to this
+// identifier, so it should match the one the Plex client uses.
+// An empty identifier leaves the default in place.
+func WithPinClientIdentifier(id string) PinOption {
+	return func(pc *PinClient) {
+		if id != "" {
+			pc.clientIdentifier = id
+		}
+	}
+}
+
+// NewPinClient creates a client for Plex's PIN sign in.
+func NewPinClient(opts ...PinOption) *PinClient {
+	pc := &PinClient{
+		baseURL:          plexTVURL,
+		clientIdentifier: defaultClientIdentifier,
+		httpClient:       &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(pc)
+	}
+	return pc
+}
+
+// RequestPin asks Plex for a new PIN. Its code is shown
+// to the person signing in, who enters it at LinkURL.
+func (pc *PinClient) RequestPin(ctx context.Context) (*Pin, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("RequestPin"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	// a strong PIN has a long code meant to be sent in a link,
+	// rather than the four characters typed in at plex.tv/link
+	pin, err := pc.request(ctx, http.MethodPost, "/api/v2/pins?strong=false")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("pin", pin.ID))
+	span.SetStatus(codes.Ok, "pin requested")
+	return pin, nil
+}
+
+// CheckPin returns the PIN as it is now, with an auth token once
+// its code has been entered. ErrPinExpired is returned once Plex
+// no longer knows of the PIN.
+func (pc *PinClient) CheckPin(ctx context.Context, id int) (*Pin, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("CheckPin"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.Int("pin", id))
+	pin, err := pc.request(ctx, http.MethodGet, "/api/v2/pins/"+strconv.Itoa(id))
+	if errors.Is(err, ErrNotFound) {
+		err = ErrPinExpired
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Bool("linked", pin.Linked()))
+	span.SetStatus(codes.Ok, "pin checked")
+	return pin, nil
+}
+
+// WaitForToken checks the PIN every interval until its code has been
+// entered and returns the auth token. It gives up with ErrPinExpired
+// when the PIN expires, or when the context is done.
+func (pc *PinClient) WaitForToken(ctx context.Context, pin *Pin, interval time.Duration) (string, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		checked, err := pc.CheckPin(ctx, pin.ID)
+		if err != nil {
+			return "", err
+		}
+		if checked.Linked() {
+			return checked.AuthToken, nil
+		}
+		if !pin.ExpiresAt.IsZero() && time.Now().After(pin.ExpiresAt) {
+			return "", ErrPinExpired
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// request makes a request to Plex's account service
+// and decodes the PIN it responds with.
+func (pc *PinClient) request(ctx context.Context, method, path string) (*Pin, error) {
+	var pin Pin
+	err := pc.call(ctx, method, path, "", "application/json", func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&pin)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pin, nil
+}
+
+// call makes a request to Plex's account service, as the account
+// with the provided token if there is one, and hands the body of
+// a successful response to decode. accept is the content type
+// decode expects, since some endpoints can respond with either
+// JSON or XML.
+func (pc *PinClient) call(ctx context.Context, method, path, token, accept string, decode func(io.Reader) error) error {
+	endpoint := pc.baseURL + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Plex-Client-Identifier", pc.clientIdentifier)
+	req.Header.Set("X-Plex-Product", product)
+	req.Header.Set("Accept", accept)
+	if token != "" {
+		req.Header.Set("X-Plex-Token", token)
+	}
+
+	resp, err := pc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		io.Copy(io.Discard, resp.Body)
+		return &StatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Method:     method,
+			Endpoint:   telemetry.RedactURL(endpoint),
+		}
+	}
+	return decode(resp.Body)
+}
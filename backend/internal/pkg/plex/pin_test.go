@@ -0,0 +1,65 @@
+package plex
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex/plextest"
+)
+
+func TestPinSignIn(t *testing.T) {
+	tv := plextest.NewPlexTV()
+	defer tv.Close()
+	pins := NewPinClient(WithPlexTVURL(tv.URL), WithPinClientIdentifier("test-client"))
+
+	pin, err := pins.RequestPin(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pin.Code == "" || pin.Linked() || pin.ExpiresAt.Before(time.Now()) {
+		t.Fatalf("unexpected new pin %+v", pin)
+	}
+
+	checked, err := pins.CheckPin(context.Background(), pin.ID)
+	if err != nil || checked.Linked() {
+		t.Fatalf("expected an unlinked pin, got %+v, %v", checked, err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		tv.Link(pin.Code, "account-token")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	token, err := pins.WaitForToken(ctx, pin, 5*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token != "account-token" {
+		t.Errorf("expected account-token, got %q", token)
+	}
+
+	// the PIN belongs to the client that requested it
+	other := NewPinClient(WithPlexTVURL(tv.URL), WithPinClientIdentifier("another-client"))
+	if _, err := other.CheckPin(context.Background(), pin.ID); !errors.Is(err, ErrPinExpired) {
+		t.Errorf("expected ErrPinExpired, got %v", err)
+	}
+}
+
+func TestWaitForTokenExpires(t *testing.T) {
+	tv := plextest.NewPlexTV(plextest.WithPinLifetime(30 * time.Millisecond))
+	defer tv.Close()
+	pins := NewPinClient(WithPlexTVURL(tv.URL))
+
+	pin, err := pins.RequestPin(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := pins.WaitForToken(ctx, pin, 5*time.Millisecond); !errors.Is(err, ErrPinExpired) {
+		t.Errorf("expected ErrPinExpired, got %v", err)
+	}
+}
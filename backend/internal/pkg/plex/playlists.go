@@ -0,0 +1,145 @@
+package plex
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// videoPlaylistType is the playlist type that holds
+// movies and TV episodes.
+const videoPlaylistType = "video"
+
+// Playlist is a Plex playlist owned by the account
+// whose token the client uses.
+type Playlist struct {
+	RatingKey    int    `xml:"ratingKey,attr" json:"rating_key"`
+	Title        string `xml:"title,attr" json:"title"`
+	PlaylistType string `xml:"playlistType,attr" json:"playlist_type"`
+	Smart        bool   `xml:"smart,attr" json:"smart"`
+	LeafCount    int    `xml:"leafCount,attr" json:"item_count"`
+}
+
+type playlistsContainer struct {
+	XMLName   xml.Name   `xml:"MediaContainer"`
+	Playlists []Playlist `xml:"Playlist"`
+}
+
+// GetPlaylists lists the video playlists on the Plex server.
+func GetPlaylists(ctx context.Context, c Client) ([]Playlist, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetPlaylists"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	var container playlistsContainer
+	uri := c.Connect(WithPath("/playlists"), WithQuery("playlistType", videoPlaylistType))
+	if err := getXML(ctx, c, uri, &container); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("count", len(container.Playlists)))
+	span.SetStatus(codes.Ok, "playlists retrieved")
+	return container.Playlists, nil
+}
+
+// SetPlaylist makes the playlist with the provided title hold
+// exactly the media with the provided rating keys, in order. A new
+// playlist is built with the media and then swapped in for any
+// existing playlist with the title, so a failure part of the way
+// through never leaves the playlist empty and calling it again
+// doesn't duplicate items. Shows are added to a playlist as all of
+// their episodes. The playlist belongs to the account whose token
+// the client uses.
+func SetPlaylist(ctx context.Context, c Client, title string, ratingKeys []int) (*Playlist, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("SetPlaylist"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.String("title", title), attribute.Int("count", len(ratingKeys)))
+	if len(ratingKeys) == 0 {
+		err := fmt.Errorf("no media to add to playlist %q", title)
+		span.RecordError(err)
+		return nil, err
+	}
+
+	machineIdentifier, err := GetMachineIdentifier(ctx, c)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	playlists, err := GetPlaylists(ctx, c)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	replaced := findPlaylists(playlists, title)
+
+	// creating a playlist isn't retried, so a request that times
+	// out after Plex made the playlist can't make a second one
+	log.Println("creating playlist ", title)
+	var container playlistsContainer
+	uri := c.Connect(
+		WithPath("/playlists"),
+		WithQuery("type", videoPlaylistType),
+		WithQuery("title", title),
+		WithQuery("smart", "0"),
+		WithQuery("uri", libraryItemsURI(machineIdentifier, ratingKeys)),
+	)
+	if err := requestXML(ctx, c, uri, http.MethodPost, &container); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	if len(container.Playlists) == 0 {
+		err := fmt.Errorf("plex did not return playlist %q", title)
+		span.RecordError(err)
+		return nil, err
+	}
+	span.AddEvent("playlist created")
+
+	for _, old := range replaced {
+		log.Println("removing the playlist ", title, " it replaces")
+		path := fmt.Sprintf("/playlists/%d", old.RatingKey)
+		err := requestXML(ctx, c, c.Connect(WithPath(path)), http.MethodDelete, nil)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+	span.SetAttributes(attribute.Int("replaced", len(replaced)))
+	span.SetStatus(codes.Ok, "playlist set")
+	return &container.Playlists[0], nil
+}
+
+// findPlaylists returns the regular video playlists
+// with the provided title.
+func findPlaylists(playlists []Playlist, title string) []Playlist {
+	var found []Playlist
+	for _, playlist := range playlists {
+		if playlist.Title == title && !playlist.Smart && playlist.PlaylistType == videoPlaylistType {
+			found = append(found, playlist)
+		}
+	}
+	return found
+}
+
+// libraryItemsURI is how Plex refers to a list of media on
+// a server when adding them to a playlist.
+func libraryItemsURI(machineIdentifier string, ratingKeys []int) string {
+	return fmt.Sprintf("server://%s/com.plexapp.plugins.library/library/metadata/%s", machineIdentifier, joinRatingKeys(ratingKeys))
+}
+
+// joinRatingKeys formats rating keys as the comma separated
+// list Plex accepts for operations on several items.
+func joinRatingKeys(ratingKeys []int) string {
+	keys := make([]string, 0, len(ratingKeys))
+	for _, key := range ratingKeys {
+		keys = append(keys, strconv.Itoa(key))
+	}
+	return strings.Join(keys, ",")
+}
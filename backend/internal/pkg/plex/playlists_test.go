@@ -0,0 +1,111 @@
+package plex
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+const playlistIdentity = `<MediaContainer size="0" claimed="1" machineIdentifier="abc123" version="1.40.0"/>`
+
+func TestSetPlaylistCreates(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/identity",
+		httpmock.NewStringResponder(http.StatusOK, playlistIdentity))
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/playlists",
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="1">
+	<Playlist ratingKey="800" title="Recommended for Kid" playlistType="video" smart="1" leafCount="10"/>
+</MediaContainer>`))
+	httpmock.RegisterResponderWithQuery(http.MethodPost, "http://localhost:32400/playlists",
+		map[string]string{
+			"type":  "video",
+			"title": "Recommended for Kid",
+			"smart": "0",
+			"uri":   "server://abc123/com.plexapp.plugins.library/library/metadata/20,10",
+		},
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="1">
+	<Playlist ratingKey="900" title="Recommended for Kid" playlistType="video" smart="0" leafCount="2"/>
+</MediaContainer>`))
+
+	playlist, err := SetPlaylist(context.Background(), New("randomToken", "localhost", "1"), "Recommended for Kid", []int{20, 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := Playlist{RatingKey: 900, Title: "Recommended for Kid", PlaylistType: "video", LeafCount: 2}
+	if *playlist != expected {
+		t.Errorf("expected %+v, got %+v", expected, *playlist)
+	}
+}
+
+func TestSetPlaylistReplaces(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/identity",
+		httpmock.NewStringResponder(http.StatusOK, playlistIdentity))
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/playlists",
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="1">
+	<Playlist ratingKey="900" title="Recommended for Kid" playlistType="video" smart="0" leafCount="5"/>
+</MediaContainer>`))
+	httpmock.RegisterResponderWithQuery(http.MethodPost, "http://localhost:32400/playlists",
+		map[string]string{
+			"type":  "video",
+			"title": "Recommended for Kid",
+			"smart": "0",
+			"uri":   "server://abc123/com.plexapp.plugins.library/library/metadata/30",
+		},
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="1">
+	<Playlist ratingKey="901" title="Recommended for Kid" playlistType="video" smart="0" leafCount="1"/>
+</MediaContainer>`))
+	httpmock.RegisterResponder(http.MethodDelete, "http://localhost:32400/playlists/900",
+		httpmock.NewStringResponder(http.StatusOK, ""))
+
+	playlist, err := SetPlaylist(context.Background(), New("randomToken", "localhost", "1"), "Recommended for Kid", []int{30})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if playlist.RatingKey != 901 || playlist.LeafCount != 1 {
+		t.Errorf("unexpected playlist: %+v", *playlist)
+	}
+	calls := httpmock.GetCallCountInfo()
+	if calls["DELETE http://localhost:32400/playlists/900"] != 1 {
+		t.Errorf("expected the old playlist to be removed once, got %v", calls)
+	}
+}
+
+func TestSetPlaylistKeepsOldPlaylistWhenCreateFails(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/identity",
+		httpmock.NewStringResponder(http.StatusOK, playlistIdentity))
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/playlists",
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="1">
+	<Playlist ratingKey="900" title="Recommended for Kid" playlistType="video" smart="0" leafCount="5"/>
+</MediaContainer>`))
+	httpmock.RegisterResponder(http.MethodPost, "http://localhost:32400/playlists",
+		httpmock.NewStringResponder(http.StatusServiceUnavailable, ""))
+
+	c := New("randomToken", "localhost", "1", WithRetryPolicy(2, time.Millisecond, time.Millisecond))
+	if _, err := SetPlaylist(context.Background(), c, "Recommended for Kid", []int{30}); err == nil {
+		t.Fatal("expected an error")
+	}
+	calls := httpmock.GetCallCountInfo()
+	if calls["POST http://localhost:32400/playlists"] != 1 {
+		t.Errorf("expected creating the playlist to not be retried, got %v", calls)
+	}
+	if calls["DELETE http://localhost:32400/playlists/900"] != 0 {
+		t.Errorf("expected the old playlist to be kept, got %v", calls)
+	}
+}
+
+func TestSetPlaylistRequiresMedia(t *testing.T) {
+	if _, err := SetPlaylist(context.Background(), New("randomToken", "localhost", "1"), "Recommended", nil); err == nil {
+		t.Error("expected an error for an empty playlist")
+	}
+}
@@ -0,0 +1,397 @@
+package plextest
+
+import (
+	"encoding/xml"
+	"strconv"
+	"time"
+)
+
+// Section is a library section served by the fake server.
+type Section struct {
+	Key       string
+	Type      string
+	Title     string
+	UpdatedAt int64
+	Items     []Item
+}
+
+// Item is a movie, a show when it has Episodes, or
+// an artist when it has Albums.
+type Item struct {
+	RatingKey     int
+	Type          string
+	Guid          string
+	Title         string
+	Summary       string
+	ContentRating string
+	Year          int
+	// Duration is the runtime in milliseconds.
+	Duration int
+	Genres   []string
+	// ViewCount, LastViewedAt, ViewOffset and UserRating are
+	// the play state of the account that owns the server's
+	// token. ViewOffset is in milliseconds.
+	ViewCount    int
+	LastViewedAt int64
+	ViewOffset   int
+	UserRating   float64
+	Episodes     []Episode
+	Albums       []Album
+}
+
+// Episode is an episode of a show.
+type Episode struct {
+	RatingKey int
+	Guid      string
+	Title     string
+	Season    int
+	Index     int
+	ViewCount int
+}
+
+// Album is an album by an artist.
+type Album struct {
+	RatingKey  int
+	Guid       string
+	Title      string
+	Year       int
+	Genres     []string
+	Moods      []string
+	ViewCount  int
+	UserRating float64
+	Tracks     []Track
+}
+
+// Track is a song on an album.
+type Track struct {
+	RatingKey int
+	Title     string
+	Index     int
+	ViewCount int
+}
+
+// Account is a user with access to the server.
+type Account struct {
+	ID   int
+	Name string
+}
+
+// Play is an entry in the server's watch history. RatingKey
+// is a movie, an episode or a track.
+type Play struct {
+	AccountID int
+	RatingKey int
+	ViewedAt  time.Time
+}
+
+// Playlist is a playlist created on the server, holding
+// the rating keys of its movies and episodes in order.
+type Playlist struct {
+	RatingKey int
+	Title     string
+	// AccountID is the account the playlist belongs to.
+	AccountID int
+	Items     []int
+}
+
+// The elements below are the XML shapes Plex responds with.
+
+type mediaContainer struct {
+	XMLName           xml.Name    `xml:"MediaContainer"`
+	Size              int         `xml:"size,attr"`
+	TotalSize         *int        `xml:"totalSize,attr,omitempty"`
+	MachineIdentifier string      `xml:"machineIdentifier,attr,omitempty"`
+	LibrarySectionID  string      `xml:"librarySectionID,attr,omitempty"`
+	Directories       []directory `xml:"Directory"`
+	Videos            []video     `xml:"Video"`
+	Tracks            []track     `xml:"Track"`
+	Playlists         []playlist  `xml:"Playlist"`
+	Accounts          []account   `xml:"Account"`
+}
+
+type tag struct {
+	Tag string `xml:"tag,attr"`
+}
+
+type directory struct {
+	RatingKey       int     `xml:"ratingKey,attr,omitempty"`
+	Key             string  `xml:"key,attr"`
+	Guid            string  `xml:"guid,attr,omitempty"`
+	Type            string  `xml:"type,attr"`
+	Title           string  `xml:"title,attr"`
+	ParentRatingKey int     `xml:"parentRatingKey,attr,omitempty"`
+	ParentKey       string  `xml:"parentKey,attr,omitempty"`
+	ParentGuid      string  `xml:"parentGuid,attr,omitempty"`
+	ParentTitle     string  `xml:"parentTitle,attr,omitempty"`
+	Summary         string  `xml:"summary,attr,omitempty"`
+	ContentRating   string  `xml:"contentRating,attr,omitempty"`
+	Year            int     `xml:"year,attr,omitempty"`
+	Thumb           string  `xml:"thumb,attr,omitempty"`
+	Art             string  `xml:"art,attr,omitempty"`
+	UpdatedAt       int64   `xml:"updatedAt,attr,omitempty"`
+	ChildCount      int     `xml:"childCount,attr,omitempty"`
+	LeafCount       int     `xml:"leafCount,attr,omitempty"`
+	ViewedLeafCount int     `xml:"viewedLeafCount,attr,omitempty"`
+	ViewCount       int     `xml:"viewCount,attr,omitempty"`
+	LastViewedAt    int64   `xml:"lastViewedAt,attr,omitempty"`
+	UserRating      float64 `xml:"userRating,attr,omitempty"`
+	Genres          []tag   `xml:"Genre"`
+	Moods           []tag   `xml:"Mood"`
+}
+
+type video struct {
+	HistoryKey           string  `xml:"historyKey,attr,omitempty"`
+	RatingKey            int     `xml:"ratingKey,attr"`
+	Key                  string  `xml:"key,attr"`
+	Guid                 string  `xml:"guid,attr,omitempty"`
+	Type                 string  `xml:"type,attr"`
+	Title                string  `xml:"title,attr"`
+	Summary              string  `xml:"summary,attr,omitempty"`
+	ContentRating        string  `xml:"contentRating,attr,omitempty"`
+	Year                 int     `xml:"year,attr,omitempty"`
+	Thumb                string  `xml:"thumb,attr,omitempty"`
+	Art                  string  `xml:"art,attr,omitempty"`
+	Duration             int     `xml:"duration,attr,omitempty"`
+	ViewCount            int     `xml:"viewCount,attr,omitempty"`
+	LastViewedAt         int64   `xml:"lastViewedAt,attr,omitempty"`
+	ViewOffset           int     `xml:"viewOffset,attr,omitempty"`
+	UserRating           float64 `xml:"userRating,attr,omitempty"`
+	ViewedAt             int64   `xml:"viewedAt,attr,omitempty"`
+	AccountID            int     `xml:"accountID,attr,omitempty"`
+	LibrarySectionID     string  `xml:"librarySectionID,attr,omitempty"`
+	ParentIndex          int     `xml:"parentIndex,attr,omitempty"`
+	Index                int     `xml:"index,attr,omitempty"`
+	GrandparentRatingKey int     `xml:"grandparentRatingKey,attr,omitempty"`
+	GrandparentKey       string  `xml:"grandparentKey,attr,omitempty"`
+	GrandparentGuid      string  `xml:"grandparentGuid,attr,omitempty"`
+	GrandparentTitle     string  `xml:"grandparentTitle,attr,omitempty"`
+	GrandparentThumb     string  `xml:"grandparentThumb,attr,omitempty"`
+	GrandparentArt       string  `xml:"grandparentArt,attr,omitempty"`
+	Genres               []tag   `xml:"Genre"`
+}
+
+type track struct {
+	HistoryKey           string `xml:"historyKey,attr,omitempty"`
+	RatingKey            int    `xml:"ratingKey,attr"`
+	Key                  string `xml:"key,attr"`
+	Type                 string `xml:"type,attr"`
+	Title                string `xml:"title,attr"`
+	Index                int    `xml:"index,attr,omitempty"`
+	ViewCount            int    `xml:"viewCount,attr,omitempty"`
+	LastViewedAt         int64  `xml:"lastViewedAt,attr,omitempty"`
+	ViewedAt             int64  `xml:"viewedAt,attr,omitempty"`
+	AccountID            int    `xml:"accountID,attr,omitempty"`
+	LibrarySectionID     string `xml:"librarySectionID,attr,omitempty"`
+	ParentRatingKey      int    `xml:"parentRatingKey,attr,omitempty"`
+	ParentKey            string `xml:"parentKey,attr,omitempty"`
+	ParentGuid           string `xml:"parentGuid,attr,omitempty"`
+	ParentTitle          string `xml:"parentTitle,attr,omitempty"`
+	ParentYear           int    `xml:"parentYear,attr,omitempty"`
+	ParentThumb          string `xml:"parentThumb,attr,omitempty"`
+	GrandparentRatingKey int    `xml:"grandparentRatingKey,attr,omitempty"`
+	GrandparentKey       string `xml:"grandparentKey,attr,omitempty"`
+	GrandparentTitle     string `xml:"grandparentTitle,attr,omitempty"`
+}
+
+type playlist struct {
+	RatingKey    int    `xml:"ratingKey,attr"`
+	Key          string `xml:"key,attr"`
+	Type         string `xml:"type,attr"`
+	Title        string `xml:"title,attr"`
+	Smart        string `xml:"smart,attr"`
+	PlaylistType string `xml:"playlistType,attr"`
+	LeafCount    int    `xml:"leafCount,attr"`
+}
+
+type account struct {
+	ID   int    `xml:"id,attr"`
+	Key  string `xml:"key,attr"`
+	Name string `xml:"name,attr"`
+}
+
+func metadataKey(ratingKey int) string {
+	return "/library/metadata/" + strconv.Itoa(ratingKey)
+}
+
+// imageVersion is the timestamp Plex adds to image
+// paths so clients notice when artwork changes.
+const imageVersion = "1700000000"
+
+// imagePath is the versioned path of an item's thumb or art.
+func imagePath(ratingKey int, kind string) string {
+	return metadataKey(ratingKey) + "/" + kind + "/" + imageVersion
+}
+
+// tags renders the names of genres, moods and other tags.
+func tags(names []string) []tag {
+	rendered := make([]tag, 0, len(names))
+	for _, name := range names {
+		rendered = append(rendered, tag{Tag: name})
+	}
+	return rendered
+}
+
+// movieVideo renders a movie as it's listed in a section.
+func movieVideo(item Item) video {
+	return video{
+		RatingKey:     item.RatingKey,
+		Key:           metadataKey(item.RatingKey),
+		Guid:          item.Guid,
+		Type:          "movie",
+		Title:         item.Title,
+		Summary:       item.Summary,
+		ContentRating: item.ContentRating,
+		Year:          item.Year,
+		Thumb:         imagePath(item.RatingKey, "thumb"),
+		Art:           imagePath(item.RatingKey, "art"),
+		Duration:      item.Duration,
+		ViewCount:     item.ViewCount,
+		LastViewedAt:  item.LastViewedAt,
+		ViewOffset:    item.ViewOffset,
+		UserRating:    item.UserRating,
+		Genres:        tags(item.Genres),
+	}
+}
+
+// showDirectory renders a show as it's listed in a section.
+func showDirectory(item Item) directory {
+	seasons := make(map[int]bool)
+	var viewed int
+	for _, episode := range item.Episodes {
+		seasons[episode.Season] = true
+		if episode.ViewCount > 0 {
+			viewed++
+		}
+	}
+	return directory{
+		RatingKey:       item.RatingKey,
+		Key:             metadataKey(item.RatingKey) + "/children",
+		Guid:            item.Guid,
+		Type:            "show",
+		Title:           item.Title,
+		Summary:         item.Summary,
+		ContentRating:   item.ContentRating,
+		Year:            item.Year,
+		Thumb:           imagePath(item.RatingKey, "thumb"),
+		Art:             imagePath(item.RatingKey, "art"),
+		ChildCount:      len(seasons),
+		LeafCount:       len(item.Episodes),
+		ViewedLeafCount: viewed,
+		ViewCount:       item.ViewCount,
+		LastViewedAt:    item.LastViewedAt,
+		UserRating:      item.UserRating,
+		Genres:          tags(item.Genres),
+	}
+}
+
+// episodeVideo renders an episode with the show it belongs to.
+func episodeVideo(show Item, episode Episode) video {
+	return video{
+		RatingKey:            episode.RatingKey,
+		Key:                  metadataKey(episode.RatingKey),
+		Guid:                 episode.Guid,
+		Type:                 "episode",
+		Title:                episode.Title,
+		ContentRating:        show.ContentRating,
+		ViewCount:            episode.ViewCount,
+		ParentIndex:          episode.Season,
+		Index:                episode.Index,
+		GrandparentRatingKey: show.RatingKey,
+		GrandparentKey:       metadataKey(show.RatingKey),
+		GrandparentGuid:      show.Guid,
+		GrandparentTitle:     show.Title,
+		GrandparentThumb:     imagePath(show.RatingKey, "thumb"),
+		GrandparentArt:       imagePath(show.RatingKey, "art"),
+	}
+}
+
+// artistDirectory renders an artist as it's listed in a section.
+func artistDirectory(item Item) directory {
+	var tracks int
+	for _, album := range item.Albums {
+		tracks += len(album.Tracks)
+	}
+	return directory{
+		RatingKey:    item.RatingKey,
+		Key:          metadataKey(item.RatingKey) + "/children",
+		Guid:         item.Guid,
+		Type:         "artist",
+		Title:        item.Title,
+		Summary:      item.Summary,
+		Thumb:        imagePath(item.RatingKey, "thumb"),
+		Art:          imagePath(item.RatingKey, "art"),
+		ChildCount:   len(item.Albums),
+		LeafCount:    tracks,
+		ViewCount:    item.ViewCount,
+		LastViewedAt: item.LastViewedAt,
+		Genres:       tags(item.Genres),
+	}
+}
+
+// albumDirectory renders an album with the artist it belongs to.
+func albumDirectory(artist Item, album Album) directory {
+	return directory{
+		RatingKey:       album.RatingKey,
+		Key:             metadataKey(album.RatingKey) + "/children",
+		Guid:            album.Guid,
+		Type:            "album",
+		Title:           album.Title,
+		ParentRatingKey: artist.RatingKey,
+		ParentKey:       metadataKey(artist.RatingKey),
+		ParentGuid:      artist.Guid,
+		ParentTitle:     artist.Title,
+		Year:            album.Year,
+		Thumb:           imagePath(album.RatingKey, "thumb"),
+		Art:             imagePath(artist.RatingKey, "art"),
+		LeafCount:       len(album.Tracks),
+		ViewCount:       album.ViewCount,
+		UserRating:      album.UserRating,
+		Genres:          tags(album.Genres),
+		Moods:           tags(album.Moods),
+	}
+}
+
+// trackElement renders a track with its album and artist.
+func trackElement(artist Item, album Album, t Track) track {
+	return track{
+		RatingKey:            t.RatingKey,
+		Key:                  metadataKey(t.RatingKey),
+		Type:                 "track",
+		Title:                t.Title,
+		Index:                t.Index,
+		ViewCount:            t.ViewCount,
+		ParentRatingKey:      album.RatingKey,
+		ParentKey:            metadataKey(album.RatingKey),
+		ParentGuid:           album.Guid,
+		ParentTitle:          album.Title,
+		ParentYear:           album.Year,
+		ParentThumb:          imagePath(album.RatingKey, "thumb"),
+		GrandparentRatingKey: artist.RatingKey,
+		GrandparentKey:       metadataKey(artist.RatingKey),
+		GrandparentTitle:     artist.Title,
+	}
+}
+
+// isArtist reports whether the item is an artist.
+func (i Item) isArtist() bool {
+	return i.Type == "artist" || len(i.Albums) > 0
+}
+
+// isShow reports whether the item is a show.
+func (i Item) isShow() bool {
+	return i.Type == "show" || len(i.Episodes) > 0
+}
+
+// render adds the item to the container the way a section
+// listing or a metadata request shows it.
+func (i Item) render(container *mediaContainer) {
+	if i.isArtist() {
+		container.Directories = append(container.Directories, artistDirectory(i))
+		return
+	}
+	if i.isShow() {
+		container.Directories = append(container.Directories, showDirectory(i))
+		return
+	}
+	container.Videos = append(container.Videos, movieVideo(i))
+}
@@ -0,0 +1,273 @@
+package plextest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultPinLifetime is how long a PIN lasts on a PlexTV
+// that isn't given its own lifetime, the same as plex.tv.
+const DefaultPinLifetime = 15 * time.Minute
+
+// PlexTV is a fake of the PIN sign in, server sharing and Plex Home
+// endpoints on plex.tv. Call Link to play the part of someone
+// entering a PIN's code, Share to share a server with an account and
+// AddHomeUser to add an account to someone's Plex Home. It's safe to
+// use from multiple goroutines.
+//
+//	tv := plextest.NewPlexTV()
+//	defer tv.Close()
+//	pins := plex.NewPinClient(plex.WithPlexTVURL(tv.URL))
+type PlexTV struct {
+	*httptest.Server
+
+	lifetime time.Duration
+
+	mu        sync.Mutex
+	pins      []*pin
+	shares    []share
+	homeUsers []homeUser
+}
+
+// homeUser is a member of the Plex Home of the
+// account with ownerToken.
+type homeUser struct {
+	ownerToken string
+	userID     int
+	authToken  string
+}
+
+// share is a server shared with an account.
+type share struct {
+	machineIdentifier string
+	ownerToken        string
+	userID            int
+	accessToken       string
+}
+
+// sharedServer is the shape plex.tv lists a shared server in.
+type sharedServer struct {
+	UserID      int    `xml:"userID,attr"`
+	AccessToken string `xml:"accessToken,attr"`
+}
+
+// pin is a PIN the fake has handed out.
+type pin struct {
+	id               int
+	code             string
+	clientIdentifier string
+	authToken        string
+	expiresAt        time.Time
+}
+
+// pinJSON is the shape plex.tv responds to PIN requests with.
+type pinJSON struct {
+	ID               int     `json:"id"`
+	Code             string  `json:"code"`
+	ClientIdentifier string  `json:"clientIdentifier"`
+	ExpiresAt        string  `json:"expiresAt"`
+	AuthToken        *string `json:"authToken"`
+}
+
+type PlexTVOption func(*PlexTV)
+
+// WithPinLifetime sets how long PINs last before they expire.
+func WithPinLifetime(d time.Duration) PlexTVOption {
+	return func(p *PlexTV) {
+		p.lifetime = d
+	}
+}
+
+// NewPlexTV starts a fake plex.tv. Call Close when finished with it.
+func NewPlexTV(opts ...PlexTVOption) *PlexTV {
+	p := &PlexTV{lifetime: DefaultPinLifetime}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v2/pins", p.createPin)
+	mux.HandleFunc("GET /api/v2/pins/{id}", p.getPin)
+	mux.HandleFunc("GET /api/servers/{machineIdentifier}/shared_servers", p.sharedServers)
+	mux.HandleFunc("POST /api/home/users/{id}/switch", p.switchHomeUser)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// Link signs in to the account with the provided token and
+// enters the code, as someone would at plex.tv/link. It
+// reports whether an unexpired PIN had that code.
+func (p *PlexTV) Link(code, authToken string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pin := range p.pins {
+		if pin.code == code && time.Now().Before(pin.expiresAt) {
+			pin.authToken = authToken
+			return true
+		}
+	}
+	return false
+}
+
+// Share shares the server with machineIdentifier, owned by the
+// account with ownerToken, with the account with userID. That
+// account uses the server with accessToken.
+func (p *PlexTV) Share(machineIdentifier, ownerToken string, userID int, accessToken string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.shares = append(p.shares, share{
+		machineIdentifier: machineIdentifier,
+		ownerToken:        ownerToken,
+		userID:            userID,
+		accessToken:       accessToken,
+	})
+}
+
+func (p *PlexTV) createPin(w http.ResponseWriter, r *http.Request) {
+	clientIdentifier := r.Header.Get("X-Plex-Client-Identifier")
+	if clientIdentifier == "" || r.Header.Get("X-Plex-Product") == "" {
+		http.Error(w, "X-Plex-Client-Identifier and X-Plex-Product are required", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	id := len(p.pins) + 1
+	created := &pin{
+		id:               id,
+		code:             fmt.Sprintf("PIN%d", id),
+		clientIdentifier: clientIdentifier,
+		expiresAt:        time.Now().Add(p.lifetime),
+	}
+	p.pins = append(p.pins, created)
+	body := created.json()
+	p.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(body)
+}
+
+// getPin responds with the PIN, which is only found by the
+// client that requested it and only until it expires.
+func (p *PlexTV) getPin(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	p.mu.Lock()
+	var found *pinJSON
+	for _, pin := range p.pins {
+		if pin.id == id && pin.clientIdentifier == r.Header.Get("X-Plex-Client-Identifier") && time.Now().Before(pin.expiresAt) {
+			body := pin.json()
+			found = &body
+		}
+	}
+	p.mu.Unlock()
+
+	if found == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(found)
+}
+
+func (p *pin) json() pinJSON {
+	body := pinJSON{
+		ID:               p.id,
+		Code:             p.code,
+		ClientIdentifier: p.clientIdentifier,
+		ExpiresAt:        p.expiresAt.UTC().Format(time.RFC3339),
+	}
+	if p.authToken != "" {
+		body.AuthToken = &p.authToken
+	}
+	return body
+}
+
+// AddHomeUser adds the account with userID to the Plex Home of the
+// account with ownerToken. Switching to it gives its authToken.
+func (p *PlexTV) AddHomeUser(ownerToken string, userID int, authToken string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.homeUsers = append(p.homeUsers, homeUser{ownerToken: ownerToken, userID: userID, authToken: authToken})
+}
+
+// owns reports whether the token belongs to an account that has
+// shared a server or has a Plex Home. The caller must hold mu.
+func (p *PlexTV) owns(token string) bool {
+	for _, s := range p.shares {
+		if s.ownerToken == token {
+			return true
+		}
+	}
+	for _, u := range p.homeUsers {
+		if u.ownerToken == token {
+			return true
+		}
+	}
+	return false
+}
+
+// switchHomeUser switches to a member of the caller's Plex Home,
+// responding with the member's token.
+func (p *PlexTV) switchHomeUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	p.mu.Lock()
+	var found *homeUser
+	for i, u := range p.homeUsers {
+		if u.userID == id && u.ownerToken == r.Header.Get("X-Plex-Token") {
+			found = &p.homeUsers[i]
+		}
+	}
+	p.mu.Unlock()
+
+	if found == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml;charset=utf-8")
+	fmt.Fprintf(w, `<user id="%d" authenticationToken="%s"/>`, found.userID, found.authToken)
+}
+
+// sharedServers lists the accounts a server is shared with, which
+// only the account that owns the server is allowed to see. Like
+// plex.tv, it responds with JSON when that's what's accepted.
+func (p *PlexTV) sharedServers(w http.ResponseWriter, r *http.Request) {
+	var container struct {
+		XMLName       xml.Name       `xml:"MediaContainer"`
+		SharedServers []sharedServer `xml:"SharedServer"`
+	}
+	token := r.Header.Get("X-Plex-Token")
+	p.mu.Lock()
+	owned := p.owns(token)
+	for _, s := range p.shares {
+		if s.machineIdentifier != r.PathValue("machineIdentifier") {
+			continue
+		}
+		if s.ownerToken != token {
+			continue
+		}
+		container.SharedServers = append(container.SharedServers, sharedServer{UserID: s.userID, AccessToken: s.accessToken})
+	}
+	p.mu.Unlock()
+
+	if !owned {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("Accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"MediaContainer": container})
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml;charset=utf-8")
+	xml.NewEncoder(w).Encode(container)
+}
@@ -0,0 +1,684 @@
+// Package plextest provides an in-process fake Plex Media Server for
+// testing code that talks to Plex over HTTP. It serves a configurable
+// library with the same XML shapes as a real server, so requests can
+// be exercised end to end without one.
+//
+//	server := plextest.NewServer(
+//		plextest.WithSection(plextest.Section{Key: "1", Type: "movie", Title: "Movies", Items: movies}),
+//	)
+//	defer server.Close()
+//	client := plex.New(server.Token(), server.URL, "1")
+package plextest
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Defaults for a server that isn't given its own.
+const (
+	DefaultToken             = "plextest-token"
+	DefaultMachineIdentifier = "plextest-machine"
+)
+
+// Server is a fake Plex Media Server listening on a local port.
+// It's safe to use from multiple goroutines.
+type Server struct {
+	*httptest.Server
+
+	token             string
+	machineIdentifier string
+	// accountTokens are the tokens of accounts other
+	// than the owner, by token
+	accountTokens map[string]int
+
+	mu        sync.Mutex
+	sections  []Section
+	accounts  []Account
+	plays     []Play
+	playlists []*Playlist
+	// nextRatingKey is given to the next playlist created
+	nextRatingKey int
+}
+
+type Option func(*Server)
+
+// WithToken sets the token requests must send in the X-Plex-Token
+// header. An empty token accepts every request.
+func WithToken(token string) Option {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// WithAccountToken lets the account with the provided ID use the
+// server with its own token. Requests with the server's token act
+// as its owner, account 1.
+func WithAccountToken(id int, token string) Option {
+	return func(s *Server) {
+		s.accountTokens[token] = id
+	}
+}
+
+// WithMachineIdentifier sets the server's unique ID.
+func WithMachineIdentifier(id string) Option {
+	return func(s *Server) {
+		s.machineIdentifier = id
+	}
+}
+
+// WithSection adds a library section and its media.
+func WithSection(section Section) Option {
+	return func(s *Server) {
+		s.sections = append(s.sections, section)
+	}
+}
+
+// WithAccount adds a user with access to the server.
+func WithAccount(id int, name string) Option {
+	return func(s *Server) {
+		s.accounts = append(s.accounts, Account{ID: id, Name: name})
+	}
+}
+
+// WithPlays adds entries to the server's watch history.
+func WithPlays(plays ...Play) Option {
+	return func(s *Server) {
+		s.plays = append(s.plays, plays...)
+	}
+}
+
+// NewServer starts a fake Plex Media Server. Call Close
+// when finished with it.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		token:             DefaultToken,
+		machineIdentifier: DefaultMachineIdentifier,
+		accountTokens:     make(map[string]int),
+		nextRatingKey:     90000,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /identity", s.identity)
+	mux.HandleFunc("GET /accounts", s.listAccounts)
+	mux.HandleFunc("GET /library/sections", s.listSections)
+	mux.HandleFunc("GET /library/sections/{section}/all", s.sectionAll)
+	mux.HandleFunc("GET /library/sections/{section}/recentlyViewed", s.recentlyViewed)
+	mux.HandleFunc("GET /library/metadata/{ratingKey}", s.metadata)
+	mux.HandleFunc("GET /library/metadata/{ratingKey}/{kind}", s.image)
+	mux.HandleFunc("GET /photo/:/transcode", s.transcodeImage)
+	mux.HandleFunc("GET /status/sessions/history/all", s.history)
+	mux.HandleFunc("GET /playlists", s.listPlaylists)
+	mux.HandleFunc("POST /playlists", s.createPlaylist)
+	mux.HandleFunc("GET /playlists/{playlist}/items", s.playlistItems)
+	mux.HandleFunc("PUT /playlists/{playlist}/items", s.addPlaylistItems)
+	mux.HandleFunc("DELETE /playlists/{playlist}/items", s.clearPlaylistItems)
+	mux.HandleFunc("DELETE /playlists/{playlist}", s.deletePlaylist)
+	s.Server = httptest.NewServer(s.authorize(mux))
+	return s
+}
+
+// Token returns the token the server accepts.
+func (s *Server) Token() string {
+	return s.token
+}
+
+// Playlists returns the playlists that have been created
+// on the server.
+func (s *Server) Playlists() []Playlist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	playlists := make([]Playlist, 0, len(s.playlists))
+	for _, p := range s.playlists {
+		playlists = append(playlists, Playlist{RatingKey: p.RatingKey, Title: p.Title, AccountID: p.AccountID, Items: slices.Clone(p.Items)})
+	}
+	return playlists
+}
+
+// authorize rejects requests without the server's token
+// the way Plex does.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Plex-Token")
+		if _, ok := s.accountTokens[token]; s.token != "" && token != s.token && !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// accountID returns the ID of the account making the request.
+func (s *Server) accountID(r *http.Request) int {
+	if id, ok := s.accountTokens[r.Header.Get("X-Plex-Token")]; ok {
+		return id
+	}
+	return 1
+}
+
+func writeXML(w http.ResponseWriter, container mediaContainer) {
+	container.Size = len(container.Directories) + len(container.Videos) + len(container.Tracks) + len(container.Playlists) + len(container.Accounts)
+	w.Header().Set("Content-Type", "text/xml;charset=utf-8")
+	body, err := xml.Marshal(container)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// pageBounds returns the slice of n items requested with Plex's
+// container start and size parameters, which may be sent as
+// query parameters or headers.
+func pageBounds(r *http.Request, n int) (int, int) {
+	param := func(name string) string {
+		if v := r.URL.Query().Get(name); v != "" {
+			return v
+		}
+		return r.Header.Get(name)
+	}
+	start, err := strconv.Atoi(param("X-Plex-Container-Start"))
+	if err != nil || start < 0 {
+		start = 0
+	}
+	start = min(start, n)
+	size, err := strconv.Atoi(param("X-Plex-Container-Size"))
+	if err != nil || size < 0 {
+		return start, n
+	}
+	return start, min(start+size, n)
+}
+
+func (s *Server) identity(w http.ResponseWriter, r *http.Request) {
+	writeXML(w, mediaContainer{MachineIdentifier: s.machineIdentifier})
+}
+
+func (s *Server) listAccounts(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Plex always lists a nameless system account
+	container := mediaContainer{Accounts: []account{{ID: 0, Key: "/accounts/0"}}}
+	for _, a := range s.accounts {
+		container.Accounts = append(container.Accounts, account{ID: a.ID, Key: "/accounts/" + strconv.Itoa(a.ID), Name: a.Name})
+	}
+	writeXML(w, container)
+}
+
+func (s *Server) listSections(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var container mediaContainer
+	for _, section := range s.sections {
+		container.Directories = append(container.Directories, directory{
+			Key:       section.Key,
+			Type:      section.Type,
+			Title:     section.Title,
+			UpdatedAt: section.UpdatedAt,
+		})
+	}
+	writeXML(w, container)
+}
+
+// section returns the section with the provided key.
+func (s *Server) section(key string) (*Section, bool) {
+	for i := range s.sections {
+		if s.sections[i].Key == key {
+			return &s.sections[i], true
+		}
+	}
+	return nil, false
+}
+
+func (s *Server) sectionAll(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	section, ok := s.section(r.PathValue("section"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// music sections list their albums when asked for type 9
+	if r.URL.Query().Get("type") == "9" {
+		var albums []directory
+		for _, artist := range section.Items {
+			for _, album := range artist.Albums {
+				albums = append(albums, albumDirectory(artist, album))
+			}
+		}
+		total := len(albums)
+		start, end := pageBounds(r, total)
+		writeXML(w, mediaContainer{TotalSize: &total, Directories: albums[start:end]})
+		return
+	}
+	total := len(section.Items)
+	start, end := pageBounds(r, total)
+	container := mediaContainer{TotalSize: &total}
+	for _, item := range section.Items[start:end] {
+		item.render(&container)
+	}
+	writeXML(w, container)
+}
+
+// lookup finds the section and item a rating key belongs to,
+// along with the episode if the key is for one.
+func (s *Server) lookup(ratingKey int) (*Section, *Item, *Episode, bool) {
+	for i := range s.sections {
+		section := &s.sections[i]
+		for j := range section.Items {
+			item := &section.Items[j]
+			if item.RatingKey == ratingKey {
+				return section, item, nil, true
+			}
+			for k := range item.Episodes {
+				if item.Episodes[k].RatingKey == ratingKey {
+					return section, item, &item.Episodes[k], true
+				}
+			}
+		}
+	}
+	return nil, nil, nil, false
+}
+
+// lookupMusic finds the section, artist and album a rating key
+// for an album or a track belongs to, along with the track if
+// the key is for one.
+func (s *Server) lookupMusic(ratingKey int) (*Section, *Item, *Album, *Track, bool) {
+	for i := range s.sections {
+		section := &s.sections[i]
+		for j := range section.Items {
+			artist := &section.Items[j]
+			for k := range artist.Albums {
+				album := &artist.Albums[k]
+				if album.RatingKey == ratingKey {
+					return section, artist, album, nil, true
+				}
+				for l := range album.Tracks {
+					if album.Tracks[l].RatingKey == ratingKey {
+						return section, artist, album, &album.Tracks[l], true
+					}
+				}
+			}
+		}
+	}
+	return nil, nil, nil, nil, false
+}
+
+func (s *Server) metadata(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ratingKey, err := strconv.Atoi(r.PathValue("ratingKey"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var container mediaContainer
+	section, item, episode, ok := s.lookup(ratingKey)
+	if !ok {
+		section, artist, album, t, ok := s.lookupMusic(ratingKey)
+		if ok {
+			container.LibrarySectionID = section.Key
+		}
+		switch {
+		case !ok:
+			http.NotFound(w, r)
+			return
+		case t != nil:
+			container.Tracks = append(container.Tracks, trackElement(*artist, *album, *t))
+		default:
+			container.Directories = append(container.Directories, albumDirectory(*artist, *album))
+		}
+		writeXML(w, container)
+		return
+	}
+	container.LibrarySectionID = section.Key
+	if episode != nil {
+		container.Videos = append(container.Videos, episodeVideo(*item, *episode))
+	} else {
+		item.render(&container)
+	}
+	writeXML(w, container)
+}
+
+// writeImage responds with a stand in for an item's image,
+// whose body names the image and the size it was resized to.
+func (s *Server) writeImage(w http.ResponseWriter, r *http.Request, ratingKey, kind, size string) {
+	key, err := strconv.Atoi(ratingKey)
+	if err != nil || (kind != "thumb" && kind != "art") {
+		http.NotFound(w, r)
+		return
+	}
+	_, _, _, ok := s.lookup(key)
+	if !ok {
+		_, _, _, _, ok = s.lookupMusic(key)
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write([]byte(kind + " of " + ratingKey + size))
+}
+
+func (s *Server) image(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeImage(w, r, r.PathValue("ratingKey"), r.PathValue("kind"), "")
+}
+
+// transcodeImage serves the image at the url parameter
+// resized to the requested width and height.
+func (s *Server) transcodeImage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query := r.URL.Query()
+	path, ok := strings.CutPrefix(query.Get("url"), "/library/metadata/")
+	if !ok || query.Get("width") == "" || query.Get("height") == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	// the path may be versioned with a timestamp
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	s.writeImage(w, r, parts[0], parts[1], " at "+query.Get("width")+"x"+query.Get("height"))
+}
+
+// sortedPlays returns the watch history, most recent first.
+func (s *Server) sortedPlays() []Play {
+	plays := slices.Clone(s.plays)
+	slices.SortStableFunc(plays, func(a, b Play) int {
+		return b.ViewedAt.Compare(a.ViewedAt)
+	})
+	return plays
+}
+
+func (s *Server) recentlyViewed(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sectionKey := r.PathValue("section")
+	if _, ok := s.section(sectionKey); !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var container mediaContainer
+	seen := make(map[int]bool)
+	for _, play := range s.sortedPlays() {
+		if seen[play.RatingKey] {
+			continue
+		}
+		if section, artist, album, t, ok := s.lookupMusic(play.RatingKey); ok && t != nil {
+			if section.Key == sectionKey {
+				seen[play.RatingKey] = true
+				played := trackElement(*artist, *album, *t)
+				played.LastViewedAt = play.ViewedAt.Unix()
+				container.Tracks = append(container.Tracks, played)
+			}
+			continue
+		}
+		section, item, episode, ok := s.lookup(play.RatingKey)
+		if !ok || section.Key != sectionKey {
+			continue
+		}
+		seen[play.RatingKey] = true
+		var v video
+		if episode != nil {
+			v = episodeVideo(*item, *episode)
+		} else {
+			v = movieVideo(*item)
+		}
+		v.LastViewedAt = play.ViewedAt.Unix()
+		container.Videos = append(container.Videos, v)
+	}
+	start, end := pageBounds(r, len(container.Videos))
+	container.Videos = container.Videos[start:end]
+	start, end = pageBounds(r, len(container.Tracks))
+	container.Tracks = container.Tracks[start:end]
+	writeXML(w, container)
+}
+
+// history serves the watch history, which Plex filters by
+// section, account and viewedAt. Entries only carry the keys
+// and titles of what was played.
+func (s *Server) history(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query := r.URL.Query()
+	accountID, _ := strconv.Atoi(query.Get("accountID"))
+	after, _ := strconv.ParseInt(query.Get("viewedAt>"), 10, 64)
+	before, _ := strconv.ParseInt(query.Get("viewedAt<"), 10, 64)
+
+	// an entry is either a video or a track
+	type entry struct {
+		video *video
+		track *track
+	}
+	var entries []entry
+	for i, play := range s.sortedPlays() {
+		viewedAt := play.ViewedAt.Unix()
+		keep := func(sectionKey string) bool {
+			switch {
+			case query.Has("librarySectionID") && query.Get("librarySectionID") != sectionKey,
+				accountID != 0 && play.AccountID != accountID,
+				after != 0 && viewedAt <= after,
+				before != 0 && viewedAt >= before:
+				return false
+			}
+			return true
+		}
+		historyKey := "/status/sessions/history/" + strconv.Itoa(i+1)
+
+		if section, artist, album, t, ok := s.lookupMusic(play.RatingKey); ok && t != nil {
+			if keep(section.Key) {
+				entries = append(entries, entry{track: &track{
+					HistoryKey:       historyKey,
+					RatingKey:        play.RatingKey,
+					Key:              metadataKey(play.RatingKey),
+					Type:             "track",
+					Title:            t.Title,
+					ViewedAt:         viewedAt,
+					AccountID:        play.AccountID,
+					LibrarySectionID: section.Key,
+					ParentKey:        metadataKey(album.RatingKey),
+					ParentTitle:      album.Title,
+					GrandparentKey:   metadataKey(artist.RatingKey),
+					GrandparentTitle: artist.Title,
+				}})
+			}
+			continue
+		}
+		section, item, episode, ok := s.lookup(play.RatingKey)
+		if !ok || !keep(section.Key) {
+			continue
+		}
+		v := &video{
+			HistoryKey:       historyKey,
+			RatingKey:        play.RatingKey,
+			Key:              metadataKey(play.RatingKey),
+			Type:             "movie",
+			Title:            item.Title,
+			ViewedAt:         viewedAt,
+			AccountID:        play.AccountID,
+			LibrarySectionID: section.Key,
+		}
+		if episode != nil {
+			v.Type = "episode"
+			v.Title = episode.Title
+			v.GrandparentKey = metadataKey(item.RatingKey)
+			v.GrandparentTitle = item.Title
+		}
+		entries = append(entries, entry{video: v})
+	}
+
+	total := len(entries)
+	start, end := pageBounds(r, total)
+	container := mediaContainer{TotalSize: &total}
+	for _, e := range entries[start:end] {
+		if e.track != nil {
+			container.Tracks = append(container.Tracks, *e.track)
+			continue
+		}
+		container.Videos = append(container.Videos, *e.video)
+	}
+	writeXML(w, container)
+}
+
+func (p *Playlist) render() playlist {
+	return playlist{
+		RatingKey:    p.RatingKey,
+		Key:          "/playlists/" + strconv.Itoa(p.RatingKey) + "/items",
+		Type:         "playlist",
+		Title:        p.Title,
+		Smart:        "0",
+		PlaylistType: "video",
+		LeafCount:    len(p.Items),
+	}
+}
+
+func (s *Server) listPlaylists(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var container mediaContainer
+	for _, p := range s.playlists {
+		if p.AccountID == s.accountID(r) {
+			container.Playlists = append(container.Playlists, p.render())
+		}
+	}
+	writeXML(w, container)
+}
+
+// playlist returns the playlist with the rating key in the
+// path, if it belongs to the account making the request.
+func (s *Server) playlist(r *http.Request) (*Playlist, bool) {
+	ratingKey, err := strconv.Atoi(r.PathValue("playlist"))
+	if err != nil {
+		return nil, false
+	}
+	for _, p := range s.playlists {
+		if p.RatingKey == ratingKey && p.AccountID == s.accountID(r) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// playableItems resolves the server://.../library/metadata/1,2
+// URI of items to add to a playlist. Shows are expanded to their
+// episodes the way Plex does.
+func (s *Server) playableItems(uri string) ([]int, bool) {
+	_, keys, ok := strings.Cut(uri, "/library/metadata/")
+	if !ok || !strings.HasPrefix(uri, "server://"+s.machineIdentifier+"/") {
+		return nil, false
+	}
+	var items []int
+	for _, key := range strings.Split(keys, ",") {
+		ratingKey, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, false
+		}
+		_, item, episode, ok := s.lookup(ratingKey)
+		switch {
+		case !ok:
+			return nil, false
+		case episode == nil && item.isShow():
+			for _, e := range item.Episodes {
+				items = append(items, e.RatingKey)
+			}
+		default:
+			items = append(items, ratingKey)
+		}
+	}
+	return items, true
+}
+
+func (s *Server) createPlaylist(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query := r.URL.Query()
+	items, ok := s.playableItems(query.Get("uri"))
+	if !ok || query.Get("title") == "" || query.Get("type") != "video" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	p := &Playlist{RatingKey: s.nextRatingKey, Title: query.Get("title"), AccountID: s.accountID(r), Items: items}
+	s.nextRatingKey++
+	s.playlists = append(s.playlists, p)
+	writeXML(w, mediaContainer{Playlists: []playlist{p.render()}})
+}
+
+func (s *Server) playlistItems(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlist(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var container mediaContainer
+	for _, ratingKey := range p.Items {
+		_, item, episode, ok := s.lookup(ratingKey)
+		switch {
+		case !ok:
+			continue
+		case episode != nil:
+			container.Videos = append(container.Videos, episodeVideo(*item, *episode))
+		default:
+			container.Videos = append(container.Videos, movieVideo(*item))
+		}
+	}
+	writeXML(w, container)
+}
+
+func (s *Server) addPlaylistItems(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlist(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	items, ok := s.playableItems(r.URL.Query().Get("uri"))
+	if !ok {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	p.Items = append(p.Items, items...)
+	writeXML(w, mediaContainer{Playlists: []playlist{p.render()}})
+}
+
+func (s *Server) clearPlaylistItems(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlist(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	p.Items = nil
+	writeXML(w, mediaContainer{Playlists: []playlist{p.render()}})
+}
+
+func (s *Server) deletePlaylist(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlist(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.playlists = slices.DeleteFunc(s.playlists, func(other *Playlist) bool {
+		return other == p
+	})
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,151 @@
+package plex
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Retry and circuit breaker defaults, used unless
+// overridden with client options.
+const (
+	defaultMaxRetries       = 3
+	defaultBaseDelay        = 200 * time.Millisecond
+	defaultMaxDelay         = 3 * time.Second
+	defaultFailureThreshold = 5
+	defaultCooldown         = 30 * time.Second
+)
+
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+// backoff returns how long to wait before the retry following
+// attempt, counted from zero. The delay grows exponentially and
+// is jittered across its whole range so that many waiting
+// requests don't hit a recovering server at the same moment.
+// A server provided Retry-After is honoured up to maxDelay.
+func (p retryPolicy) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, p.maxDelay)
+	}
+	delay := p.baseDelay << attempt
+	if delay <= 0 || delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	return rand.N(delay) + 1
+}
+
+// isIdempotent reports whether a request with the method can be
+// sent again without side effects if the first attempt failed.
+// PUT is left out because Plex uses it to add to things, such as
+// the items of a playlist, which a retry would add twice.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isTransient reports whether err is likely to go away if the
+// request is retried, such as a dropped connection, a server that
+// stopped responding or an overloaded server. It is also what
+// counts against the circuit breaker. Errors caused by the caller
+// cancelling ctx aren't transient, but a request timing out on its
+// own is, even though that error is also a deadline exceeded.
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	// a certificate that fails verification won't
+	// start passing on the next attempt
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// retryAfter parses the delay from a Retry-After header given
+// in seconds. Zero is returned if there isn't one.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// circuitBreaker stops requests to a Plex server that keeps
+// failing. After threshold consecutive failures it opens and
+// rejects requests until cooldown has passed, then lets a single
+// trial request through. The breaker closes again if the trial
+// succeeds and reopens if it fails.
+type circuitBreaker struct {
+	mu            sync.Mutex
+	threshold     int
+	cooldown      time.Duration
+	failures      int
+	openedAt      time.Time
+	trialInFlight bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow reports whether a request may be sent, and whether it's
+// the trial request let through once the cooldown has passed. The
+// result of the request must be recorded with the same trial.
+func (b *circuitBreaker) allow() (ok, trial bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true, false
+	}
+	if time.Since(b.openedAt) < b.cooldown || b.trialInFlight {
+		return false, false
+	}
+	b.trialInFlight = true
+	return true, true
+}
+
+// record updates the breaker with the outcome of a request made
+// with ctx. Only transient failures count against the server. Any
+// other response, even an error like not found, shows it's up. A
+// request the caller cancelled says nothing either way. Another
+// trial is only let through once the trial request is recorded,
+// not when a request sent before the breaker opened finishes.
+func (b *circuitBreaker) record(ctx context.Context, trial bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if trial {
+		b.trialInFlight = false
+	}
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	if err == nil || !isTransient(ctx, err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,228 @@
+package plex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newStatusServer responds with each status in turn, repeating
+// the last one once they run out.
+func newStatusServer(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(calls.Add(1)) - 1
+		if i >= len(statuses) {
+			i = len(statuses) - 1
+		}
+		w.WriteHeader(statuses[i])
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestMakeNetworkRequestStatusErrors(t *testing.T) {
+	testCases := []struct {
+		name          string
+		status        int
+		method        string
+		expected      error
+		expectedCalls int32
+	}{
+		{name: "Unauthorized", status: http.StatusUnauthorized, method: http.MethodGet, expected: ErrUnauthorized, expectedCalls: 1},
+		{name: "Not Found", status: http.StatusNotFound, method: http.MethodGet, expected: ErrNotFound, expectedCalls: 1},
+		{name: "Server Error Retried", status: http.StatusServiceUnavailable, method: http.MethodGet, expected: ErrServer, expectedCalls: 3},
+		{name: "Server Error Not Retried For POST", status: http.StatusInternalServerError, method: http.MethodPost, expected: ErrServer, expectedCalls: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, calls := newStatusServer(t, tc.status)
+			c := New("randomToken", server.URL, "1", WithRetryPolicy(2, time.Millisecond, 5*time.Millisecond))
+			_, err := c.MakeNetworkRequest(context.Background(), c.Connect(), tc.method)
+			if !errors.Is(err, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, err)
+			}
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != tc.status {
+				t.Errorf("expected a StatusError with status %d, got %v", tc.status, err)
+			}
+			if calls.Load() != tc.expectedCalls {
+				t.Errorf("expected %d calls, got %d", tc.expectedCalls, calls.Load())
+			}
+		})
+	}
+}
+
+func TestMakeNetworkRequestRecovers(t *testing.T) {
+	server, calls := newStatusServer(t, http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK)
+	c := New("randomToken", server.URL, "1", WithRetryPolicy(3, time.Millisecond, 5*time.Millisecond))
+	resp, err := c.MakeNetworkRequest(context.Background(), c.Connect(), http.MethodGet)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 calls, got %d", calls.Load())
+	}
+}
+
+func TestMakeNetworkRequestHonoursContext(t *testing.T) {
+	server, calls := newStatusServer(t, http.StatusServiceUnavailable)
+	c := New("randomToken", server.URL, "1", WithRetryPolicy(5, time.Hour, time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.MakeNetworkRequest(ctx, c.Connect(), http.MethodGet)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected cancellation to stop retries, took %v", elapsed)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call, got %d", calls.Load())
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	server, calls := newStatusServer(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK)
+	c := New("randomToken", server.URL, "1",
+		WithRetryPolicy(0, time.Millisecond, time.Millisecond),
+		WithCircuitBreaker(2, 50*time.Millisecond),
+	)
+	request := func() error {
+		resp, err := c.MakeNetworkRequest(context.Background(), c.Connect(), http.MethodGet)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := request(); !errors.Is(err, ErrServer) {
+			t.Fatalf("expected server error, got %v", err)
+		}
+	}
+
+	if err := request(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected the open circuit to skip Plex, got %d calls", calls.Load())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := request(); err != nil {
+		t.Fatalf("expected the trial request to succeed, got %v", err)
+	}
+	if err := request(); err != nil {
+		t.Fatalf("expected a closed circuit, got %v", err)
+	}
+}
+
+func TestCircuitBreakerLetsOneTrialThrough(t *testing.T) {
+	b := newCircuitBreaker(1, time.Millisecond)
+	// a request is sent while the breaker is closed,
+	// then another fails and opens it
+	if ok, trial := b.allow(); !ok || trial {
+		t.Fatalf("expected a closed breaker to allow a normal request, got %v, %v", ok, trial)
+	}
+	b.record(context.Background(), false, ErrServer)
+	time.Sleep(5 * time.Millisecond)
+
+	if ok, trial := b.allow(); !ok || !trial {
+		t.Fatalf("expected a trial once the cooldown passed, got %v, %v", ok, trial)
+	}
+	// the request sent before the breaker opened finishes,
+	// cancelled by its caller, while the trial is in flight
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.record(cancelled, false, context.Canceled)
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := b.allow(); ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed.Load() != 0 {
+		t.Errorf("expected nothing else through while the trial is in flight, got %d", allowed.Load())
+	}
+
+	b.record(context.Background(), true, nil)
+	if ok, trial := b.allow(); !ok || trial {
+		t.Errorf("expected the breaker to close after the trial succeeded, got %v, %v", ok, trial)
+	}
+}
+
+func TestTimeoutsCountAgainstPlex(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// hang until the client gives up
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	c := New("randomToken", server.URL, "1",
+		WithRetryPolicy(1, time.Millisecond, time.Millisecond),
+		WithCircuitBreaker(2, time.Minute),
+	)
+	c.httpClient.Timeout = 20 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		_, err := c.MakeNetworkRequest(context.Background(), c.Connect(), http.MethodGet)
+		if err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected the request to time out, got %v", err)
+		}
+	}
+	if calls.Load() != 4 {
+		t.Errorf("expected timed out requests to be retried, got %d calls", calls.Load())
+	}
+	if _, err := c.MakeNetworkRequest(context.Background(), c.Connect(), http.MethodGet); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected timeouts to open the circuit, got %v", err)
+	}
+}
+
+func TestPutIsNotRetried(t *testing.T) {
+	server, calls := newStatusServer(t, http.StatusServiceUnavailable)
+	c := New("randomToken", server.URL, "1", WithRetryPolicy(2, time.Millisecond, 5*time.Millisecond))
+	if _, err := c.MakeNetworkRequest(context.Background(), c.Connect(), http.MethodPut); !errors.Is(err, ErrServer) {
+		t.Errorf("expected server error, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call, got %d", calls.Load())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := retryPolicy{maxRetries: 5, baseDelay: 100 * time.Millisecond, maxDelay: time.Second}
+	for attempt := 0; attempt < 8; attempt++ {
+		limit := min(policy.baseDelay<<attempt, policy.maxDelay)
+		for i := 0; i < 20; i++ {
+			if delay := policy.backoff(attempt, 0); delay <= 0 || delay > limit {
+				t.Fatalf("attempt %d: delay %v outside (0, %v]", attempt, delay, limit)
+			}
+		}
+	}
+
+	if delay := policy.backoff(0, 500*time.Millisecond); delay != 500*time.Millisecond {
+		t.Errorf("expected Retry-After to be honoured, got %v", delay)
+	}
+	if delay := policy.backoff(0, time.Hour); delay != policy.maxDelay {
+		t.Errorf("expected Retry-After to be capped, got %v", delay)
+	}
+}
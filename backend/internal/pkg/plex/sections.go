@@ -0,0 +1,130 @@
+package plex
+
+import (
+	"context"
+	"encoding/xml"
+	"log"
+	"slices"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Section is a Plex library section, such as
+// a Movies or TV Shows library.
+type Section struct {
+	XMLName   xml.Name `xml:"Directory"`
+	Key       string   `xml:"key,attr"`
+	Type      string   `xml:"type,attr"`
+	Title     string   `xml:"title,attr"`
+	UpdatedAt int64    `xml:"updatedAt,attr"`
+}
+
+// SectionSummary describes a library section and
+// how many items it holds.
+type SectionSummary struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Type      string `json:"type"`
+	ItemCount int    `json:"item_count"`
+}
+
+type sectionsContainer struct {
+	XMLName  xml.Name  `xml:"MediaContainer"`
+	Sections []Section `xml:"Directory"`
+}
+
+// ingestibleTypes are the section types we can
+// store and recommend from.
+var ingestibleTypes = []string{movieType, showType, artistType}
+
+// IsMusic reports whether the section is a music library.
+func (s Section) IsMusic() bool {
+	return s.Type == artistType
+}
+
+// GetLibrarySections lists every library section
+// on the Plex server.
+func GetLibrarySections(ctx context.Context, c Client) ([]Section, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetLibrarySections"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	log.Println("getting library sections...")
+	var container sectionsContainer
+	if err := getXML(ctx, c, c.Connect(WithPath("/library/sections")), &container); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("count", len(container.Sections)))
+	span.SetStatus(codes.Ok, "sections retrieved")
+	return container.Sections, nil
+}
+
+// GetSectionItemCount returns the number of top level items, movies
+// or shows, in the section. Plex reports the total size of the
+// section even when it's asked for an empty page, so only the
+// count is transferred.
+func GetSectionItemCount(ctx context.Context, c Client, sectionId string) (int, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetSectionItemCount"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.String("section", sectionId))
+	var container MediaContainer
+	uri := c.Connect(
+		WithSectionID(sectionId),
+		WithAllMovies(allMovies),
+		WithQuery("X-Plex-Container-Start", "0"),
+		WithQuery("X-Plex-Container-Size", "0"),
+	)
+	if err := getXML(ctx, c, uri, &container); err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+	span.SetStatus(codes.Ok, "count retrieved")
+	return container.TotalSize, nil
+}
+
+// GetSectionSummaries lists every library section on the Plex
+// server along with the number of items in each.
+func GetSectionSummaries(ctx context.Context, c Client) ([]SectionSummary, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("GetSectionSummaries"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	sections, err := GetLibrarySections(ctx, c)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	summaries := make([]SectionSummary, 0, len(sections))
+	for _, section := range sections {
+		count, err := GetSectionItemCount(ctx, c, section.Key)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		summaries = append(summaries, SectionSummary{
+			ID:        section.Key,
+			Title:     section.Title,
+			Type:      section.Type,
+			ItemCount: count,
+		})
+	}
+	span.SetStatus(codes.Ok, "summaries retrieved")
+	return summaries, nil
+}
+
+// FilterSections returns the sections that hold media we can
+// recommend. If allowList is not empty, only sections whose
+// key is in the allow list are returned.
+func FilterSections(sections []Section, allowList []string) []Section {
+	filtered := make([]Section, 0, len(sections))
+	for _, section := range sections {
+		if !slices.Contains(ingestibleTypes, section.Type) {
+			continue
+		}
+		if len(allowList) > 0 && !slices.Contains(allowList, section.Key) {
+			continue
+		}
+		filtered = append(filtered, section)
+	}
+	return filtered
+}
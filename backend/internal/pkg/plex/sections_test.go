@@ -0,0 +1,98 @@
+package plex
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestSectionsContainer(t *testing.T) {
+	body := `<MediaContainer size="3" allowSync="0" title1="Plex Library">
+	<Directory key="1" type="movie" title="Movies" agent="tv.plex.agents.movie" updatedAt="1716000000"><Location id="1" path="/movies"/></Directory>
+	<Directory key="2" type="show" title="TV Shows" updatedAt="1716000001"/>
+	<Directory key="3" type="artist" title="Music" updatedAt="1716000002"/>
+</MediaContainer>`
+
+	var container sectionsContainer
+	if err := xml.Unmarshal([]byte(body), &container); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(container.Sections) != 3 {
+		t.Fatalf("expected 3 sections, got %d", len(container.Sections))
+	}
+	got := container.Sections[0]
+	if got.Key != "1" || got.Type != movieType || got.Title != "Movies" || got.UpdatedAt != 1716000000 {
+		t.Errorf("unexpected section: %+v", got)
+	}
+}
+
+func TestFilterSections(t *testing.T) {
+	sections := []Section{
+		{Key: "1", Type: movieType},
+		{Key: "2", Type: showType},
+		{Key: "3", Type: "artist"},
+		{Key: "4", Type: movieType},
+		{Key: "5", Type: "photo"},
+	}
+
+	testCases := []struct {
+		name      string
+		allowList []string
+		expected  []string
+	}{
+		{
+			name:     "No Allow List",
+			expected: []string{"1", "2", "3", "4"},
+		},
+		{
+			name:      "With Allow List",
+			allowList: []string{"2", "3", "5"},
+			expected:  []string{"2", "3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := FilterSections(sections, tc.allowList)
+			keys := make([]string, 0, len(result))
+			for _, section := range result {
+				keys = append(keys, section.Key)
+			}
+			if !reflect.DeepEqual(keys, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, keys)
+			}
+		})
+	}
+}
+
+func TestGetSectionSummaries(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/library/sections",
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="2">
+	<Directory key="1" type="movie" title="Movies"/>
+	<Directory key="2" type="show" title="TV Shows"/>
+</MediaContainer>`))
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/library/sections/1/all",
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="0" totalSize="42"/>`))
+	httpmock.RegisterResponder(http.MethodGet, "http://localhost:32400/library/sections/2/all",
+		httpmock.NewStringResponder(http.StatusOK, `<MediaContainer size="0" totalSize="7"/>`))
+
+	summaries, err := GetSectionSummaries(context.Background(), New("randomToken", "localhost", "1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []SectionSummary{
+		{ID: "1", Title: "Movies", Type: movieType, ItemCount: 42},
+		{ID: "2", Title: "TV Shows", Type: showType, ItemCount: 7},
+	}
+	if !reflect.DeepEqual(summaries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, summaries)
+	}
+}
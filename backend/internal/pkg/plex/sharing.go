@@ -0,0 +1,108 @@
+package plex
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// ownerAccountID is the ID a Plex server gives
+// the account that owns it.
+const ownerAccountID = 1
+
+// sharedServersContainer lists the accounts
+// a Plex server is shared with.
+type sharedServersContainer struct {
+	XMLName       xml.Name       `xml:"MediaContainer"`
+	SharedServers []sharedServer `xml:"SharedServer"`
+}
+
+type sharedServer struct {
+	UserID      int    `xml:"userID,attr"`
+	AccessToken string `xml:"accessToken,attr"`
+}
+
+// homeUser is a Plex Home member plex.tv has switched to.
+type homeUser struct {
+	XMLName             xml.Name `xml:"user"`
+	ID                  int      `xml:"id,attr"`
+	AuthenticationToken string   `xml:"authenticationToken,attr"`
+}
+
+// AccountToken looks up the token the account with accountID uses
+// on the Plex server with machineIdentifier. Plex only tells the
+// account that owns the server, so ownerToken must be its token.
+// Accounts the server is shared with are looked up first. Members
+// of the owner's Plex Home aren't always listed there, so any other
+// account is switched to as a Home member instead, which only works
+// for members without a PIN.
+func (pc *PinClient) AccountToken(ctx context.Context, ownerToken, machineIdentifier string, accountID int) (string, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("AccountToken"), telemetry.WithSpanPackage("plex"))
+	defer span.End()
+	span.SetAttributes(attribute.Int("accountID", accountID))
+	var container sharedServersContainer
+	path := "/api/servers/" + machineIdentifier + "/shared_servers"
+	err := pc.call(ctx, http.MethodGet, path, ownerToken, "application/xml", func(body io.Reader) error {
+		return xml.NewDecoder(body).Decode(&container)
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+	for _, shared := range container.SharedServers {
+		if shared.UserID == accountID && shared.AccessToken != "" {
+			span.SetStatus(codes.Ok, "account token found")
+			return shared.AccessToken, nil
+		}
+	}
+	span.AddEvent("not shared, trying Plex Home")
+
+	var user homeUser
+	path = "/api/home/users/" + strconv.Itoa(accountID) + "/switch"
+	err = pc.call(ctx, http.MethodPost, path, ownerToken, "application/xml", func(body io.Reader) error {
+		return xml.NewDecoder(body).Decode(&user)
+	})
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusForbidden) {
+		err = fmt.Errorf("plex server is not shared with account %d, and it isn't a Plex Home member without a PIN", accountID)
+	}
+	if err == nil && user.AuthenticationToken == "" {
+		err = fmt.Errorf("plex didn't provide a token for Plex Home member %d", accountID)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+	span.SetStatus(codes.Ok, "home user token found")
+	return user.AuthenticationToken, nil
+}
+
+// AsAccount returns a client for the same Plex server that acts as
+// the account with accountID, so what it creates, such as playlists,
+// belongs to that account. c must use the token of the account that
+// owns the server, and is returned as it is for that account. Any
+// other account's token is looked up with tv.
+func AsAccount(ctx context.Context, c *PlexClient, tv *PinClient, accountID int) (*PlexClient, error) {
+	if accountID == ownerAccountID {
+		return c, nil
+	}
+	machineIdentifier, err := GetMachineIdentifier(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	token, err := tv.AccountToken(ctx, c.accessToken, machineIdentifier, accountID)
+	if err != nil {
+		return nil, err
+	}
+	as := *c
+	as.accessToken = token
+	return &as, nil
+}
@@ -0,0 +1,61 @@
+package plex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex/plextest"
+)
+
+func TestAsAccount(t *testing.T) {
+	server := plextest.NewServer(
+		plextest.WithSection(plextest.Section{Key: "1", Type: movieType, Title: "Movies", Items: []plextest.Item{
+			{RatingKey: 20, Guid: "plex://movie/a", Title: "Movie A"},
+		}}),
+		plextest.WithAccount(1, "owner"),
+		plextest.WithAccount(2, "Kid"),
+		plextest.WithAccount(3, "Partner"),
+		plextest.WithAccountToken(2, "kid-token"),
+		plextest.WithAccountToken(3, "partner-token"),
+	)
+	defer server.Close()
+	tv := plextest.NewPlexTV()
+	defer tv.Close()
+	tv.Share(plextest.DefaultMachineIdentifier, server.Token(), 2, "kid-token")
+	// a Plex Home member the server isn't shared with directly
+	tv.AddHomeUser(server.Token(), 3, "partner-token")
+	owner := New(server.Token(), server.URL, "1")
+	pins := NewPinClient(WithPlexTVURL(tv.URL))
+
+	same, err := AsAccount(context.Background(), owner, pins, ownerAccountID)
+	if err != nil || same != owner {
+		t.Fatalf("expected the owner's client, got %v, %v", same, err)
+	}
+
+	kid, err := AsAccount(context.Background(), owner, pins, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := SetPlaylist(context.Background(), kid, "Recommended for Kid", []int{20}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	playlists := server.Playlists()
+	if len(playlists) != 1 || playlists[0].AccountID != 2 {
+		t.Errorf("expected a playlist belonging to the kid, got %+v", playlists)
+	}
+
+	partner, err := AsAccount(context.Background(), owner, pins, 3)
+	if err != nil {
+		t.Fatalf("unexpected error for a Plex Home member: %v", err)
+	}
+	if _, err := SetPlaylist(context.Background(), partner, "Recommended for Partner", []int{20}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if playlists := server.Playlists(); len(playlists) != 2 || playlists[1].AccountID != 3 {
+		t.Errorf("expected a playlist belonging to the Home member, got %+v", playlists)
+	}
+
+	if _, err := AsAccount(context.Background(), owner, pins, 4); err == nil {
+		t.Error("expected an error for an account the server isn't shared with")
+	}
+}
@@ -0,0 +1,72 @@
+package plex
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Webhook events we act on. Plex sends many more, such as
+// media.play and media.pause, which are ignored.
+const (
+	WebhookLibraryNew    = "library.new"
+	WebhookMediaScrobble = "media.scrobble"
+)
+
+// WebhookPayload is the JSON document Plex sends in the
+// payload field of its multipart webhook requests.
+type WebhookPayload struct {
+	Event    string          `json:"event"`
+	Account  WebhookAccount  `json:"Account"`
+	Metadata WebhookMetadata `json:"Metadata"`
+}
+
+type WebhookAccount struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+// WebhookMetadata describes the media a webhook event is
+// about. Plex sends rating keys as strings.
+type WebhookMetadata struct {
+	LibrarySectionType   string `json:"librarySectionType"`
+	LibrarySectionID     int    `json:"librarySectionID"`
+	RatingKey            string `json:"ratingKey"`
+	ParentRatingKey      string `json:"parentRatingKey"`
+	GrandparentRatingKey string `json:"grandparentRatingKey"`
+	Guid                 string `json:"guid"`
+	Type                 string `json:"type"`
+	Title                string `json:"title"`
+}
+
+// DecodeWebhook parses the payload field of a Plex webhook.
+func DecodeWebhook(payload []byte) (*WebhookPayload, error) {
+	var p WebhookPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// SectionID returns the library section the media belongs to.
+func (m WebhookMetadata) SectionID() string {
+	return strconv.Itoa(m.LibrarySectionID)
+}
+
+// MediaRatingKey returns the rating key of the movie, show or
+// album the event is about. Events for episodes and seasons resolve
+// to the show they belong to, and events for tracks to their album,
+// since that's what we store and recommend.
+func (m WebhookMetadata) MediaRatingKey() int {
+	key := m.RatingKey
+	switch m.Type {
+	case episodeType:
+		key = m.GrandparentRatingKey
+	case "season", trackType:
+		key = m.ParentRatingKey
+	}
+	ratingKey, err := strconv.Atoi(key)
+	if err != nil {
+		return 0
+	}
+	return ratingKey
+}
@@ -0,0 +1,83 @@
+package plex
+
+import (
+	"testing"
+)
+
+func TestDecodeWebhook(t *testing.T) {
+	payload := `{
+		"event": "media.scrobble",
+		"user": true,
+		"owner": true,
+		"Account": {"id": 2, "thumb": "https://plex.tv/users/abc/avatar", "title": "Kid"},
+		"Server": {"title": "Home", "uuid": "abc123"},
+		"Metadata": {
+			"librarySectionType": "show",
+			"ratingKey": "12",
+			"parentRatingKey": "11",
+			"grandparentRatingKey": "10",
+			"guid": "plex://episode/pilot",
+			"librarySectionID": 2,
+			"type": "episode",
+			"title": "Pilot"
+		}
+	}`
+
+	p, err := DecodeWebhook([]byte(payload))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Event != WebhookMediaScrobble || p.Account.ID != 2 {
+		t.Errorf("unexpected payload: %+v", p)
+	}
+	if p.Metadata.SectionID() != "2" {
+		t.Errorf("expected section 2, got %s", p.Metadata.SectionID())
+	}
+}
+
+func TestWebhookMetadataMediaRatingKey(t *testing.T) {
+	testCases := []struct {
+		name     string
+		metadata WebhookMetadata
+		expected int
+	}{
+		{
+			name:     "Movie",
+			metadata: WebhookMetadata{Type: movieType, RatingKey: "20"},
+			expected: 20,
+		},
+		{
+			name:     "Show",
+			metadata: WebhookMetadata{Type: showType, RatingKey: "10"},
+			expected: 10,
+		},
+		{
+			name:     "Season Resolves To Show",
+			metadata: WebhookMetadata{Type: "season", RatingKey: "11", ParentRatingKey: "10"},
+			expected: 10,
+		},
+		{
+			name:     "Episode Resolves To Show",
+			metadata: WebhookMetadata{Type: episodeType, RatingKey: "12", ParentRatingKey: "11", GrandparentRatingKey: "10"},
+			expected: 10,
+		},
+		{
+			name:     "Track Resolves To Album",
+			metadata: WebhookMetadata{Type: trackType, RatingKey: "32", ParentRatingKey: "31", GrandparentRatingKey: "30"},
+			expected: 31,
+		},
+		{
+			name:     "Missing Key",
+			metadata: WebhookMetadata{Type: movieType},
+			expected: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.metadata.MediaRatingKey(); got != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package telemetry
+
+import (
+	"net/url"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// sensitiveParams are query parameters, compared without
+// case, whose values must never reach traces or logs.
+var sensitiveParams = []string{
+	"x-plex-token",
+	"token",
+	"api_key",
+	"apikey",
+	"password",
+	"secret",
+}
+
+// RedactURL returns the URL with credentials and secret query
+// parameter values replaced so it is safe to record in spans
+// and logs. Values that can't be parsed as a URL are redacted
+// entirely.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+
+	query := u.Query()
+	changed := false
+	for key := range query {
+		for _, sensitive := range sensitiveParams {
+			if strings.EqualFold(key, sensitive) {
+				query.Set(key, redacted)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
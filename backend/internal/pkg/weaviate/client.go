@@ -2,20 +2,31 @@ package weaviate
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/go-openapi/strfmt"
+	"github.com/google/uuid"
 	"github.com/tmc/langchaingo/llms/ollama"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/grpc"
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/config"
 	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex"
 
 	"github.com/weaviate/weaviate/entities/models"
@@ -23,9 +34,19 @@ import (
 
 var client *weaviate.Client
 
+// ErrNothingClose is returned by a vector query whose maximum
+// distance or autocut left nothing.
+var ErrNothingClose = errors.New("nothing stored is close enough to what was searched for")
+
 type queryOption struct {
-	className string
-	limit     int
+	className   string
+	limit       int
+	sectionID   string
+	server      string
+	weights     []float64
+	maxDistance float32
+	autocut     int
+	fallback    bool
 }
 
 type QueryOption func(*queryOption)
@@ -36,14 +57,67 @@ func WithClassName(s string) QueryOption {
 	}
 }
 
+// WithLimit sets how many objects are fetched at a time by
+// QueryData, and how many results a vector query returns.
 func WithLimit(i int) QueryOption {
 	return func(q *queryOption) {
 		q.limit = i
 	}
 }
 
+// WithSectionID restricts a vector query to videos
+// from the provided Plex library section.
+func WithSectionID(s string) QueryOption {
+	return func(q *queryOption) {
+		q.sectionID = s
+	}
+}
+
+// WithServer restricts a vector query to videos
+// from the named Plex server.
+func WithServer(s string) QueryOption {
+	return func(q *queryOption) {
+		q.server = s
+	}
+}
+
+// WithMaxDistance leaves results further than d from what's
+// searched for out of a vector query.
+func WithMaxDistance(d float32) QueryOption {
+	return func(q *queryOption) {
+		q.maxDistance = d
+	}
+}
+
+// WithAutocut cuts a vector query's results off after n jumps in
+// their distance, keeping only the closest groups of results.
+func WithAutocut(n int) QueryOption {
+	return func(q *queryOption) {
+		q.autocut = n
+	}
+}
+
+// WithCutoffFallback runs a vector query again without its maximum
+// distance and autocut when they leave nothing, rather than failing
+// it with ErrNothingClose.
+func WithCutoffFallback() QueryOption {
+	return func(q *queryOption) {
+		q.fallback = true
+	}
+}
+
+// WithWeights sets how much each of the vectors in a vector query
+// counts towards what is searched for. The vectors count equally
+// when no weights are given.
+func WithWeights(w []float64) QueryOption {
+	return func(q *queryOption) {
+		q.weights = w
+	}
+}
+
 type insertOption struct {
 	videos []plex.VideoShort
+	albums []plex.AlbumShort
 }
 
 type InsertOption func(*insertOption)
@@ -54,7 +128,108 @@ func WithVideos(v []plex.VideoShort) InsertOption {
 	}
 }
 
-func InitWeaviate(ctx context.Context, c plex.Client, embedder *ollama.LLM) error {
+// WithAlbums stores albums from a music library.
+func WithAlbums(a []plex.AlbumShort) InsertOption {
+	return func(i *insertOption) {
+		i.albums = a
+	}
+}
+
+// PlexServer is a Plex server whose media is stored.
+type PlexServer struct {
+	Name   string
+	Client plex.Client
+	// LibrarySections limits ingestion to these section IDs.
+	// All movie, show and music sections are ingested when empty.
+	LibrarySections []string
+}
+
+type initOption struct {
+	servers    []PlexServer
+	connection config.Weaviate
+	ingest     config.Ingest
+	full       bool
+	store      SyncStore
+}
+
+type InitOption func(*initOption)
+
+// newInitOption applies opts over the defaults. Retries are
+// left unset so the pipeline's default applies.
+func newInitOption(opts ...InitOption) *initOption {
+	options := &initOption{ingest: config.Ingest{Retries: -1}}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// WithPlexServer ingests the media on the provided Plex server.
+// Pass it once for each server. Media stored before servers were
+// named is assumed to be on the first one.
+func WithPlexServer(s PlexServer) InitOption {
+	return func(i *initOption) {
+		i.servers = append(i.servers, s)
+	}
+}
+
+// WithConnection connects to the Weaviate described by c
+// rather than the Weaviate in our docker compose.
+func WithConnection(c config.Weaviate) InitOption {
+	return func(i *initOption) {
+		i.connection = c
+	}
+}
+
+// clientConfig turns our connection settings into the Weaviate
+// client's. The API key is sent as a header rather than with the
+// client's auth config, which can't be combined with the HTTP
+// client that applies the request timeout.
+func clientConfig(c config.Weaviate) weaviate.Config {
+	cfg := weaviate.Config{
+		Host:           c.Host,
+		Scheme:         c.Scheme,
+		StartupTimeout: c.StartupTimeout,
+		Headers:        make(map[string]string, len(c.Headers)+1),
+	}
+	if cfg.Host == "" {
+		cfg.Host = "weaviate:8080"
+	}
+	if cfg.Scheme == "" {
+		cfg.Scheme = "http"
+	}
+	for name, value := range c.Headers {
+		cfg.Headers[name] = value
+	}
+	if c.APIKey != "" {
+		cfg.Headers["Authorization"] = "Bearer " + c.APIKey
+	}
+	if c.Timeout > 0 {
+		cfg.ConnectionClient = &http.Client{Timeout: c.Timeout}
+	}
+	if c.GRPCPort != "" {
+		host, _, err := net.SplitHostPort(cfg.Host)
+		if err != nil {
+			// no port on the host
+			host = cfg.Host
+		}
+		cfg.GrpcConfig = &grpc.Config{
+			Host:    net.JoinHostPort(host, c.GRPCPort),
+			Secured: cfg.Scheme == "https",
+		}
+	}
+	return cfg
+}
+
+// WithIngest sets how media is embedded and stored when
+// it's ingested on start up.
+func WithIngest(c config.Ingest) InitOption {
+	return func(i *initOption) {
+		i.ingest = c
+	}
+}
+
+func InitWeaviate(ctx context.Context, embedder *ollama.LLM, opts ...InitOption) error {
 	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Init Weaviate"), telemetry.WithSpanPackage("weaviate"))
 	defer span.End()
 	if client != nil {
@@ -62,10 +237,10 @@ func InitWeaviate(ctx context.Context, c plex.Client, embedder *ollama.LLM) erro
 		return nil
 	}
 
-	cfg := weaviate.Config{
-		Host:   "weaviate:8080",
-		Scheme: "http",
-	}
+	options := newInitOption(opts...)
+
+	cfg := clientConfig(options.connection)
+	span.SetAttributes(attribute.String("host", cfg.Host), attribute.String("scheme", cfg.Scheme))
 
 	var err error
 	client, err = weaviate.NewClient(cfg)
@@ -74,7 +249,7 @@ func InitWeaviate(ctx context.Context, c plex.Client, embedder *ollama.LLM) erro
 		return err
 	}
 
-	classesToCheck := []models.Class{VideoClass}
+	classesToCheck := []models.Class{VideoClass, AlbumClass}
 
 	for _, class := range classesToCheck {
 		if err := createSchemaIfNotExists(ctx, &class); err != nil {
@@ -83,7 +258,7 @@ func InitWeaviate(ctx context.Context, c plex.Client, embedder *ollama.LLM) erro
 		}
 	}
 
-	if err := insertPlexMedia(ctx, c, embedder); err != nil {
+	if err := Sync(ctx, embedder, opts...); err != nil {
 		span.RecordError(err)
 		return err
 	}
@@ -115,18 +290,39 @@ func InsertData(ctx context.Context, embedder *ollama.LLM, opts ...InsertOption)
 		}
 
 		for i, video := range options.videos {
+			properties := videoProperties(video)
+			properties["fingerprint"] = videoFingerprint(video)
 			data := &models.Object{
-				Class: videoCollectionName,
-				Properties: map[string]any{
-					"title":          video.Title,
-					"summary":        video.Summary,
-					"content_rating": video.ContentRating,
-				},
-				Vector: vectors[i],
+				Class:      videoCollectionName,
+				ID:         objectID(video.Server, video.SectionID, video.PlexID, video.RatingKey),
+				Properties: properties,
+				Vector:     vectors[i],
 			}
 			objs = append(objs, data)
 		}
 	}
+	if options.albums != nil {
+		var texts = make([]string, 0, len(options.albums))
+		for _, album := range options.albums {
+			texts = append(texts, album.String())
+		}
+		vectors, err := embedChunkedDocument(ctx, embedder, texts)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+
+		for i, album := range options.albums {
+			properties := albumProperties(album)
+			properties["fingerprint"] = albumFingerprint(album)
+			objs = append(objs, &models.Object{
+				Class:      albumCollectionName,
+				ID:         objectID(album.Server, album.SectionID, album.PlexID, album.RatingKey),
+				Properties: properties,
+				Vector:     vectors[i],
+			})
+		}
+	}
 
 	log.Println("start batch insert")
 	defer log.Println("batch done!")
@@ -155,7 +351,108 @@ func InsertData(ctx context.Context, embedder *ollama.LLM, opts ...InsertOption)
 	return nil
 }
 
-func QueryData(ctx context.Context, opts ...QueryOption) ([]*models.Object, error) {
+// videoProperties maps a video to the properties
+// declared on VideoClass.
+func videoProperties(video plex.VideoShort) map[string]any {
+	return map[string]any{
+		"title":                   video.Title,
+		"summary":                 video.Summary,
+		"content_rating":          video.ContentRating,
+		"plex_id":                 video.PlexID,
+		"type":                    video.Type,
+		"season_count":            video.SeasonCount,
+		"episode_count":           video.EpisodeCount,
+		"show_status":             video.ShowStatus,
+		"section_id":              video.SectionID,
+		"server":                  video.Server,
+		"year":                    video.Year,
+		"duration":                video.Duration,
+		"rating":                  video.Rating,
+		"audience_rating":         video.AudienceRating,
+		"originally_available_at": video.OriginallyAvailableAt,
+		"genres":                  video.Genres,
+		"directors":               video.Directors,
+		"writers":                 video.Writers,
+		"cast":                    video.Cast,
+		"countries":               video.Countries,
+	}
+}
+
+// objectNamespace is the namespace the IDs of stored objects
+// are derived in.
+var objectNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/wgeorgecook/plex-recommendation"))
+
+// objectID is the ID media is stored under. It's derived from the
+// server and section the media is in and its Plex GUID, or its
+// rating key when it has no GUID, so storing media again replaces
+// it rather than adding a duplicate.
+func objectID(server, sectionID, plexID string, ratingKey int) strfmt.UUID {
+	key := plexID
+	if key == "" {
+		key = "rating_key:" + strconv.Itoa(ratingKey)
+	}
+	return strfmt.UUID(uuid.NewSHA1(objectNamespace, []byte(server+"/"+sectionID+"/"+key)).String())
+}
+
+// videoFingerprint hashes the properties stored for a video,
+// so a stored video can be told apart from its current metadata.
+// A show's status is left out, along with the rest of what changes
+// as it's watched, so watching a video doesn't make it look changed.
+// The stored status is brought up to date whenever the show is
+// stored again.
+func videoFingerprint(video plex.VideoShort) string {
+	properties := videoProperties(video)
+	delete(properties, "show_status")
+	return fingerprint(properties)
+}
+
+// albumFingerprint hashes the properties stored for an album,
+// the same way videoFingerprint does for a video.
+func albumFingerprint(album plex.AlbumShort) string {
+	return fingerprint(albumProperties(album))
+}
+
+func fingerprint(properties map[string]any) string {
+	// maps are marshalled with their keys sorted, so
+	// the same properties always hash the same
+	encoded, _ := json.Marshal(properties)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// albumProperties maps an album to the properties
+// declared on AlbumClass.
+func albumProperties(album plex.AlbumShort) map[string]any {
+	return map[string]any{
+		"title":                   album.Title,
+		"artist":                  album.Artist,
+		"summary":                 album.Summary,
+		"plex_id":                 album.PlexID,
+		"artist_plex_id":          album.ArtistPlexID,
+		"section_id":              album.SectionID,
+		"server":                  album.Server,
+		"year":                    album.Year,
+		"originally_available_at": album.OriginallyAvailableAt,
+		"track_count":             album.TrackCount,
+		"genres":                  album.Genres,
+		"styles":                  album.Styles,
+		"moods":                   album.Moods,
+	}
+}
+
+// storedObject is what the sync needs to know about an object
+// already in the vector store to tell whether it has changed.
+type storedObject struct {
+	ID          strfmt.UUID
+	Server      string
+	SectionID   string
+	Fingerprint string
+}
+
+// QueryData lists the objects stored in a class, a page at a time.
+// Only their IDs and the properties that identify what they were
+// stored from are fetched, leaving their vectors in the store.
+func QueryData(ctx context.Context, opts ...QueryOption) ([]storedObject, error) {
 	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Query Data"))
 	defer span.End()
 	span.SetAttributes(attribute.String("package", "weaviate"))
@@ -176,25 +473,50 @@ func QueryData(ctx context.Context, opts ...QueryOption) ([]*models.Object, erro
 		limit = options.limit
 	}
 
-	allObjects := make([]*models.Object, 0)
+	fields := []graphql.Field{
+		{Name: "server"},
+		{Name: "section_id"},
+		{Name: "fingerprint"},
+		{Name: "_additional", Fields: []graphql.Field{{Name: "id"}}},
+	}
+	allObjects := make([]storedObject, 0)
 	after := ""
 	for {
-		getter := client.Data().ObjectsGetter().
+		getter := client.GraphQL().Get().
 			WithClassName(options.className).
-			WithLimit(limit).
-			WithVector()
+			WithFields(fields...).
+			WithLimit(limit)
 
 		if after != "" {
 			getter = getter.WithAfter(after)
 		}
 
-		result, err := getter.Do(ctx)
+		resp, err := getter.Do(ctx)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		if resp.Errors != nil {
+			var errs string
+			for _, err := range resp.Errors {
+				errs += err.Message + "\n"
+			}
+			span.RecordError(errors.New(errs))
+			return nil, errors.New(errs)
+		}
+
+		marshalled, err := resp.MarshalBinary()
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		result, err := decodeStoredObjects(marshalled, options.className)
 		if err != nil {
 			span.RecordError(err)
 			return nil, err
 		}
 		allObjects = append(allObjects, result...)
-		if len(result) <= limit {
+		if len(result) < limit {
 			break
 		}
 		after = result[len(result)-1].ID.String()
@@ -204,17 +526,70 @@ func QueryData(ctx context.Context, opts ...QueryOption) ([]*models.Object, erro
 	return allObjects, nil
 }
 
-func insertPlexMedia(ctx context.Context, c plex.Client, embedder *ollama.LLM) error {
-	log.Println("performing migration on load...")
+// decodeStoredObjects decodes a page of objects listed by QueryData.
+func decodeStoredObjects(marshalled []byte, className string) ([]storedObject, error) {
+	var toReturn struct {
+		Data struct {
+			Get map[string][]struct {
+				Server      string `json:"server"`
+				SectionID   string `json:"section_id"`
+				Fingerprint string `json:"fingerprint"`
+				Additional  struct {
+					ID strfmt.UUID `json:"id"`
+				} `json:"_additional"`
+			} `json:"Get"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(marshalled, &toReturn); err != nil {
+		return nil, err
+	}
+	found := toReturn.Data.Get[className]
+	objs := make([]storedObject, 0, len(found))
+	for _, obj := range found {
+		objs = append(objs, storedObject{
+			ID:          obj.Additional.ID,
+			Server:      obj.Server,
+			SectionID:   obj.SectionID,
+			Fingerprint: obj.Fingerprint,
+		})
+	}
+	return objs, nil
+}
+
+// insertPlexMedia ingests every movie, show and music section on each
+// Plex server, or only those in its LibrarySections if it has any.
+// Unless full is set, sections Plex hasn't updated since they were
+// last synced are skipped. A section that fails to sync doesn't stop
+// the others from syncing. Each section that syncs is saved to store,
+// if there is one.
+func insertPlexMedia(ctx context.Context, servers []PlexServer, ingest config.Ingest, full bool, store SyncStore, embedder *ollama.LLM) error {
+	log.Println("syncing libraries...")
 	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Insert Plex Media"))
 	defer span.End()
-	vids, err := plex.GetAllVideos(ctx, c, c.GetDefaultLibrarySection())
-	if err != nil {
-		span.RecordError(err)
-		return err
+	if len(servers) == 0 {
+		span.SetStatus(codes.Ok, "no Plex servers to ingest")
+		return nil
+	}
+
+	toSync := make([][]plex.Section, len(servers))
+	var pending int
+	for i, server := range servers {
+		sections, err := sectionsToSync(ctx, server, full)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		log.Println("found ", len(sections), " library sections to sync on ", server.Name)
+		span.SetAttributes(attribute.Int("sections."+server.Name, len(sections)))
+		toSync[i] = sections
+		pending += len(sections)
 	}
-	log.Println("got ", len(vids), " videos")
-	span.SetAttributes(attribute.Int("count", len(vids)))
+	if pending == 0 {
+		log.Println("no library sections have changed")
+		span.SetStatus(codes.Ok, "nothing to sync")
+		return nil
+	}
+
 	savedData, err := QueryData(ctx, WithClassName(VideoClass.Class), WithLimit(500))
 	if err != nil {
 		span.RecordError(err)
@@ -224,59 +599,435 @@ func insertPlexMedia(ctx context.Context, c plex.Client, embedder *ollama.LLM) e
 
 	log.Println("found ", len(savedData), " videos in the db")
 
-	// map for faster lookup when we check if a video is
-	// already saved
-	savedHm := make(map[string]strfmt.UUID, len(savedData))
-	for _, obj := range savedData {
-		summary := obj.Properties.(map[string]interface{})["summary"].(string)
-		savedHm[summary] = obj.ID
+	saved, untagged, unsectioned := fingerprints(savedData, servers[0].Name)
+	if err := tagServer(ctx, untagged, servers[0].Name); err != nil {
+		span.RecordError(err)
+		return err
 	}
-
-	toSave := make([]plex.VideoShort, 0, len(vids))
-	for _, vid := range vids {
-		if _, ok := savedHm[vid.Summary]; !ok {
-			// this video not found in the saved video
-			// map, so add it to the list of new media
-			// to save
-			toSave = append(toSave, vid)
+	if len(unsectioned) > 0 {
+		log.Println("removing ", len(unsectioned), " videos stored without a library section")
+		if err := deleteObjects(ctx, VideoClass.Class, unsectioned); err != nil {
+			span.RecordError(err)
+			return err
 		}
 	}
 
-	log.Println("found ", len(toSave), " videos to save")
-	if len(toSave) > 0 {
-		if err = InsertData(ctx, embedder, WithVideos(toSave)); err != nil {
+	savedAlbumData, err := QueryData(ctx, WithClassName(AlbumClass.Class), WithLimit(500))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	log.Println("found ", len(savedAlbumData), " albums in the db")
+	savedAlbums, _, unsectionedAlbums := fingerprints(savedAlbumData, servers[0].Name)
+	if len(unsectionedAlbums) > 0 {
+		log.Println("removing ", len(unsectionedAlbums), " albums stored without a library section")
+		if err := deleteObjects(ctx, AlbumClass.Class, unsectionedAlbums); err != nil {
 			span.RecordError(err)
 			return err
 		}
+	}
 
-		span.AddEvent("saved found diff data")
+	save := newPipeline("videos", ingest, func(ctx context.Context, videos []plex.VideoShort) error {
+		return InsertData(ctx, embedder, WithVideos(videos))
+	}).run
+	remove := func(ctx context.Context, ids []strfmt.UUID) error {
+		return deleteObjects(ctx, VideoClass.Class, ids)
+	}
+	saveAlbums := newPipeline("albums", ingest, func(ctx context.Context, albums []plex.AlbumShort) error {
+		return InsertData(ctx, embedder, WithAlbums(albums))
+	}).run
+	removeAlbums := func(ctx context.Context, ids []strfmt.UUID) error {
+		return deleteObjects(ctx, AlbumClass.Class, ids)
+	}
+	var errs []error
+	for i, server := range servers {
+		for _, section := range toSync[i] {
+			var err error
+			if section.IsMusic() {
+				err = insertAlbumSection(ctx, server.Name, server.Client, section, savedAlbums[sectionKey(server.Name, section.Key)], saveAlbums, removeAlbums)
+			} else {
+				err = insertSection(ctx, server.Name, server.Client, section, saved[sectionKey(server.Name, section.Key)], save, remove)
+			}
+			recordSectionSync(ctx, store, server.Name, section, time.Now(), err)
+			if err != nil {
+				log.Printf("could not sync section %s on %s: %s\n", section.Key, server.Name, err.Error())
+				span.RecordError(err)
+				errs = append(errs, err)
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
 	}
 
-	span.SetStatus(codes.Ok, "migration complete")
+	span.SetStatus(codes.Ok, "sync complete")
 
 	log.Println("complete")
 	return nil
 }
 
-func VectorQuery(ctx context.Context, collectionName string, vectors [][]float32) ([]*plex.VideoShort, error) {
+// tagServer records that the stored objects are
+// on the named Plex server.
+func tagServer(ctx context.Context, ids []strfmt.UUID, server string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	log.Println("tagging ", len(ids), " stored videos with Plex server ", server)
+	for _, id := range ids {
+		err := client.Data().Updater().
+			WithClassName(VideoClass.Class).
+			WithID(id.String()).
+			WithProperties(map[string]any{"server": server}).
+			WithMerge().
+			Do(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteObjects removes the objects with the provided IDs.
+func deleteObjects(ctx context.Context, className string, ids []strfmt.UUID) error {
+	log.Println("removing ", len(ids), " objects from ", className)
+	for _, id := range ids {
+		if err := client.Data().Deleter().WithClassName(className).WithID(id.String()).Do(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sectionKey identifies a library section on a Plex server.
+func sectionKey(server, sectionID string) string {
+	return server + "/" + sectionID
+}
+
+// fingerprints returns the fingerprint of each stored object by its
+// ID, grouped by the server and section it's in, to find what has
+// changed. Objects stored before servers were named are grouped
+// with defaultServer and their IDs are returned as untagged. The IDs
+// of objects stored before sections were recorded are returned as
+// unsectioned, since no section's sync would ever find them.
+func fingerprints(objs []storedObject, defaultServer string) (saved map[string]map[strfmt.UUID]string, untagged, unsectioned []strfmt.UUID) {
+	saved = make(map[string]map[strfmt.UUID]string)
+	for _, obj := range objs {
+		if obj.SectionID == "" {
+			unsectioned = append(unsectioned, obj.ID)
+			continue
+		}
+		server := obj.Server
+		if server == "" {
+			server = defaultServer
+			untagged = append(untagged, obj.ID)
+		}
+		key := sectionKey(server, obj.SectionID)
+		if saved[key] == nil {
+			saved[key] = make(map[strfmt.UUID]string)
+		}
+		saved[key][obj.ID] = obj.Fingerprint
+	}
+	return saved, untagged, unsectioned
+}
+
+// saveFunc stores videos in the vector store, replacing
+// any already stored under the same ID.
+type saveFunc func(context.Context, []plex.VideoShort) error
+
+// removeFunc removes the videos with the provided
+// IDs from the vector store.
+type removeFunc func(context.Context, []strfmt.UUID) error
+
+// insertSection syncs the section on the named server with the
+// vector store. saved holds the fingerprint of each video stored
+// for the section by its ID. The section is read a page at a time,
+// and each page's videos that aren't stored yet or whose metadata
+// has changed are saved before the next page is read. Once every
+// page is read, stored videos that are no longer in it are removed.
+// Videos that couldn't be saved don't stop the later pages from
+// being read, but the section isn't synced until they are saved, so
+// an error is still returned for them.
+func insertSection(ctx context.Context, server string, c plex.Client, section plex.Section, saved map[strfmt.UUID]string, save saveFunc, remove removeFunc) error {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Insert Section"), telemetry.WithSpanPackage("weaviate"))
+	defer span.End()
+	span.SetAttributes(attribute.String("server", server), attribute.String("section", section.Key))
+	log.Println("ingesting section ", section.Key, " (", section.Title, ")")
+	pager := plex.NewVideoPager(c, section.Key, plex.DefaultPageSize)
+	seenIDs := make(map[strfmt.UUID]bool, len(saved))
+	var seen, added, updated int
+	var notStored error
+	for pager.Next(ctx) {
+		vids := pager.Page()
+		seen += len(vids)
+		toSave := make([]plex.VideoShort, 0, len(vids))
+		for _, vid := range vids {
+			vid.Server = server
+			id := objectID(server, vid.SectionID, vid.PlexID, vid.RatingKey)
+			seenIDs[id] = true
+			fingerprint, ok := saved[id]
+			switch {
+			case !ok:
+				added++
+				toSave = append(toSave, vid)
+			case fingerprint != videoFingerprint(vid):
+				updated++
+				toSave = append(toSave, vid)
+			}
+		}
+		log.Println("found ", len(toSave), " videos to save out of ", len(vids), " on this page")
+		if len(toSave) == 0 {
+			continue
+		}
+		if err := save(ctx, toSave); err != nil {
+			span.RecordError(err)
+			if !errors.Is(err, errSomeNotStored) {
+				return err
+			}
+			notStored = errors.Join(notStored, err)
+			continue
+		}
+		span.AddEvent("saved page diff data")
+	}
+	if err := pager.Err(); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	gone := make([]strfmt.UUID, 0)
+	for id := range saved {
+		if !seenIDs[id] {
+			gone = append(gone, id)
+		}
+	}
+	if seen == 0 && len(gone) > 0 {
+		// more likely a problem reading the section
+		// than every video in it being deleted
+		log.Println("section ", section.Key, " is empty, keeping its ", len(gone), " stored videos")
+		gone = nil
+	}
+	if len(gone) > 0 {
+		if err := remove(ctx, gone); err != nil {
+			span.RecordError(err)
+			return err
+		}
+		span.AddEvent("removed videos no longer in the section")
+	}
+	span.SetAttributes(
+		attribute.Int("count", seen),
+		attribute.Int("added", added),
+		attribute.Int("updated", updated),
+		attribute.Int("removed", len(gone)),
+	)
+	if notStored != nil {
+		return notStored
+	}
+	span.SetStatus(codes.Ok, "section ingested")
+	return nil
+}
+
+// saveAlbumsFunc stores albums in the vector store.
+type saveAlbumsFunc func(context.Context, []plex.AlbumShort) error
+
+// insertAlbumSection syncs the music section on the named server
+// with the vector store, the same way insertSection does for videos.
+// saved holds the fingerprint of each album stored for the section
+// by its ID. Albums that couldn't be saved don't stop those that
+// left the section from being removed, but an error is returned for
+// them so the section is synced again.
+func insertAlbumSection(ctx context.Context, server string, c plex.Client, section plex.Section, saved map[strfmt.UUID]string, save saveAlbumsFunc, remove removeFunc) error {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Insert Album Section"), telemetry.WithSpanPackage("weaviate"))
+	defer span.End()
+	span.SetAttributes(attribute.String("server", server), attribute.String("section", section.Key))
+	log.Println("ingesting music section ", section.Key, " (", section.Title, ")")
+	albums, err := plex.GetAllAlbums(ctx, c, section.Key)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	seenIDs := make(map[strfmt.UUID]bool, len(albums))
+	toSave := make([]plex.AlbumShort, 0, len(albums))
+	var added, updated int
+	for _, album := range albums {
+		album.Server = server
+		id := objectID(server, album.SectionID, album.PlexID, album.RatingKey)
+		seenIDs[id] = true
+		fingerprint, ok := saved[id]
+		switch {
+		case !ok:
+			added++
+			toSave = append(toSave, album)
+		case fingerprint != albumFingerprint(album):
+			updated++
+			toSave = append(toSave, album)
+		}
+	}
+	log.Println("found ", len(toSave), " albums to save out of ", len(albums))
+	var notStored error
+	if len(toSave) > 0 {
+		if err := save(ctx, toSave); err != nil {
+			span.RecordError(err)
+			if !errors.Is(err, errSomeNotStored) {
+				return err
+			}
+			notStored = err
+		}
+	}
+
+	gone := make([]strfmt.UUID, 0)
+	for id := range saved {
+		if !seenIDs[id] {
+			gone = append(gone, id)
+		}
+	}
+	if len(albums) == 0 && len(gone) > 0 {
+		// more likely a problem reading the section
+		// than every album in it being deleted
+		log.Println("section ", section.Key, " is empty, keeping its ", len(gone), " stored albums")
+		gone = nil
+	}
+	if len(gone) > 0 {
+		if err := remove(ctx, gone); err != nil {
+			span.RecordError(err)
+			return err
+		}
+		span.AddEvent("removed albums no longer in the section")
+	}
+	span.SetAttributes(
+		attribute.Int("count", len(albums)),
+		attribute.Int("added", added),
+		attribute.Int("updated", updated),
+		attribute.Int("removed", len(gone)),
+	)
+	if notStored != nil {
+		return notStored
+	}
+	span.SetStatus(codes.Ok, "section ingested")
+	return nil
+}
+
+func VectorQuery(ctx context.Context, collectionName string, vectors [][]float32, opts ...QueryOption) ([]*plex.VideoShort, error) {
 	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Vector Query"))
 	defer span.End()
 	span.SetAttributes(attribute.String("package", "weaviate"))
-	nearVectorArgument := client.GraphQL().NearVectorArgBuilder()
-	for _, vector := range vectors {
-		nearVectorArgument.WithVector(vector)
+	var videos []*plex.VideoShort
+	distances, err := nearVector(ctx, collectionName, VideoClass.Properties, vectors, &videos, opts...)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
 	}
-	fields := []graphql.Field{
-		{Name: "title"},
-		{Name: "summary"},
-		{Name: "content_rating"},
-		{Name: "plex_id"},
+	for i, video := range videos {
+		video.Distance, video.Score = scores(distances[i])
 	}
-	resp, err := client.GraphQL().Get().WithClassName(collectionName).WithFields(fields...).WithNearVector(nearVectorArgument).Do(ctx)
+	span.SetAttributes(attribute.Int("count", len(videos)))
+	span.SetStatus(codes.Ok, "query successful")
+	return videos, nil
+}
+
+// AlbumVectorQuery finds the stored albums nearest to the vectors.
+// It takes the same options as VectorQuery.
+func AlbumVectorQuery(ctx context.Context, vectors [][]float32, opts ...QueryOption) ([]*plex.AlbumShort, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Album Vector Query"), telemetry.WithSpanPackage("weaviate"))
+	defer span.End()
+	var albums []*plex.AlbumShort
+	distances, err := nearVector(ctx, AlbumClass.Class, AlbumClass.Properties, vectors, &albums, opts...)
 	if err != nil {
 		span.RecordError(err)
 		return nil, err
 	}
+	for i, album := range albums {
+		album.Distance, album.Score = scores(distances[i])
+	}
+	span.SetStatus(codes.Ok, "query successful")
+	return albums, nil
+}
+
+// scores returns the distance of a result from what was searched
+// for, and how similar it is: 1 for the same, down to 0. Cosine
+// distances run from 0 to 2, so anything past 1 scores 0.
+func scores(distance float64) (*float64, *float64) {
+	score := max(0, 1-distance)
+	return &distance, &score
+}
+
+// nearVector queries the collection for the objects nearest to
+// the vectors, decodes their properties into results, and returns
+// how far each of them is from the vectors, in the same order. If
+// the maximum distance or autocut leave nothing, ErrNothingClose is
+// returned, unless the query was asked to fall back to running
+// again without them.
+func nearVector(ctx context.Context, collectionName string, properties []*models.Property, vectors [][]float32, results any, opts ...QueryOption) ([]float64, error) {
+	span := trace.SpanFromContext(ctx)
+	options := &queryOption{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	distances, err := queryNearVector(ctx, collectionName, properties, vectors, results, *options)
+	if err != nil || len(distances) > 0 || (options.maxDistance <= 0 && options.autocut <= 0) {
+		return distances, err
+	}
+	if !options.fallback {
+		span.RecordError(ErrNothingClose)
+		return nil, ErrNothingClose
+	}
+	log.Println("nothing was close enough to the history, searching again without a cut-off")
+	span.AddEvent("cut-off left no results")
+	uncut := *options
+	uncut.maxDistance, uncut.autocut = 0, 0
+	return queryNearVector(ctx, collectionName, properties, vectors, results, uncut)
+}
+
+// queryNearVector runs a single vector query for nearVector.
+func queryNearVector(ctx context.Context, collectionName string, properties []*models.Property, vectors [][]float32, results any, options queryOption) ([]float64, error) {
+	span := trace.SpanFromContext(ctx)
+	vector, err := weightedMean(vectors, options.weights)
+	if err != nil {
+		return nil, err
+	}
+	nearVectorArgument := client.GraphQL().NearVectorArgBuilder().WithVector(vector)
+	if options.maxDistance > 0 {
+		span.SetAttributes(attribute.Float64("maxDistance", float64(options.maxDistance)))
+		nearVectorArgument = nearVectorArgument.WithDistance(options.maxDistance)
+	}
+	fields := make([]graphql.Field, 0, len(properties)+1)
+	for _, prop := range properties {
+		fields = append(fields, graphql.Field{Name: prop.Name})
+	}
+	fields = append(fields, graphql.Field{Name: "_additional", Fields: []graphql.Field{{Name: "distance"}}})
+	getter := client.GraphQL().Get().WithClassName(collectionName).WithFields(fields...).WithNearVector(nearVectorArgument)
+	if options.limit > 0 {
+		span.SetAttributes(attribute.Int("limit", options.limit))
+		getter = getter.WithLimit(options.limit)
+	}
+	if options.autocut > 0 {
+		span.SetAttributes(attribute.Int("autocut", options.autocut))
+		getter = getter.WithAutocut(options.autocut)
+	}
+	var where []*filters.WhereBuilder
+	if options.sectionID != "" {
+		span.SetAttributes(attribute.String("section", options.sectionID))
+		where = append(where, filters.Where().
+			WithPath([]string{"section_id"}).
+			WithOperator(filters.Equal).
+			WithValueText(options.sectionID))
+	}
+	if options.server != "" {
+		span.SetAttributes(attribute.String("server", options.server))
+		where = append(where, filters.Where().
+			WithPath([]string{"server"}).
+			WithOperator(filters.Equal).
+			WithValueText(options.server))
+	}
+	switch len(where) {
+	case 0:
+	case 1:
+		getter = getter.WithWhere(where[0])
+	default:
+		getter = getter.WithWhere(filters.Where().WithOperator(filters.And).WithOperands(where))
+	}
+	resp, err := getter.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	span.AddEvent("query successful")
 
@@ -285,13 +1036,11 @@ func VectorQuery(ctx context.Context, collectionName string, vectors [][]float32
 		for _, err := range resp.Errors {
 			errs += err.Message + "\n"
 		}
-		span.RecordError(errors.New(errs))
 		return nil, errors.New(errs)
 	}
 
-	results, err := resp.MarshalBinary()
+	marshalled, err := resp.MarshalBinary()
 	if err != nil {
-		span.RecordError(err)
 		return nil, err
 	}
 
@@ -299,19 +1048,165 @@ func VectorQuery(ctx context.Context, collectionName string, vectors [][]float32
 
 	type marshalResults struct {
 		Data struct {
-			Get struct {
-				Videos []*plex.VideoShort `json:"Videos"`
-			} `json:"Get"`
+			Get map[string]json.RawMessage `json:"Get"`
 		} `json:"data"`
 	}
 
 	var toReturn marshalResults
-	if err := json.Unmarshal(results, &toReturn); err != nil {
+	if err := json.Unmarshal(marshalled, &toReturn); err != nil {
+		return nil, err
+	}
+	found, ok := toReturn.Data.Get[collectionName]
+	if !ok {
+		return nil, nil
+	}
+	return decodeNearVector(found, results)
+}
+
+// decodeNearVector decodes the objects found by a vector query into
+// results and returns their distances, in the same order.
+func decodeNearVector(found json.RawMessage, results any) ([]float64, error) {
+	if err := json.Unmarshal(found, results); err != nil {
+		return nil, err
+	}
+	var additional []struct {
+		Additional struct {
+			Distance float64 `json:"distance"`
+		} `json:"_additional"`
+	}
+	if err := json.Unmarshal(found, &additional); err != nil {
+		return nil, err
+	}
+	distances := make([]float64, 0, len(additional))
+	for _, a := range additional {
+		distances = append(distances, a.Additional.Distance)
+	}
+	return distances, nil
+}
+
+// weightedMean combines the vectors into one, each counting towards
+// it by its weight. The vectors are weighted equally when there are
+// no weights.
+func weightedMean(vectors [][]float32, weights []float64) ([]float32, error) {
+	if len(vectors) == 0 {
+		return nil, errors.New("no vectors to query with")
+	}
+	if weights != nil && len(weights) != len(vectors) {
+		return nil, fmt.Errorf("%d weights provided for %d vectors", len(weights), len(vectors))
+	}
+	sum := make([]float64, len(vectors[0]))
+	var total float64
+	for i, vector := range vectors {
+		if len(vector) != len(sum) {
+			return nil, fmt.Errorf("vector %d has %d dimensions, expected %d", i, len(vector), len(sum))
+		}
+		weight := 1.0
+		if weights != nil {
+			weight = weights[i]
+		}
+		for j, value := range vector {
+			sum[j] += weight * float64(value)
+		}
+		total += weight
+	}
+	if total <= 0 {
+		return nil, errors.New("vector weights must add up to more than zero")
+	}
+	mean := make([]float32, len(sum))
+	for j, value := range sum {
+		mean[j] = float32(value / total)
+	}
+	return mean, nil
+}
+
+// CountBySection returns the number of objects stored in the
+// provided class for each library section on the named Plex server.
+func CountBySection(ctx context.Context, collectionName, server string) (map[string]int, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Count By Section"), telemetry.WithSpanPackage("weaviate"))
+	defer span.End()
+	fields := []graphql.Field{
+		{Name: "groupedBy", Fields: []graphql.Field{{Name: "value"}}},
+		{Name: "meta", Fields: []graphql.Field{{Name: "count"}}},
+	}
+	where := filters.Where().WithPath([]string{"server"}).WithOperator(filters.Equal).WithValueText(server)
+	resp, err := client.GraphQL().Aggregate().WithClassName(collectionName).WithGroupBy("section_id").WithWhere(where).WithFields(fields...).Do(ctx)
+	if err != nil {
 		span.RecordError(err)
 		return nil, err
 	}
 
-	span.SetStatus(codes.Ok, "query successful")
+	if resp.Errors != nil {
+		var errs string
+		for _, err := range resp.Errors {
+			errs += err.Message + "\n"
+		}
+		span.RecordError(errors.New(errs))
+		return nil, errors.New(errs)
+	}
+
+	results, err := resp.MarshalBinary()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	type marshalResults struct {
+		Data struct {
+			Aggregate map[string][]struct {
+				GroupedBy struct {
+					Value string `json:"value"`
+				} `json:"groupedBy"`
+				Meta struct {
+					Count int `json:"count"`
+				} `json:"meta"`
+			} `json:"Aggregate"`
+		} `json:"data"`
+	}
+
+	var aggregated marshalResults
+	if err := json.Unmarshal(results, &aggregated); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, group := range aggregated.Data.Aggregate[collectionName] {
+		counts[group.GroupedBy.Value] = group.Meta.Count
+	}
+	span.SetStatus(codes.Ok, "count successful")
+	return counts, nil
+}
 
-	return toReturn.Data.Get.Videos, nil
+// VideoExists reports whether the video has already been
+// stored for its library section on its Plex server.
+func VideoExists(ctx context.Context, video plex.VideoShort) (bool, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Video Exists"), telemetry.WithSpanPackage("weaviate"))
+	defer span.End()
+	exists, err := client.Data().Checker().
+		WithClassName(VideoClass.Class).
+		WithID(objectID(video.Server, video.SectionID, video.PlexID, video.RatingKey).String()).
+		Do(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return false, err
+	}
+	span.SetStatus(codes.Ok, "existence checked")
+	return exists, nil
+}
+
+// AlbumExists reports whether the album has already been
+// stored for its library section on its Plex server.
+func AlbumExists(ctx context.Context, album plex.AlbumShort) (bool, error) {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Album Exists"), telemetry.WithSpanPackage("weaviate"))
+	defer span.End()
+	exists, err := client.Data().Checker().
+		WithClassName(AlbumClass.Class).
+		WithID(objectID(album.Server, album.SectionID, album.PlexID, album.RatingKey).String()).
+		Do(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return false, err
+	}
+	span.SetStatus(codes.Ok, "existence checked")
+	return exists, nil
 }
@@ -1,8 +1,13 @@
 package weaviate
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/grpc"
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/config"
 	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex"
 )
 
@@ -33,6 +38,31 @@ func TestQueryOptions(t *testing.T) {
 			options:  []QueryOption{WithClassName("image"), WithLimit(25)},
 			expected: queryOption{className: "image", limit: 25},
 		},
+		{
+			name:     "With Section ID",
+			options:  []QueryOption{WithSectionID("2")},
+			expected: queryOption{sectionID: "2"},
+		},
+		{
+			name:     "With Server",
+			options:  []QueryOption{WithSectionID("2"), WithServer("cabin")},
+			expected: queryOption{sectionID: "2", server: "cabin"},
+		},
+		{
+			name:     "With Weights",
+			options:  []QueryOption{WithWeights([]float64{2, 0.5})},
+			expected: queryOption{weights: []float64{2, 0.5}},
+		},
+		{
+			name:     "With Max Distance And Autocut",
+			options:  []QueryOption{WithLimit(20), WithMaxDistance(0.4), WithAutocut(2)},
+			expected: queryOption{limit: 20, maxDistance: 0.4, autocut: 2},
+		},
+		{
+			name:     "With Cut-off Fallback",
+			options:  []QueryOption{WithMaxDistance(0.4), WithCutoffFallback()},
+			expected: queryOption{maxDistance: 0.4, fallback: true},
+		},
 	}
 
 	for _, tc := range tests {
@@ -42,7 +72,7 @@ func TestQueryOptions(t *testing.T) {
 				opt(&qo)
 			}
 
-			if qo.className != tc.expected.className || qo.limit != tc.expected.limit {
+			if !reflect.DeepEqual(qo, tc.expected) {
 				t.Errorf("Expected: %v, Got: %v", tc.expected, qo)
 			}
 		})
@@ -96,3 +126,128 @@ func TestWithVideos(t *testing.T) {
 		})
 	}
 }
+
+func TestVideoPropertiesMatchSchema(t *testing.T) {
+	declared := make(map[string]bool, len(VideoClass.Properties))
+	for _, prop := range VideoClass.Properties {
+		declared[prop.Name] = true
+	}
+
+	for name := range videoProperties(plex.VideoShort{}) {
+		if !declared[name] {
+			t.Errorf("property %q is not declared on %s", name, VideoClass.Class)
+		}
+	}
+}
+
+func TestWeightedMean(t *testing.T) {
+	vectors := [][]float32{{1, 0}, {0, 1}}
+
+	testCases := []struct {
+		name     string
+		vectors  [][]float32
+		weights  []float64
+		expected []float32
+		wantErr  bool
+	}{
+		{name: "Equal Weights", vectors: vectors, expected: []float32{0.5, 0.5}},
+		{name: "Weighted", vectors: vectors, weights: []float64{3, 1}, expected: []float32{0.75, 0.25}},
+		{name: "Weight Count Mismatch", vectors: vectors, weights: []float64{1}, wantErr: true},
+		{name: "Zero Weights", vectors: vectors, weights: []float64{0, 0}, wantErr: true},
+		{name: "No Vectors", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := weightedMean(tc.vectors, tc.weights)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	cfg := clientConfig(config.Weaviate{})
+	if cfg.Host != "weaviate:8080" || cfg.Scheme != "http" || cfg.ConnectionClient != nil || cfg.GrpcConfig != nil {
+		t.Errorf("expected the docker compose Weaviate, got %+v", cfg)
+	}
+
+	cfg = clientConfig(config.Weaviate{
+		Host:     "vectors.example.com:8443",
+		Scheme:   "https",
+		APIKey:   "secret",
+		Headers:  map[string]string{"X-Team": "media"},
+		GRPCPort: "50051",
+		Timeout:  30 * time.Second,
+	})
+	expectedHeaders := map[string]string{"X-Team": "media", "Authorization": "Bearer secret"}
+	if !reflect.DeepEqual(cfg.Headers, expectedHeaders) {
+		t.Errorf("expected headers %v, got %v", expectedHeaders, cfg.Headers)
+	}
+	if cfg.ConnectionClient == nil || cfg.ConnectionClient.Timeout != 30*time.Second {
+		t.Errorf("expected requests to time out after 30s, got %+v", cfg.ConnectionClient)
+	}
+	expectedGrpc := &grpc.Config{Host: "vectors.example.com:50051", Secured: true}
+	if !reflect.DeepEqual(cfg.GrpcConfig, expectedGrpc) {
+		t.Errorf("expected gRPC config %+v, got %+v", expectedGrpc, cfg.GrpcConfig)
+	}
+}
+
+func TestDecodeNearVector(t *testing.T) {
+	found := json.RawMessage(`[
+		{"title": "Spirited Away", "rating_key": 10, "_additional": {"distance": 0.12}},
+		{"title": "Ponyo", "rating_key": 11, "_additional": {"distance": 0.3}}
+	]`)
+
+	var videos []plex.VideoShort
+	distances, err := decodeNearVector(found, &videos)
+	if err != nil {
+		t.Fatalf("could not decode results: %v", err)
+	}
+	expectedVideos := []plex.VideoShort{{Title: "Spirited Away", RatingKey: 10}, {Title: "Ponyo", RatingKey: 11}}
+	if !reflect.DeepEqual(videos, expectedVideos) {
+		t.Errorf("expected %+v, got %+v", expectedVideos, videos)
+	}
+	if expected := []float64{0.12, 0.3}; !reflect.DeepEqual(distances, expected) {
+		t.Errorf("expected distances %v, got %v", expected, distances)
+	}
+}
+
+func TestDecodeStoredObjects(t *testing.T) {
+	marshalled := []byte(`{"data": {"Get": {"Videos": [
+		{"server": "home", "section_id": "1", "fingerprint": "abc", "_additional": {"id": "9c0a7e6e-8a43-5b4e-9d2e-0f2b4c1d3e5f"}},
+		{"server": "", "section_id": "", "fingerprint": "", "_additional": {"id": "1f3e5d7c-2b4a-5c6d-8e9f-0a1b2c3d4e5f"}}
+	]}}}`)
+	objs, err := decodeStoredObjects(marshalled, "Videos")
+	if err != nil {
+		t.Fatalf("could not decode objects: %v", err)
+	}
+	expected := []storedObject{
+		{ID: "9c0a7e6e-8a43-5b4e-9d2e-0f2b4c1d3e5f", Server: "home", SectionID: "1", Fingerprint: "abc"},
+		{ID: "1f3e5d7c-2b4a-5c6d-8e9f-0a1b2c3d4e5f"},
+	}
+	if !reflect.DeepEqual(objs, expected) {
+		t.Errorf("expected %+v, got %+v", expected, objs)
+	}
+}
+
+func TestScores(t *testing.T) {
+	testCases := []struct {
+		distance float64
+		score    float64
+	}{
+		{distance: 0, score: 1},
+		{distance: 0.25, score: 0.75},
+		{distance: 1.5, score: 0},
+	}
+	for _, tc := range testCases {
+		distance, score := scores(tc.distance)
+		if *distance != tc.distance || *score != tc.score {
+			t.Errorf("expected %v to score %v, got %v, %v", tc.distance, tc.score, *distance, *score)
+		}
+	}
+}
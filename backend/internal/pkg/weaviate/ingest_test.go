@@ -0,0 +1,257 @@
+package weaviate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex"
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex/plextest"
+)
+
+func TestInsertSectionSyncsVideos(t *testing.T) {
+	items := make([]plextest.Item, 0, plex.DefaultPageSize+2)
+	for i := 0; i < plex.DefaultPageSize+2; i++ {
+		items = append(items, plextest.Item{
+			RatingKey: 1000 + i,
+			Guid:      fmt.Sprintf("plex://movie/%d", i),
+			Title:     "Movie",
+			// summaries no longer identify videos
+			Summary: "Same summary",
+		})
+	}
+	server := plextest.NewServer(plextest.WithSection(plextest.Section{Key: "1", Type: "movie", Title: "Movies", Items: items}))
+	defer server.Close()
+	c := plex.New(server.Token(), server.URL, "1")
+
+	// store everything but the last two movies as they are now
+	current, err := plex.GetAllVideos(context.Background(), c, "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	saved := make(map[strfmt.UUID]string)
+	for _, video := range current[:plex.DefaultPageSize] {
+		video.Server = "home"
+		saved[objectID("home", "1", video.PlexID, video.RatingKey)] = videoFingerprint(video)
+	}
+	// the first movie's metadata changed since it was stored
+	saved[objectID("home", "1", current[0].PlexID, current[0].RatingKey)] = "stale"
+	// and a movie that was stored has left the library
+	goneID := objectID("home", "1", "plex://movie/deleted", 0)
+	saved[goneID] = "gone"
+
+	var saves [][]string
+	save := func(ctx context.Context, videos []plex.VideoShort) error {
+		ids := make([]string, 0, len(videos))
+		for _, video := range videos {
+			if video.Server != "home" {
+				t.Errorf("expected videos tagged with server home, got %q", video.Server)
+			}
+			ids = append(ids, video.PlexID)
+		}
+		saves = append(saves, ids)
+		return nil
+	}
+	var removed []strfmt.UUID
+	remove := func(ctx context.Context, ids []strfmt.UUID) error {
+		removed = append(removed, ids...)
+		return nil
+	}
+
+	if err := insertSection(context.Background(), "home", c, plex.Section{Key: "1", Type: "movie"}, saved, save, remove); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// each page's changes are saved as the page is read
+	expected := [][]string{
+		{items[0].Guid},
+		{items[plex.DefaultPageSize].Guid, items[plex.DefaultPageSize+1].Guid},
+	}
+	if !reflect.DeepEqual(saves, expected) {
+		t.Errorf("expected saves %v, got %v", expected, saves)
+	}
+	if !reflect.DeepEqual(removed, []strfmt.UUID{goneID}) {
+		t.Errorf("expected only %s to be removed, got %v", goneID, removed)
+	}
+}
+
+func TestInsertSectionKeepsVideosOfEmptySection(t *testing.T) {
+	server := plextest.NewServer(plextest.WithSection(plextest.Section{Key: "1", Type: "movie", Title: "Movies"}))
+	defer server.Close()
+	c := plex.New(server.Token(), server.URL, "1")
+
+	saved := map[strfmt.UUID]string{objectID("home", "1", "plex://movie/a", 20): "stored"}
+	save := func(ctx context.Context, videos []plex.VideoShort) error {
+		t.Errorf("expected nothing to be saved, got %+v", videos)
+		return nil
+	}
+	remove := func(ctx context.Context, ids []strfmt.UUID) error {
+		t.Errorf("expected nothing to be removed, got %v", ids)
+		return nil
+	}
+	if err := insertSection(context.Background(), "home", c, plex.Section{Key: "1", Type: "movie"}, saved, save, remove); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestInsertSectionReportsVideosNotStored(t *testing.T) {
+	items := make([]plextest.Item, 0, plex.DefaultPageSize+1)
+	for i := 0; i < plex.DefaultPageSize+1; i++ {
+		items = append(items, plextest.Item{RatingKey: 1000 + i, Guid: fmt.Sprintf("plex://movie/%d", i), Title: "Movie"})
+	}
+	server := plextest.NewServer(plextest.WithSection(plextest.Section{Key: "1", Type: "movie", Title: "Movies", Items: items}))
+	defer server.Close()
+	c := plex.New(server.Token(), server.URL, "1")
+
+	var pages int
+	save := func(ctx context.Context, videos []plex.VideoShort) error {
+		pages++
+		if pages == 1 {
+			return fmt.Errorf("%w: 1 of the %d videos", errSomeNotStored, len(videos))
+		}
+		return nil
+	}
+	remove := func(ctx context.Context, ids []strfmt.UUID) error {
+		return nil
+	}
+	section := plex.Section{Key: "1", Type: "movie", UpdatedAt: 1716000900}
+	err := insertSection(context.Background(), "home", c, section, nil, save, remove)
+	if !errors.Is(err, errSomeNotStored) {
+		t.Fatalf("expected an error for the video that wasn't stored, got %v", err)
+	}
+	if pages != 2 {
+		t.Errorf("expected the next page to be saved anyway, got %d saves", pages)
+	}
+
+	resetSyncStatus(t)
+	recordSectionSync(context.Background(), nil, "home", section, time.Now(), err)
+	if s := sectionStatus[sectionKey("home", "1")]; s.SyncedUpdatedAt != 0 || s.LastError == "" {
+		t.Errorf("expected the section not to be marked as synced, got %+v", s)
+	}
+}
+
+func TestObjectID(t *testing.T) {
+	id := objectID("home", "1", "plex://movie/a", 20)
+	if id != objectID("home", "1", "plex://movie/a", 21) {
+		t.Errorf("expected the ID to come from the GUID when there is one")
+	}
+	others := []strfmt.UUID{
+		objectID("cabin", "1", "plex://movie/a", 20),
+		objectID("home", "2", "plex://movie/a", 20),
+		objectID("home", "1", "plex://movie/b", 20),
+		objectID("home", "1", "", 20),
+	}
+	for _, other := range others {
+		if other == id {
+			t.Errorf("expected media elsewhere to have its own ID, got %s for both", id)
+		}
+	}
+	if objectID("home", "1", "", 20) == objectID("home", "1", "", 21) {
+		t.Errorf("expected media without a GUID to be told apart by rating key")
+	}
+}
+
+func TestFingerprints(t *testing.T) {
+	objs := []storedObject{
+		{ID: "a", Server: "cabin", SectionID: "1", Fingerprint: "fa"},
+		{ID: "b", SectionID: "1", Fingerprint: "fb"},
+		{ID: "c", Server: "cabin"},
+		{ID: "d"},
+	}
+	saved, untagged, unsectioned := fingerprints(objs, "home")
+	expected := map[string]map[strfmt.UUID]string{
+		"cabin/1": {"a": "fa"},
+		"home/1":  {"b": "fb"},
+	}
+	if !reflect.DeepEqual(saved, expected) {
+		t.Errorf("expected %v, got %v", expected, saved)
+	}
+	if !reflect.DeepEqual(untagged, []strfmt.UUID{"b"}) {
+		t.Errorf("expected only b to need its server, got %v", untagged)
+	}
+	if !reflect.DeepEqual(unsectioned, []strfmt.UUID{"c", "d"}) {
+		t.Errorf("expected media without a section to be removed, got %v", unsectioned)
+	}
+}
+
+func TestInsertAlbumSectionSyncsAlbums(t *testing.T) {
+	server := plextest.NewServer(plextest.WithSection(plextest.Section{Key: "3", Type: "artist", Title: "Music", Items: []plextest.Item{
+		{RatingKey: 30, Guid: "plex://artist/radiohead", Title: "Radiohead", Albums: []plextest.Album{
+			{RatingKey: 31, Guid: "plex://album/ok-computer", Title: "OK Computer", Moods: []string{"Brooding"}},
+			{RatingKey: 34, Guid: "plex://album/in-rainbows", Title: "In Rainbows"},
+			{RatingKey: 35, Guid: "plex://album/kid-a", Title: "Kid A"},
+		}},
+	}}))
+	defer server.Close()
+	c := plex.New(server.Token(), server.URL, "1")
+
+	current, err := plex.GetAllAlbums(context.Background(), c, "3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	saved := make(map[strfmt.UUID]string)
+	for _, album := range current {
+		album.Server = "home"
+		saved[objectID("home", "3", album.PlexID, album.RatingKey)] = albumFingerprint(album)
+	}
+	// In Rainbows was stored before its metadata changed
+	saved[objectID("home", "3", "plex://album/in-rainbows", 34)] = "stale"
+	// Kid A hasn't been stored yet
+	delete(saved, objectID("home", "3", "plex://album/kid-a", 35))
+	// and an album that was stored has left the library
+	goneID := objectID("home", "3", "plex://album/deleted", 0)
+	saved[goneID] = "gone"
+
+	var savedAlbums []plex.AlbumShort
+	save := func(ctx context.Context, albums []plex.AlbumShort) error {
+		savedAlbums = append(savedAlbums, albums...)
+		return nil
+	}
+	var removed []strfmt.UUID
+	remove := func(ctx context.Context, ids []strfmt.UUID) error {
+		removed = append(removed, ids...)
+		return nil
+	}
+
+	if err := insertAlbumSection(context.Background(), "home", c, plex.Section{Key: "3", Type: "artist"}, saved, save, remove); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var titles []string
+	for _, album := range savedAlbums {
+		if album.Server != "home" || album.SectionID != "3" || album.Artist != "Radiohead" {
+			t.Errorf("unexpected album saved %+v", album)
+		}
+		titles = append(titles, album.Title)
+	}
+	if expected := []string{"In Rainbows", "Kid A"}; !reflect.DeepEqual(titles, expected) {
+		t.Errorf("expected %v to be saved, got %v", expected, titles)
+	}
+	if !reflect.DeepEqual(removed, []strfmt.UUID{goneID}) {
+		t.Errorf("expected only %s to be removed, got %v", goneID, removed)
+	}
+}
+
+func TestVideoFingerprintIgnoresViewers(t *testing.T) {
+	video := plex.VideoShort{Title: "The Office", PlexID: "plex://show/office", Type: "show", EpisodeCount: 201}
+	watched := video
+	watched.ShowStatus = "in_progress"
+	watched.ViewCount = 12
+	watched.LastViewedAt = 1716000300
+	watched.ViewOffset = 600000
+	watched.UserRating = 8
+	if videoFingerprint(video) != videoFingerprint(watched) {
+		t.Error("expected watching a video not to change its fingerprint")
+	}
+	if video.String() != watched.String() {
+		t.Error("expected watching a video not to change what's embedded")
+	}
+
+	changed := video
+	changed.EpisodeCount++
+	if videoFingerprint(video) == videoFingerprint(changed) {
+		t.Error("expected new episodes to change the fingerprint")
+	}
+}
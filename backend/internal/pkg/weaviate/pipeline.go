@@ -0,0 +1,258 @@
+package weaviate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/config"
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Defaults for how media is embedded and stored when ingesting.
+const (
+	defaultChunkSize  = 32
+	defaultWorkers    = 4
+	defaultRetries    = 3
+	defaultRetryDelay = time.Second
+	maxRetryDelay     = 30 * time.Second
+	// maxFailedChunks is how many chunks in a row can fail
+	// to store anything before a run gives up, since by
+	// then Weaviate or the embedder is likely down.
+	maxFailedChunks = 3
+)
+
+// errSomeNotStored is returned by a pipeline run that stored some of
+// its items but not all of them. What was stored is kept, but the
+// run isn't done until the rest are stored too.
+var errSomeNotStored = errors.New("some items could not be stored")
+
+// pipeline embeds and stores media a chunk at a time, with a
+// bounded number of chunks in flight. Each chunk is flushed to
+// Weaviate as soon as it's embedded, so an ingest that stops part
+// of the way through keeps what it stored. There's no checkpoint:
+// the next sync picks up from there because what was stored has a
+// matching fingerprint and is skipped.
+type pipeline[T any] struct {
+	// name is what's being stored, for logging.
+	name      string
+	chunkSize int
+	workers   int
+	// retries is how many more times a failed chunk
+	// is tried before it's split up.
+	retries    int
+	retryDelay time.Duration
+	// store embeds and stores a chunk.
+	store func(context.Context, []T) error
+}
+
+// newPipeline creates a pipeline that stores chunks with store,
+// using the defaults for anything in settings that isn't set.
+func newPipeline[T any](name string, settings config.Ingest, store func(context.Context, []T) error) pipeline[T] {
+	p := pipeline[T]{
+		name:       name,
+		chunkSize:  settings.ChunkSize,
+		workers:    settings.Workers,
+		retries:    settings.Retries,
+		retryDelay: defaultRetryDelay,
+		store:      store,
+	}
+	if p.chunkSize <= 0 {
+		p.chunkSize = defaultChunkSize
+	}
+	if p.workers <= 0 {
+		p.workers = defaultWorkers
+	}
+	if p.retries < 0 {
+		p.retries = defaultRetries
+	}
+	return p
+}
+
+// run stores every item. A chunk that keeps failing is stored an
+// item at a time so one bad item doesn't hold back the rest, and
+// items that still can't be stored are logged and left for the
+// next ingest. The run stops early with an error when ctx is
+// cancelled or maxFailedChunks chunks in a row store nothing. It
+// returns an error if none of the items could be stored, and one
+// wrapping errSomeNotStored if only some of them were.
+func (p pipeline[T]) run(ctx context.Context, items []T) error {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Run Ingest Pipeline"), telemetry.WithSpanPackage("weaviate"))
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("name", p.name),
+		attribute.Int("total", len(items)),
+		attribute.Int("chunkSize", p.chunkSize),
+		attribute.Int("workers", p.workers),
+	)
+	if len(items) == 0 {
+		span.SetStatus(codes.Ok, "nothing to store")
+		return nil
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	chunks := make(chan []T)
+	go func() {
+		defer close(chunks)
+		for start := 0; start < len(items); start += p.chunkSize {
+			select {
+			case chunks <- items[start:min(start+p.chunkSize, len(items))]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	prog := newProgress(p.name, len(items), time.Now())
+	var wg sync.WaitGroup
+	for range min(p.workers, (len(items)+p.chunkSize-1)/p.chunkSize) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				if ctx.Err() != nil {
+					return
+				}
+				stored, failed := p.storeChunk(ctx, chunk)
+				snapshot := prog.record(stored, failed, time.Now())
+				log.Println(snapshot)
+				if snapshot.failedChunks >= maxFailedChunks {
+					cancel(fmt.Errorf("giving up on storing %s after %d chunks in a row failed", p.name, snapshot.failedChunks))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	snapshot := prog.snapshot(time.Now())
+	span.SetAttributes(attribute.Int("stored", snapshot.done-snapshot.failed), attribute.Int("failed", snapshot.failed))
+	if ctx.Err() != nil {
+		err := context.Cause(ctx)
+		span.RecordError(err)
+		return err
+	}
+	if snapshot.failed == len(items) {
+		err := fmt.Errorf("could not store any of the %d %s", len(items), p.name)
+		span.RecordError(err)
+		return err
+	}
+	if snapshot.failed > 0 {
+		err := fmt.Errorf("%w: %d of the %d %s", errSomeNotStored, snapshot.failed, len(items), p.name)
+		span.RecordError(err)
+		return err
+	}
+	span.SetStatus(codes.Ok, "pipeline complete")
+	return nil
+}
+
+// storeChunk stores the chunk, retrying it if it fails, and then
+// falls back to storing its items one at a time. It returns how
+// many items were stored and how many weren't.
+func (p pipeline[T]) storeChunk(ctx context.Context, chunk []T) (int, int) {
+	err := p.storeWithRetries(ctx, chunk)
+	if err == nil {
+		return len(chunk), 0
+	}
+	if len(chunk) == 1 || ctx.Err() != nil {
+		log.Printf("could not store %d %s: %s\n", len(chunk), p.name, err.Error())
+		return 0, len(chunk)
+	}
+
+	log.Printf("could not store a chunk of %d %s, storing them one at a time: %s\n", len(chunk), p.name, err.Error())
+	var stored, failed int
+	for i := range chunk {
+		s, f := p.storeChunk(ctx, chunk[i:i+1])
+		stored += s
+		failed += f
+	}
+	return stored, failed
+}
+
+// storeWithRetries stores the chunk, trying again with
+// exponential backoff when it fails.
+func (p pipeline[T]) storeWithRetries(ctx context.Context, chunk []T) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = p.store(ctx, chunk); err == nil || attempt >= p.retries {
+			return err
+		}
+		delay := min(p.retryDelay<<attempt, maxRetryDelay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// progress tracks how far along a pipeline run is.
+type progress struct {
+	mu     sync.Mutex
+	name   string
+	total  int
+	done   int
+	failed int
+	// failedChunks is how many chunks in a
+	// row have stored nothing.
+	failedChunks int
+	started      time.Time
+}
+
+func newProgress(name string, total int, started time.Time) *progress {
+	return &progress{name: name, total: total, started: started}
+}
+
+// progressSnapshot is a pipeline's progress at one moment.
+type progressSnapshot struct {
+	name         string
+	total        int
+	done         int
+	failed       int
+	failedChunks int
+	// eta is how much longer the run should take
+	// at the rate it has gone so far.
+	eta time.Duration
+}
+
+func (s progressSnapshot) String() string {
+	msg := fmt.Sprintf("stored %d/%d %s", s.done-s.failed, s.total, s.name)
+	if s.failed > 0 {
+		msg += fmt.Sprintf(" (%d failed)", s.failed)
+	}
+	if s.done < s.total {
+		msg += fmt.Sprintf(", about %s left", s.eta.Round(time.Second))
+	}
+	return msg
+}
+
+// record adds a chunk's results and returns the
+// progress made so far.
+func (p *progress) record(stored, failed int, now time.Time) progressSnapshot {
+	p.mu.Lock()
+	p.done += stored + failed
+	p.failed += failed
+	if stored == 0 && failed > 0 {
+		p.failedChunks++
+	} else {
+		p.failedChunks = 0
+	}
+	p.mu.Unlock()
+	return p.snapshot(now)
+}
+
+func (p *progress) snapshot(now time.Time) progressSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := progressSnapshot{name: p.name, total: p.total, done: p.done, failed: p.failed, failedChunks: p.failedChunks}
+	if p.done > 0 && p.done < p.total {
+		perItem := now.Sub(p.started) / time.Duration(p.done)
+		s.eta = perItem * time.Duration(p.total-p.done)
+	}
+	return s
+}
@@ -0,0 +1,138 @@
+package weaviate
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/config"
+)
+
+func TestPipelineStoresInChunks(t *testing.T) {
+	var mu sync.Mutex
+	var stored []int
+	var inFlight, maxInFlight int
+	store := func(ctx context.Context, chunk []int) error {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		if len(chunk) > 3 {
+			t.Errorf("expected chunks of at most 3, got %v", chunk)
+		}
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		stored = append(stored, chunk...)
+		mu.Unlock()
+		return nil
+	}
+
+	items := make([]int, 20)
+	for i := range items {
+		items[i] = i
+	}
+	p := newPipeline("numbers", config.Ingest{ChunkSize: 3, Workers: 2}, store)
+	if err := p.run(context.Background(), items); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	slices.Sort(stored)
+	if !slices.Equal(stored, items) {
+		t.Errorf("expected every item to be stored, got %v", stored)
+	}
+	if maxInFlight > 2 {
+		t.Errorf("expected at most 2 chunks in flight, got %d", maxInFlight)
+	}
+}
+
+func TestPipelineRetriesAndIsolatesBadItems(t *testing.T) {
+	var mu sync.Mutex
+	attempts := make(map[int]int)
+	var stored []int
+	store := func(ctx context.Context, chunk []int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, item := range chunk {
+			attempts[item]++
+			// 3 is never stored, and 5 only on its second try
+			if item == 3 || (item == 5 && attempts[item] == 1) {
+				return errors.New("embedding failed")
+			}
+		}
+		stored = append(stored, chunk...)
+		return nil
+	}
+
+	p := newPipeline("numbers", config.Ingest{ChunkSize: 4, Workers: 1, Retries: 1}, store)
+	p.retryDelay = time.Millisecond
+	if err := p.run(context.Background(), []int{0, 1, 2, 3, 4, 5}); !errors.Is(err, errSomeNotStored) {
+		t.Fatalf("expected an error for the item that couldn't be stored, got %v", err)
+	}
+	slices.Sort(stored)
+	if expected := []int{0, 1, 2, 4, 5}; !slices.Equal(stored, expected) {
+		t.Errorf("expected %v to be stored, got %v", expected, stored)
+	}
+	// the first chunk is tried twice, then 3 on its own twice
+	if attempts[3] != 4 {
+		t.Errorf("expected 3 to be tried 4 times, got %d", attempts[3])
+	}
+}
+
+func TestPipelineStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := func(ctx context.Context, chunk []int) error {
+		cancel()
+		return errors.New("embedding failed")
+	}
+	p := newPipeline("numbers", config.Ingest{ChunkSize: 1, Workers: 1}, store)
+	if err := p.run(ctx, []int{1, 2, 3}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the run to be cancelled, got %v", err)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	started := time.Unix(1_700_000_000, 0)
+	p := newProgress("videos", 100, started)
+	snapshot := p.record(20, 5, started.Add(time.Minute))
+	if snapshot.done != 25 || snapshot.failed != 5 || snapshot.eta != 3*time.Minute {
+		t.Errorf("unexpected progress %+v", snapshot)
+	}
+	if expected := "stored 20/100 videos (5 failed), about 3m0s left"; snapshot.String() != expected {
+		t.Errorf("expected %q, got %q", expected, snapshot.String())
+	}
+	if done := p.record(75, 0, started.Add(2*time.Minute)); done.String() != "stored 95/100 videos (5 failed)" {
+		t.Errorf("unexpected finished progress %q", done.String())
+	}
+}
+
+func TestPipelineGivesUpOnRepeatedFailures(t *testing.T) {
+	var mu sync.Mutex
+	var tried int
+	store := func(ctx context.Context, chunk []int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		tried++
+		return errors.New("embedder is down")
+	}
+	p := newPipeline("numbers", config.Ingest{ChunkSize: 1, Workers: 1, Retries: 0}, store)
+	items := make([]int, 10)
+	if err := p.run(context.Background(), items); err == nil || errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the run to give up, got %v", err)
+	}
+	if tried != maxFailedChunks {
+		t.Errorf("expected %d chunks to be tried without retries, got %d", maxFailedChunks, tried)
+	}
+}
+
+func TestPipelineFailsWhenNothingIsStored(t *testing.T) {
+	store := func(ctx context.Context, chunk []int) error {
+		return errors.New("embedding failed")
+	}
+	p := newPipeline("numbers", config.Ingest{ChunkSize: 4, Workers: 1, Retries: 0}, store)
+	if err := p.run(context.Background(), []int{1, 2}); err == nil {
+		t.Error("expected an error when none of the items could be stored")
+	}
+}
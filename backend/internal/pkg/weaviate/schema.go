@@ -11,6 +11,7 @@ import (
 
 const (
 	videoCollectionName  = "Videos"
+	albumCollectionName  = "Albums"
 	cachedCollectionName = "RecommendationsCache"
 )
 
@@ -38,6 +39,168 @@ var VideoClass = models.Class{
 			Description: "Plex GUID associated to the video",
 			DataType:    []string{"text"},
 		},
+		{
+			Name:        "type",
+			Description: "Plex media type, either movie or show",
+			DataType:    []string{"text"},
+		},
+		{
+			Name:        "season_count",
+			Description: "number of seasons in a show",
+			DataType:    []string{"int"},
+		},
+		{
+			Name:        "episode_count",
+			Description: "number of episodes in a show",
+			DataType:    []string{"int"},
+		},
+		{
+			Name:        "show_status",
+			Description: "how much of a show has been watched",
+			DataType:    []string{"text"},
+		},
+		{
+			Name:        "section_id",
+			Description: "Plex library section the video belongs to",
+			DataType:    []string{"text"},
+		},
+		{
+			Name:        "server",
+			Description: "name of the Plex server the video is on",
+			DataType:    []string{"text"},
+		},
+		{
+			Name:        "year",
+			Description: "year the video was released",
+			DataType:    []string{"int"},
+		},
+		{
+			Name:        "duration",
+			Description: "runtime of the video in milliseconds",
+			DataType:    []string{"int"},
+		},
+		{
+			Name:        "rating",
+			Description: "critic rating out of 10",
+			DataType:    []string{"number"},
+		},
+		{
+			Name:        "audience_rating",
+			Description: "audience rating out of 10",
+			DataType:    []string{"number"},
+		},
+		{
+			Name:        "originally_available_at",
+			Description: "release date of the video",
+			DataType:    []string{"text"},
+		},
+		{
+			Name:        "genres",
+			Description: "genres the video belongs to",
+			DataType:    []string{"text[]"},
+		},
+		{
+			Name:        "directors",
+			Description: "directors of the video",
+			DataType:    []string{"text[]"},
+		},
+		{
+			Name:        "writers",
+			Description: "writers of the video",
+			DataType:    []string{"text[]"},
+		},
+		{
+			Name:        "cast",
+			Description: "actors appearing in the video",
+			DataType:    []string{"text[]"},
+		},
+		{
+			Name:        "countries",
+			Description: "countries the video was produced in",
+			DataType:    []string{"text[]"},
+		},
+		{
+			Name:        "fingerprint",
+			Description: "hash of the video's other properties, to tell when its metadata changes",
+			DataType:    []string{"text"},
+		},
+	},
+}
+
+var AlbumClass = models.Class{
+	Class:       albumCollectionName,
+	Description: "Schema for holding vectorized Plex album data",
+	Properties: []*models.Property{
+		{
+			Name:        "title",
+			Description: "title of the album",
+			DataType:    []string{"text"},
+		},
+		{
+			Name:        "artist",
+			Description: "name of the artist the album is by",
+			DataType:    []string{"text"},
+		},
+		{
+			Name:        "summary",
+			Description: "description of the album",
+			DataType:    []string{"text"},
+		},
+		{
+			Name:        "plex_id",
+			Description: "Plex GUID associated to the album",
+			DataType:    []string{"text"},
+		},
+		{
+			Name:        "artist_plex_id",
+			Description: "Plex GUID associated to the artist",
+			DataType:    []string{"text"},
+		},
+		{
+			Name:        "section_id",
+			Description: "Plex library section the album belongs to",
+			DataType:    []string{"text"},
+		},
+		{
+			Name:        "server",
+			Description: "name of the Plex server the album is on",
+			DataType:    []string{"text"},
+		},
+		{
+			Name:        "year",
+			Description: "year the album was released",
+			DataType:    []string{"int"},
+		},
+		{
+			Name:        "originally_available_at",
+			Description: "release date of the album",
+			DataType:    []string{"text"},
+		},
+		{
+			Name:        "track_count",
+			Description: "number of tracks on the album",
+			DataType:    []string{"int"},
+		},
+		{
+			Name:        "genres",
+			Description: "genres the album belongs to",
+			DataType:    []string{"text[]"},
+		},
+		{
+			Name:        "styles",
+			Description: "styles of music on the album",
+			DataType:    []string{"text[]"},
+		},
+		{
+			Name:        "moods",
+			Description: "moods the album is tagged with",
+			DataType:    []string{"text[]"},
+		},
+		{
+			Name:        "fingerprint",
+			Description: "hash of the album's other properties, to tell when its metadata changes",
+			DataType:    []string{"text"},
+		},
 	},
 }
 
@@ -50,7 +213,11 @@ func createSchemaIfNotExists(ctx context.Context, class *models.Class) error {
 	}
 
 	if ok {
-		log.Println("class exists, exiting")
+		log.Println("class exists, checking for new properties")
+		if err := addMissingProperties(ctx, class); err != nil {
+			span.RecordError(err)
+			return err
+		}
 		span.SetStatus(codes.Ok, "class exists")
 		return nil
 	}
@@ -65,3 +232,34 @@ func createSchemaIfNotExists(ctx context.Context, class *models.Class) error {
 
 	return nil
 }
+
+// addMissingProperties adds any properties declared on class that
+// the stored schema doesn't have yet, so that classes created by an
+// older version of the app pick up new fields.
+func addMissingProperties(ctx context.Context, class *models.Class) error {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Add Missing Properties"), telemetry.WithSpanPackage("weaviate"))
+	defer span.End()
+	existing, err := client.Schema().ClassGetter().WithClassName(class.Class).Do(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	stored := make(map[string]bool, len(existing.Properties))
+	for _, prop := range existing.Properties {
+		stored[prop.Name] = true
+	}
+
+	for _, prop := range class.Properties {
+		if stored[prop.Name] {
+			continue
+		}
+		log.Println("adding property ", prop.Name, " to ", class.Class)
+		if err := client.Schema().PropertyCreator().WithClassName(class.Class).WithProperty(prop).Do(ctx); err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+	span.SetStatus(codes.Ok, "properties up to date")
+	return nil
+}
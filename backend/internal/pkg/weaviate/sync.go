@@ -0,0 +1,248 @@
+package weaviate
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex"
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// ErrSyncRunning is returned when a sync is asked
+// for while another one is still running.
+var ErrSyncRunning = errors.New("a library sync is already running")
+
+var (
+	// syncMu is held while a sync runs
+	syncMu sync.Mutex
+	// statusMu guards status and sectionStatus
+	statusMu      sync.RWMutex
+	status        SyncStatus
+	sectionStatus = make(map[string]SectionSyncStatus)
+)
+
+// SyncStatus describes the syncing of Plex
+// libraries into the vector store.
+type SyncStatus struct {
+	Running      bool      `json:"running"`
+	LastStarted  time.Time `json:"last_started,omitempty"`
+	LastFinished time.Time `json:"last_finished,omitempty"`
+	// LastError is why the last sync failed, if it did.
+	LastError string              `json:"last_error,omitempty"`
+	Sections  []SectionSyncStatus `json:"sections"`
+}
+
+// SectionSyncStatus describes when a library section
+// was last synced.
+type SectionSyncStatus struct {
+	Server  string `json:"server"`
+	Section string `json:"section"`
+	Title   string `json:"title"`
+	// UpdatedAt is when Plex last updated the section,
+	// as of the last time it was checked.
+	UpdatedAt int64 `json:"updated_at"`
+	// SyncedUpdatedAt is the section's UpdatedAt when it was last
+	// synced. The section is only synced again once they differ.
+	SyncedUpdatedAt int64     `json:"synced_updated_at,omitempty"`
+	SyncedAt        time.Time `json:"synced_at,omitempty"`
+	LastError       string    `json:"last_error,omitempty"`
+}
+
+// SyncStore persists when each library section was last synced,
+// so sections Plex hasn't updated since aren't synced again after
+// a restart.
+type SyncStore interface {
+	// LoadSectionSyncs returns every section synced before.
+	LoadSectionSyncs(ctx context.Context) ([]SectionSyncStatus, error)
+	// SaveSectionSync records that the section was synced.
+	SaveSectionSync(ctx context.Context, s SectionSyncStatus) error
+}
+
+// WithSyncStore persists when each section was last synced
+// in store. Without it, every section is synced again after
+// a restart.
+func WithSyncStore(store SyncStore) InitOption {
+	return func(i *initOption) {
+		i.store = store
+	}
+}
+
+// WithFullSync syncs every section, not only
+// those Plex has updated since they were last synced.
+func WithFullSync() InitOption {
+	return func(i *initOption) {
+		i.full = true
+	}
+}
+
+// Sync brings the vector store up to date with the sections of the
+// Plex servers passed with WithPlexServer, and blocks until it's
+// done. Sections Plex hasn't updated since they were last synced
+// are skipped unless WithFullSync is passed. It returns
+// ErrSyncRunning if a sync is already running.
+func Sync(ctx context.Context, embedder *ollama.LLM, opts ...InitOption) error {
+	if !syncMu.TryLock() {
+		return ErrSyncRunning
+	}
+	defer syncMu.Unlock()
+	return runSync(ctx, embedder, opts...)
+}
+
+// StartSync starts a sync like Sync does, but runs it in the
+// background. It returns ErrSyncRunning if a sync is already
+// running, and otherwise once the sync has started.
+func StartSync(ctx context.Context, embedder *ollama.LLM, opts ...InitOption) error {
+	if !syncMu.TryLock() {
+		return ErrSyncRunning
+	}
+	setRunning(time.Now())
+	go func() {
+		defer syncMu.Unlock()
+		if err := runSync(ctx, embedder, opts...); err != nil {
+			log.Println("could not sync libraries: ", err.Error())
+		}
+	}()
+	return nil
+}
+
+// GetSyncStatus returns the status of syncing
+// Plex libraries into the vector store.
+func GetSyncStatus() SyncStatus {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+	s := status
+	s.Sections = make([]SectionSyncStatus, 0, len(sectionStatus))
+	for _, section := range sectionStatus {
+		s.Sections = append(s.Sections, section)
+	}
+	slices.SortFunc(s.Sections, func(a, b SectionSyncStatus) int {
+		return strings.Compare(sectionKey(a.Server, a.Section), sectionKey(b.Server, b.Section))
+	})
+	return s
+}
+
+// runSync syncs the libraries, recording its status.
+// The caller must hold syncMu.
+func runSync(ctx context.Context, embedder *ollama.LLM, opts ...InitOption) error {
+	ctx, span := telemetry.StartSpan(ctx, telemetry.WithSpanName("Sync Libraries"), telemetry.WithSpanPackage("weaviate"))
+	defer span.End()
+	options := newInitOption(opts...)
+	span.SetAttributes(attribute.Bool("full", options.full))
+
+	setRunning(time.Now())
+	if options.store != nil {
+		if err := loadSectionSyncs(ctx, options.store); err != nil {
+			// the sections are compared with Plex from
+			// scratch, which only costs time
+			log.Println("could not load when sections were last synced: ", err.Error())
+			span.RecordError(err)
+		}
+	}
+	err := insertPlexMedia(ctx, options.servers, options.ingest, options.full, options.store, embedder)
+
+	statusMu.Lock()
+	status.Running = false
+	status.LastFinished = time.Now()
+	status.LastError = ""
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	statusMu.Unlock()
+
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	span.SetStatus(codes.Ok, "libraries synced")
+	return nil
+}
+
+// setRunning records that a sync started.
+func setRunning(started time.Time) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	if !status.Running {
+		status.Running = true
+		status.LastStarted = started
+	}
+}
+
+// sectionsToSync returns the ingestible sections on the server.
+// Unless full is set, sections whose UpdatedAt hasn't changed
+// since they were last synced are left out.
+func sectionsToSync(ctx context.Context, server PlexServer, full bool) ([]plex.Section, error) {
+	sections, err := plex.GetLibrarySections(ctx, server.Client)
+	if err != nil {
+		return nil, err
+	}
+	sections = plex.FilterSections(sections, server.LibrarySections)
+
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	toSync := make([]plex.Section, 0, len(sections))
+	for _, section := range sections {
+		key := sectionKey(server.Name, section.Key)
+		s := sectionStatus[key]
+		s.Server, s.Section, s.Title, s.UpdatedAt = server.Name, section.Key, section.Title, section.UpdatedAt
+		sectionStatus[key] = s
+		// Plex always reports when a section was updated, so
+		// one without it is synced every time to be safe
+		if !full && section.UpdatedAt != 0 && s.SyncedUpdatedAt == section.UpdatedAt {
+			continue
+		}
+		toSync = append(toSync, section)
+	}
+	return toSync, nil
+}
+
+// loadSectionSyncs fills in when sections were last synced from
+// the store, for those the sync status doesn't know about yet.
+func loadSectionSyncs(ctx context.Context, store SyncStore) error {
+	synced, err := store.LoadSectionSyncs(ctx)
+	if err != nil {
+		return err
+	}
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	for _, s := range synced {
+		key := sectionKey(s.Server, s.Section)
+		if _, ok := sectionStatus[key]; ok {
+			continue
+		}
+		sectionStatus[key] = s
+	}
+	return nil
+}
+
+// recordSectionSync records how syncing the section went, and
+// saves it to store if the section synced and there is one.
+func recordSectionSync(ctx context.Context, store SyncStore, server string, section plex.Section, syncedAt time.Time, err error) {
+	statusMu.Lock()
+	key := sectionKey(server, section.Key)
+	s := sectionStatus[key]
+	s.LastError = ""
+	if err != nil {
+		s.LastError = err.Error()
+	} else {
+		s.SyncedUpdatedAt = section.UpdatedAt
+		s.SyncedAt = syncedAt
+	}
+	sectionStatus[key] = s
+	statusMu.Unlock()
+
+	if err != nil || store == nil {
+		return
+	}
+	if err := store.SaveSectionSync(ctx, s); err != nil {
+		// the section is only synced again after a restart
+		log.Printf("could not save that section %s on %s synced: %s\n", section.Key, server, err.Error())
+	}
+}
@@ -0,0 +1,158 @@
+package weaviate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex"
+	"github.com/wgeorgecook/plex-recommendation/internal/pkg/plex/plextest"
+)
+
+// resetSyncStatus forgets every sync for the duration of the test.
+func resetSyncStatus(t *testing.T) {
+	t.Helper()
+	previousStatus, previousSections := status, sectionStatus
+	t.Cleanup(func() {
+		status, sectionStatus = previousStatus, previousSections
+	})
+	status, sectionStatus = SyncStatus{}, make(map[string]SectionSyncStatus)
+}
+
+func newSyncTestServer(t *testing.T, showsUpdatedAt int64) PlexServer {
+	t.Helper()
+	server := plextest.NewServer(
+		plextest.WithSection(plextest.Section{Key: "1", Type: "movie", Title: "Movies", UpdatedAt: 1716000000}),
+		plextest.WithSection(plextest.Section{Key: "2", Type: "show", Title: "TV Shows", UpdatedAt: showsUpdatedAt}),
+	)
+	t.Cleanup(server.Close)
+	return PlexServer{Name: "home", Client: plex.New(server.Token(), server.URL, "1")}
+}
+
+func sectionKeys(sections []plex.Section) []string {
+	keys := make([]string, 0, len(sections))
+	for _, section := range sections {
+		keys = append(keys, section.Key)
+	}
+	return keys
+}
+
+func TestSectionsToSync(t *testing.T) {
+	resetSyncStatus(t)
+	ctx := context.Background()
+	server := newSyncTestServer(t, 1716000001)
+
+	sections, err := sectionsToSync(ctx, server, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if keys := sectionKeys(sections); len(keys) != 2 {
+		t.Fatalf("expected every section to be synced the first time, got %v", keys)
+	}
+	syncedAt := time.Unix(1716000500, 0)
+	for _, section := range sections {
+		recordSectionSync(ctx, nil, server.Name, section, syncedAt, nil)
+	}
+
+	if sections, _ := sectionsToSync(ctx, server, false); len(sections) != 0 {
+		t.Errorf("expected no sections to sync when Plex hasn't updated them, got %v", sectionKeys(sections))
+	}
+	if sections, _ := sectionsToSync(ctx, server, true); len(sections) != 2 {
+		t.Errorf("expected a full sync to sync every section, got %v", sectionKeys(sections))
+	}
+
+	// Plex updates the TV shows, which fail to sync
+	updated := newSyncTestServer(t, 1716000900)
+	sections, _ = sectionsToSync(ctx, updated, false)
+	if keys := sectionKeys(sections); len(keys) != 1 || keys[0] != "2" {
+		t.Fatalf("expected only the updated section to sync, got %v", keys)
+	}
+	recordSectionSync(ctx, nil, updated.Name, sections[0], syncedAt.Add(time.Hour), errors.New("Plex went away"))
+	if sections, _ := sectionsToSync(ctx, updated, false); len(sections) != 1 {
+		t.Errorf("expected a section that failed to sync to be synced again, got %v", sectionKeys(sections))
+	}
+
+	s := GetSyncStatus()
+	expected := []SectionSyncStatus{
+		{Server: "home", Section: "1", Title: "Movies", UpdatedAt: 1716000000, SyncedUpdatedAt: 1716000000, SyncedAt: syncedAt},
+		{Server: "home", Section: "2", Title: "TV Shows", UpdatedAt: 1716000900, SyncedUpdatedAt: 1716000001, SyncedAt: syncedAt, LastError: "Plex went away"},
+	}
+	if len(s.Sections) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, s.Sections)
+	}
+	for i := range expected {
+		if s.Sections[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], s.Sections[i])
+		}
+	}
+}
+
+func TestSyncWhileRunning(t *testing.T) {
+	resetSyncStatus(t)
+	syncMu.Lock()
+	t.Cleanup(syncMu.Unlock)
+	if err := Sync(context.Background(), nil); !errors.Is(err, ErrSyncRunning) {
+		t.Errorf("expected %v, got %v", ErrSyncRunning, err)
+	}
+	if err := StartSync(context.Background(), nil); !errors.Is(err, ErrSyncRunning) {
+		t.Errorf("expected %v, got %v", ErrSyncRunning, err)
+	}
+}
+
+func TestSyncWithoutServers(t *testing.T) {
+	resetSyncStatus(t)
+	if err := Sync(context.Background(), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := GetSyncStatus()
+	if s.Running || s.LastStarted.IsZero() || s.LastFinished.IsZero() || s.LastError != "" {
+		t.Errorf("expected a finished sync, got %+v", s)
+	}
+}
+
+// memorySyncStore keeps section syncs in memory.
+type memorySyncStore struct {
+	synced map[string]SectionSyncStatus
+}
+
+func (m *memorySyncStore) LoadSectionSyncs(ctx context.Context) ([]SectionSyncStatus, error) {
+	synced := make([]SectionSyncStatus, 0, len(m.synced))
+	for _, s := range m.synced {
+		synced = append(synced, s)
+	}
+	return synced, nil
+}
+
+func (m *memorySyncStore) SaveSectionSync(ctx context.Context, s SectionSyncStatus) error {
+	m.synced[sectionKey(s.Server, s.Section)] = s
+	return nil
+}
+
+func TestSectionSyncsSurviveRestart(t *testing.T) {
+	resetSyncStatus(t)
+	ctx := context.Background()
+	server := newSyncTestServer(t, 1716000001)
+	store := &memorySyncStore{synced: make(map[string]SectionSyncStatus)}
+
+	sections, err := sectionsToSync(ctx, server, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, section := range sections {
+		recordSectionSync(ctx, store, server.Name, section, time.Unix(1716000500, 0), nil)
+	}
+	recordSectionSync(ctx, store, server.Name, plex.Section{Key: "3"}, time.Unix(1716000500, 0), errors.New("Plex went away"))
+	if len(store.synced) != 2 {
+		t.Fatalf("expected only the sections that synced to be saved, got %+v", store.synced)
+	}
+
+	// restarting forgets what was synced
+	resetSyncStatus(t)
+	if err := loadSectionSyncs(ctx, store); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sections, _ := sectionsToSync(ctx, server, false); len(sections) != 0 {
+		t.Errorf("expected no sections to sync after a restart, got %v", sectionKeys(sections))
+	}
+}